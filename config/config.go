@@ -68,12 +68,13 @@ func Configure(obj interface{}, file string, metadata ...interface{}) (err error
 
 // Configuration 服务配置
 type Configurations struct {
-	App      *iris.Configuration      // Application配置
-	DB       *DBConfiguration         // Database配置
-	Redis    *RedisConfiguration      // Redis配置
-	MQ       *MQConfiguration         // MQ配置
-	DS       *DepSvcConfiguration     // 依赖的第三方服务配置
-	RateRule []*RateRuleConfiguration // 限流配置
+	App         *iris.Configuration         // Application配置
+	DB          *DBConfiguration            // Database配置
+	DataSources map[string]*DBConfiguration // 命名数据源配置 key为InstallDB的数据源名称
+	Redis       *RedisConfiguration         // Redis配置
+	MQ          *MQConfiguration            // MQ配置
+	DS          *DepSvcConfiguration        // 依赖的第三方服务配置
+	RateRule    []*RateRuleConfiguration    // 限流配置
 }
 
 // NewConfiguration 初始化默认配置
@@ -141,25 +142,40 @@ func NewConfiguration() *Configurations {
 	return configuration
 }
 
+// DataSource 返回命名数据源的配置，name 为空、default 或未配置时返回 DB
+func (c *Configurations) DataSource(name string) *DBConfiguration {
+	if conf, ok := c.DataSources[name]; ok && conf != nil {
+		return conf
+	}
+	return c.DB
+}
+
 type DBConfiguration struct {
-	Host         string      `yaml:"db_host"`
-	Port         int         `yaml:"db_port"`
-	Type         string      `yaml:"db_type"` // 类型 mysql dm8
-	User         string      `yaml:"user_name"`
-	Pwd          string      `yaml:"user_pwd"`
-	DBName       string      `yaml:"db_name"`
-	Charset      string      `yaml:"db_charset"`
-	MaxOpenConns int         `yaml:"max_open_conns"` // 允许打开的最大连接数
-	MaxIdleConns int         `yaml:"max_idle_conns"` // 连接池里的空闲连接数
-	Timeout      int         `yaml:"timeout"`        // 连接超时时间 单位毫秒
-	ReadTimeout  int         `yaml:"read_timeout"`   // 读超时时间
-	WriteTimeout int         `yaml:"write_timeout"`  // 写超时时间
-	Driver       string      `yaml:"driver"`         // 驱动 proton-rds: proton提供的 sqlite3: 单元测试用
-	Timezone     string      `yaml:"timezone"`
-	ParseTime    bool        `yaml:"parse_time"`    // 支持把数据库datetime和date类型转换为golang的time.Time类型
-	PrintSqlLog  bool        `yaml:"print_sql_log"` // 慢sql时间,单位毫秒,超过这个时间会打印sql
-	SlowSqlTime  int         `yaml:"slow_sql_time"` // 是否打印sql, 配合慢sql使用 单位毫秒
-	Other        interface{} `yaml:"Other"`
+	Host         string `yaml:"db_host"`
+	Port         int    `yaml:"db_port"`
+	Type         string `yaml:"db_type"` // 类型 mysql postgres dm8
+	User         string `yaml:"user_name"`
+	Pwd          string `yaml:"user_pwd"`
+	DBName       string `yaml:"db_name"`
+	Charset      string `yaml:"db_charset"`
+	MaxOpenConns int    `yaml:"max_open_conns"` // 允许打开的最大连接数
+	MaxIdleConns int    `yaml:"max_idle_conns"` // 连接池里的空闲连接数
+	Timeout      int    `yaml:"timeout"`        // 连接超时时间 单位毫秒
+	ReadTimeout  int    `yaml:"read_timeout"`   // 读超时时间
+	WriteTimeout int    `yaml:"write_timeout"`  // 写超时时间
+	Driver       string `yaml:"driver"`         // 驱动 proton-rds: proton提供的 sqlite3: 单元测试用
+	Timezone     string `yaml:"timezone"`
+	ParseTime    bool   `yaml:"parse_time"`    // 支持把数据库datetime和date类型转换为golang的time.Time类型
+	PrintSqlLog  bool   `yaml:"print_sql_log"` // 慢sql时间,单位毫秒,超过这个时间会打印sql
+	SlowSqlTime  int    `yaml:"slow_sql_time"` // 是否打印sql, 配合慢sql使用 单位毫秒
+	QueryTimeout int    `yaml:"query_timeout"` // 每条sql的默认超时时间 单位毫秒 0不限制
+
+	// 只读副本 未配置的连接参数与主库相同
+	Replicas             []*DBConfiguration `yaml:"replicas"`
+	MaxReplicaLag        int                `yaml:"max_replica_lag"`        // 副本最大复制延迟 单位秒 超过后暂停使用该副本 默认5秒
+	ReplicaCheckInterval int                `yaml:"replica_check_interval"` // 副本健康检查间隔 单位秒 默认5秒
+
+	Other interface{} `yaml:"Other"`
 }
 
 type RedisConfiguration struct {
@@ -199,6 +215,9 @@ type RedisConfiguration struct {
 	MaxConnAge         int `yaml:"max_conn_age"`         // 连接最长时间 默认300秒
 	PoolTimeout        int `yaml:"pool_timeout"`         // 如果连接池已满 等待可用连接的时间 默认8秒
 
+	// redlock 多节点分布式锁 每个节点为独立部署的 redis 默认使用上述 redis
+	RedlockNodes []*RedisConfiguration `yaml:"redlock_nodes"`
+
 	Other interface{} `yaml:"Other"`
 }
 
@@ -213,16 +232,27 @@ type MQConfiguration struct {
 
 // DepSvcConfiguration 公共的依赖服务配置
 type DepSvcConfiguration struct {
-	UserMgntProtocol    string      `yaml:"user_management_private_protocol"`
-	UserMgntHost        string      `yaml:"user_management_private_host"`
-	UserMgntPort        string      `yaml:"user_management_private_port"`
-	HydraPublicProtocol string      `yaml:"hydra_public_protocol"`
-	HydraPublicHost     string      `yaml:"hydra_public_host"`
-	HydraPublicPort     string      `yaml:"hydra_public_port"`
-	HydraAdminProtocol  string      `yaml:"hydra_admin_protocol"`
-	HydraAdminHost      string      `yaml:"hydra_admin_host"`
-	HydraAdminPort      string      `yaml:"hydra_admin_port"`
-	Other               interface{} `yaml:"Other"`
+	UserMgntProtocol    string                       `yaml:"user_management_private_protocol"`
+	UserMgntHost        string                       `yaml:"user_management_private_host"`
+	UserMgntPort        string                       `yaml:"user_management_private_port"`
+	HydraPublicProtocol string                       `yaml:"hydra_public_protocol"`
+	HydraPublicHost     string                       `yaml:"hydra_public_host"`
+	HydraPublicPort     string                       `yaml:"hydra_public_port"`
+	HydraAdminProtocol  string                       `yaml:"hydra_admin_protocol"`
+	HydraAdminHost      string                       `yaml:"hydra_admin_host"`
+	HydraAdminPort      string                       `yaml:"hydra_admin_port"`
+	Oauth2Clients       []*Oauth2ClientConfiguration `yaml:"oauth2_clients"` // 按下游区分的应用账户凭据
+	Other               interface{}                  `yaml:"Other"`
+}
+
+// Oauth2ClientConfiguration 调用某个下游服务使用的应用账户凭据
+type Oauth2ClientConfiguration struct {
+	Audience      string   `yaml:"audience"`  // 下游服务标识
+	Scopes        []string `yaml:"scopes"`    // 申请的权限范围
+	ClientID      string   `yaml:"client_id"` // 为空时使用服务注册的应用账户
+	ClientSecret  string   `yaml:"client_secret"`
+	TokenURL      string   `yaml:"token_url"`      // 为空时使用 hydra public 的 /oauth2/token
+	TokenExchange bool     `yaml:"token_exchange"` // 开启后以调用方身份(RFC 8693)交换下游token
 }
 
 // RateRuleConfiguration 限流配置
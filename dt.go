@@ -1,6 +1,10 @@
 package dt
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	redis "github.com/go-redis/redis/v8"
 	"github.com/kataras/golog"
 	"github.com/kataras/iris/v12"
@@ -99,6 +103,17 @@ type (
 
 	// RedisCmd .
 	RedisCmd = redis.Cmdable
+
+	// DBRouting is the read/write routing state of a worker.
+	DBRouting = internal.DBRouting
+)
+
+const (
+	// ReplicaPluginName is the name of the gorm plugin for read/write splitting.
+	ReplicaPluginName = internal.ReplicaPluginName
+
+	// DefaultDataSource is the name of the datasource installed by InstallDB without a name.
+	DefaultDataSource = internal.DefaultDataSource
 )
 
 // NewPublicApplication returns Application interface type
@@ -123,7 +138,7 @@ func NewConfiguration() *config.Configurations {
 
 // Application
 type Application interface {
-	InstallDB(f func() (db interface{}))
+	InstallDB(f func() (db interface{}), name ...string)
 	InstallDBTable(f func() (tables map[string]interface{}))
 	InstallRedis(f func() (client redis.Cmdable))
 	InstallOther(f func() interface{})
@@ -160,3 +175,35 @@ func ToWorker(ctx Context) Worker {
 	}
 	return nil
 }
+
+// NewWorker creates a Worker outside of an http request, such as scheduled jobs and task consumers.
+// header is used to rebuild the Bus, nil means an empty Bus.
+func NewWorker(private bool, header http.Header) Worker {
+	return internal.NewWorker(private, header)
+}
+
+// DBRoutingFrom returns the routing state carried by the ctx of a db fetched by Repository.FetchDB, nil if none.
+func DBRoutingFrom(ctx context.Context) *DBRouting {
+	return internal.DBRoutingFrom(ctx)
+}
+
+// WorkerFrom returns the Worker carried by the ctx of a db fetched by Repository.FetchDB, nil if none.
+func WorkerFrom(ctx context.Context) Worker {
+	return internal.WorkerFrom(ctx)
+}
+
+// QueryTimeoutFrom returns the per-statement timeout carried by the ctx of a db fetched by Repository.FetchDB.
+func QueryTimeoutFrom(ctx context.Context) (time.Duration, bool) {
+	return internal.QueryTimeoutFrom(ctx)
+}
+
+// SetQueryTimeout sets the per-statement timeout of the dbs fetched by Repository.FetchDB in the worker,
+// usually called by a route middleware. Repository.SetQueryTimeout takes precedence.
+func SetQueryTimeout(worker Worker, timeout time.Duration) {
+	internal.SetQueryTimeout(worker, timeout)
+}
+
+// TransactionKey returns the key of the transaction of the named datasource in Worker.Store().
+func TransactionKey(name string) string {
+	return internal.TransactionKey(name)
+}
@@ -0,0 +1,111 @@
+package authz
+
+import (
+	"net/url"
+
+	dt "DT-Go"
+	"DT-Go/errors"
+	"DT-Go/utils"
+)
+
+func init() {
+	dt.Prepare(func(initiator dt.Initiator) {
+		initiator.BindInfra(false, initiator.IsPrivate(), func() *AuthorizerImpl {
+			return &AuthorizerImpl{}
+		})
+	})
+}
+
+var _ Authorizer = (*AuthorizerImpl)(nil)
+
+// Authorizer 服务内的策略决策接口
+type Authorizer interface {
+	// Subject 当前调用方
+	Subject() Subject
+	// Roles 当前调用方具备的角色
+	Roles() ([]string, error)
+	// Can 当前调用方是否具备权限
+	Can(permission string) bool
+	// Decide 对资源执行策略决策
+	Decide(policy Policy, resource map[string]interface{}) (Decision, error)
+	// Authorize 对资源执行策略决策，拒绝时返回本地化的 errors.ForbiddenErr
+	Authorize(policy Policy, resource map[string]interface{}) error
+}
+
+// AuthorizerImpl .
+type AuthorizerImpl struct {
+	dt.Infra
+}
+
+// BeginRequest .
+func (a *AuthorizerImpl) BeginRequest(worker dt.Worker) {
+	a.Infra.BeginRequest(worker)
+}
+
+// Subject .
+func (a *AuthorizerImpl) Subject() Subject {
+	return SubjectFromBus(a.Worker().Bus())
+}
+
+// Roles .
+func (a *AuthorizerImpl) Roles() ([]string, error) {
+	return currentRoleStore().RolesOf(a.Subject())
+}
+
+// Can .
+func (a *AuthorizerImpl) Can(permission string) bool {
+	decision, err := a.Decide(Require(permission), nil)
+	if err != nil {
+		a.Worker().Logger().Errorf("authz decide failed, permission: %s, err: %v", permission, err)
+		return false
+	}
+	return decision.Allowed
+}
+
+// Decide .
+func (a *AuthorizerImpl) Decide(policy Policy, resource map[string]interface{}) (Decision, error) {
+	attrs := RequestAttributes(a.Worker())
+	attrs.Resource = resource
+	return Decide(policy, attrs)
+}
+
+// Authorize .
+func (a *AuthorizerImpl) Authorize(policy Policy, resource map[string]interface{}) error {
+	decision, err := a.Decide(policy, resource)
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return Forbidden(a.Worker(), decision)
+	}
+	return nil
+}
+
+// RequestAttributes 从 worker 构建决策属性，非 HTTP 请求的 worker 仅包含调用方身份
+func RequestAttributes(worker dt.Worker) *Attributes {
+	attrs := &Attributes{
+		Subject: SubjectFromBus(worker.Bus()),
+		Params:  make(map[string]string),
+		Query:   make(url.Values),
+	}
+	ctx := worker.IrisContext()
+	if ctx == nil || ctx.Request().URL == nil {
+		return attrs
+	}
+	attrs.Method = ctx.Method()
+	attrs.Path = ctx.Path()
+	attrs.Query = ctx.Request().URL.Query()
+	ctx.Params().Visit(func(key, value string) {
+		attrs.Params[key] = value
+	})
+	return attrs
+}
+
+// Forbidden 返回本地化的 errors.ForbiddenErr
+func Forbidden(worker dt.Worker, decision Decision) *errors.ErrorResp {
+	language := worker.Bus().Get("language")
+	if language == "" {
+		language = utils.ParseXLanguage(worker.Bus().Get("x-language"))
+	}
+	return errors.New(language, errors.ForbiddenErr, decision.Reason, nil)
+}
@@ -0,0 +1,181 @@
+package authz
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/errors"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testRoleStore() *ConfigRoleStore {
+	return NewConfigRoleStore(RoleConfiguration{
+		Roles: map[string][]string{
+			"admin":  {"*"},
+			"editor": {"doc.*"},
+			"viewer": {"doc.read"},
+		},
+		Bindings: []RoleBinding{
+			{Role: "admin", Users: []string{"root"}},
+			{Role: "editor", AccountTypes: []string{"editor"}},
+			{Role: "viewer", ClientTypes: []string{"web"}},
+		},
+	})
+}
+
+func TestDecide(t *testing.T) {
+	InstallRoleStore(testRoleStore())
+	defer InstallRoleStore(NewConfigRoleStore(RoleConfiguration{}))
+
+	root := Subject{UserID: "root"}
+	editor := Subject{UserID: "u1", AccountType: "editor"}
+	viewer := Subject{UserID: "u2", ClientType: "web"}
+	anonymous := Subject{UserID: "u3"}
+
+	cases := []struct {
+		name    string
+		policy  Policy
+		attrs   *Attributes
+		allowed bool
+	}{
+		{"admin wildcard", Require("doc.delete", "user.write"), &Attributes{Subject: root}, true},
+		{"prefix wildcard", Require("doc.read", "doc.write"), &Attributes{Subject: editor}, true},
+		{"prefix wildcard other resource", Require("docs.read"), &Attributes{Subject: editor}, false},
+		{"exact permission", Require("doc.read"), &Attributes{Subject: viewer}, true},
+		{"missing permission", Require("doc.read", "doc.write"), &Attributes{Subject: viewer}, false},
+		{"no role", Require("doc.read"), &Attributes{Subject: anonymous}, false},
+		{"empty policy", Policy{}, &Attributes{Subject: anonymous}, true},
+		{"any roles", Policy{AnyRoles: []string{"admin", "editor"}}, &Attributes{Subject: editor}, true},
+		{"any roles missing", Policy{AnyRoles: []string{"admin"}}, &Attributes{Subject: viewer}, false},
+		{"owner", Require("doc.read").When(OwnerIs("owner")), &Attributes{Subject: viewer, Resource: map[string]interface{}{"owner": "u2"}}, true},
+		{"not owner", Require("doc.read").When(OwnerIs("owner")), &Attributes{Subject: viewer, Resource: map[string]interface{}{"owner": "u1"}}, false},
+		{"param is subject", Policy{}.When(ParamIsSubject("id")), &Attributes{Subject: viewer, Params: map[string]string{"id": "u2"}}, true},
+		{"param missing", Policy{}.When(ParamIsSubject("id")), &Attributes{Subject: Subject{}, Params: map[string]string{}}, false},
+		{"account type", Policy{}.When(AccountTypeIn("editor", "user")), &Attributes{Subject: editor}, true},
+		{"account type mismatch", Policy{}.When(AccountTypeIn("user")), &Attributes{Subject: viewer}, false},
+	}
+	for _, c := range cases {
+		decision, err := Decide(c.policy, c.attrs)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.allowed, decision.Allowed, c.name)
+		if !c.allowed {
+			assert.NotEmpty(t, decision.Reason, c.name)
+		}
+	}
+
+	// When 不修改原策略
+	base := Require("doc.read")
+	_ = base.When(AccountTypeIn("user"))
+	assert.Empty(t, base.Conditions)
+}
+
+type docController struct{}
+
+func (c *docController) GetBy(id string) string { return id }
+func (c *docController) Post() string           { return "" }
+
+func (c *docController) Policies() map[string]Policy {
+	return map[string]Policy{"GetBy": Require("doc.read")}
+}
+
+func TestAnnotatedPolicy(t *testing.T) {
+	app := iris.New()
+	mvc.New(app.Party("/docs")).Handle(new(docController))
+	Annotate(new(docController))
+
+	handlers := make(map[string]string)
+	for _, route := range app.GetRoutes() {
+		handlers[route.Method] = route.MainHandlerName
+	}
+	// 路由的 MainHandlerName 与注解 key 一致
+	policy, ok := AnnotatedPolicy(handlers[http.MethodGet])
+	assert.True(t, ok, handlers[http.MethodGet])
+	assert.Equal(t, []string{"doc.read"}, policy.Permissions)
+
+	_, ok = AnnotatedPolicy(handlers[http.MethodPost])
+	assert.False(t, ok)
+}
+
+func TestDBRoleStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:authz?mode=memory&cache=shared"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Exec("CREATE TABLE user_role (subject_id TEXT, role TEXT)").Error)
+	assert.Nil(t, db.Exec("CREATE TABLE role_permission (role TEXT, permission TEXT)").Error)
+	assert.Nil(t, db.Exec("INSERT INTO user_role VALUES ('u1', 'editor'), ('c1', 'viewer')").Error)
+	assert.Nil(t, db.Exec("INSERT INTO role_permission VALUES ('editor', 'doc.read'), ('editor', 'doc.write'), ('viewer', 'doc.read')").Error)
+
+	store := NewDBRoleStore(func() *gorm.DB { return db }, "user_role", "role_permission", time.Minute)
+	roles, err := store.RolesOf(Subject{UserID: "u1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"editor"}, roles)
+	roles, err = store.RolesOf(Subject{ClientID: "c1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"viewer"}, roles)
+	perms, err := store.PermissionsOf("editor")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"doc.read", "doc.write"}, perms)
+
+	// TTL 内使用进程内缓存
+	assert.Nil(t, db.Exec("DELETE FROM user_role").Error)
+	roles, err = store.RolesOf(Subject{UserID: "u1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"editor"}, roles)
+
+	// 未缓存的调用方读取数据库
+	roles, err = store.RolesOf(Subject{UserID: "u2"})
+	assert.Nil(t, err)
+	assert.Empty(t, roles)
+
+	// 零值可用，超出 MaxEntries 时淘汰最久未使用的条目
+	bounded := &DBRoleStore{DB: func() *gorm.DB { return db }, RolePermissionTable: "role_permission", TTL: time.Minute, MaxEntries: 1}
+	perms, err = bounded.PermissionsOf("viewer")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"doc.read"}, perms)
+	_, err = bounded.PermissionsOf("editor")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, bounded.entries.Len())
+	assert.False(t, bounded.entries.Contains("role:viewer"))
+
+	InstallRoleStore(store)
+	defer InstallRoleStore(NewConfigRoleStore(RoleConfiguration{}))
+	decision, err := Decide(Require("doc.write"), &Attributes{Subject: Subject{UserID: "u1"}})
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+}
+
+func TestAuthorizerForbidden(t *testing.T) {
+	InstallRoleStore(testRoleStore())
+	defer InstallRoleStore(NewConfigRoleStore(RoleConfiguration{}))
+
+	for language, description := range map[string]string{
+		errors.ENUS: "You are not allowed to access this resource.",
+		errors.ZHCN: "您没有权限访问当前资源。",
+	} {
+		header := http.Header{}
+		header.Set("x-language", language)
+		header.Set("user_id", "u2")
+		header.Set("client_type", "web")
+		authorizer := &AuthorizerImpl{}
+		authorizer.BeginRequest(dt.NewWorker(false, header))
+
+		assert.Equal(t, "u2", authorizer.Subject().ID())
+		assert.True(t, authorizer.Can("doc.read"))
+		assert.False(t, authorizer.Can("doc.write"))
+		assert.Nil(t, authorizer.Authorize(Require("doc.read"), nil))
+
+		err := authorizer.Authorize(Require("doc.read").When(OwnerIs("owner")), map[string]interface{}{"owner": "u1"})
+		resp, ok := err.(*errors.ErrorResp)
+		if assert.True(t, ok, language) {
+			assert.Equal(t, errors.ForbiddenErr, resp.Code())
+			assert.Equal(t, description, resp.Description())
+			assert.Equal(t, "condition 0 not satisfied", resp.Cause())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package authz
+
+/**
+声明式鉴权组件 (RBAC + ABAC)
+
+	1.角色与权限从配置文件或数据库加载 (RoleStore)
+	2.策略(Policy)可在 BindController/CreateParty 时作为中间件挂载，或由控制器方法注解声明
+	3.属性条件(Condition)可以使用请求与资源的字段
+	4.服务内通过 Authorizer 组件做策略决策
+
+Created by Dustin.zhu on 2023/08/21.
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	dt "DT-Go"
+
+	"github.com/kataras/iris/v12/mvc"
+)
+
+var (
+	storeMu   sync.RWMutex
+	roleStore RoleStore = NewConfigRoleStore(RoleConfiguration{})
+
+	// annotations 控制器方法注解的策略 key: iris 路由的 MainHandlerName
+	annotations sync.Map
+)
+
+// InstallRoleStore 安装角色与权限的数据源，默认为空配置
+func InstallRoleStore(store RoleStore) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	roleStore = store
+}
+
+func currentRoleStore() RoleStore {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return roleStore
+}
+
+// Subject 认证中间件写入总线的调用方身份
+type Subject struct {
+	UserID      string
+	ClientID    string
+	AccountType string
+	ClientType  string
+	VisitorType string
+}
+
+// SubjectFromBus 从总线读取调用方身份
+func SubjectFromBus(bus *dt.Bus) Subject {
+	return Subject{
+		UserID:      bus.Get("user_id"),
+		ClientID:    bus.Get("client_id"),
+		AccountType: bus.Get("account_type"),
+		ClientType:  bus.Get("client_type"),
+		VisitorType: bus.Get("visitor_type"),
+	}
+}
+
+// ID 用户账户返回 user_id，应用账户返回 client_id
+func (s Subject) ID() string {
+	if s.UserID != "" {
+		return s.UserID
+	}
+	return s.ClientID
+}
+
+// Attributes 策略决策时可用的属性
+type Attributes struct {
+	Subject  Subject
+	Method   string
+	Path     string
+	Params   map[string]string      // 路由参数
+	Query    url.Values             // 查询参数
+	Resource map[string]interface{} // 资源字段，由服务在决策时提供
+}
+
+// Condition 属性条件
+type Condition func(attrs *Attributes) bool
+
+// Policy 访问策略，各项均需满足
+type Policy struct {
+	Permissions []string    // 需要全部具备的权限，支持通配符 "doc.*" 与 "*"
+	AnyRoles    []string    // 需要具备其中任一角色，为空不校验
+	Conditions  []Condition // 需要全部满足的属性条件
+}
+
+// Require 需要全部权限的策略
+func Require(permissions ...string) Policy {
+	return Policy{Permissions: permissions}
+}
+
+// When 追加属性条件
+func (p Policy) When(conditions ...Condition) Policy {
+	p.Conditions = append(append([]Condition{}, p.Conditions...), conditions...)
+	return p
+}
+
+// Decision 策略决策结果
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Decide 对 attrs 执行策略决策
+func Decide(policy Policy, attrs *Attributes) (Decision, error) {
+	store := currentRoleStore()
+	roles, err := store.RolesOf(attrs.Subject)
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(policy.AnyRoles) > 0 && !hasAnyRole(roles, policy.AnyRoles) {
+		return Decision{Reason: fmt.Sprintf("requires one of roles %v", policy.AnyRoles)}, nil
+	}
+	if len(policy.Permissions) > 0 {
+		granted := make([]string, 0)
+		for _, role := range roles {
+			perms, err := store.PermissionsOf(role)
+			if err != nil {
+				return Decision{}, err
+			}
+			granted = append(granted, perms...)
+		}
+		for _, required := range policy.Permissions {
+			if !permitted(granted, required) {
+				return Decision{Reason: fmt.Sprintf("missing permission %s", required)}, nil
+			}
+		}
+	}
+	for i, condition := range policy.Conditions {
+		if !condition(attrs) {
+			return Decision{Reason: fmt.Sprintf("condition %d not satisfied", i)}, nil
+		}
+	}
+	return Decision{Allowed: true}, nil
+}
+
+// permitted 权限匹配 "*" 匹配全部, "doc.*" 匹配 "doc.read"
+func permitted(granted []string, required string) bool {
+	for _, g := range granted {
+		if g == "*" || g == required {
+			return true
+		}
+		if strings.HasSuffix(g, ".*") && strings.HasPrefix(required, strings.TrimSuffix(g, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyRole(roles, required []string) bool {
+	for _, r := range required {
+		for _, role := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Annotated 控制器实现该接口以方法注解的形式声明策略 key: 方法名
+type Annotated interface {
+	Policies() map[string]Policy
+}
+
+// Annotate 登记控制器的方法注解，在 BindController 之后调用
+func Annotate(controller Annotated) {
+	name := strings.TrimPrefix(mvc.NameOf(controller), "main.")
+	t := reflect.TypeOf(controller)
+	for method, policy := range controller.Policies() {
+		if _, ok := t.MethodByName(method); !ok {
+			dt.Logger().Fatalf("Annotate: method not found, %v.%s", t, method)
+		}
+		annotations.Store(name+"."+method, policy)
+	}
+}
+
+// AnnotatedPolicy 返回路由 MainHandlerName 对应的注解策略
+func AnnotatedPolicy(handlerName string) (Policy, bool) {
+	policy, ok := annotations.Load(handlerName)
+	if !ok {
+		return Policy{}, false
+	}
+	return policy.(Policy), true
+}
+
+// OwnerIs 资源字段 field 等于调用方 ID
+func OwnerIs(field string) Condition {
+	return func(attrs *Attributes) bool {
+		return fmt.Sprint(attrs.Resource[field]) == attrs.Subject.ID()
+	}
+}
+
+// ParamIsSubject 路由参数 param 等于调用方 ID
+func ParamIsSubject(param string) Condition {
+	return func(attrs *Attributes) bool {
+		return attrs.Params[param] != "" && attrs.Params[param] == attrs.Subject.ID()
+	}
+}
+
+// AccountTypeIn 调用方账户类型属于 types
+func AccountTypeIn(types ...string) Condition {
+	return func(attrs *Attributes) bool {
+		for _, t := range types {
+			if attrs.Subject.AccountType == t {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,179 @@
+package authz
+
+import (
+	"sync"
+	"time"
+
+	"DT-Go/config"
+	"DT-Go/infra/rate/sentinel/core/hotspot/cache"
+
+	"gorm.io/gorm"
+)
+
+// RoleStore 角色与权限的数据源
+type RoleStore interface {
+	// RolesOf 调用方具备的角色
+	RolesOf(subject Subject) ([]string, error)
+	// PermissionsOf 角色具备的权限
+	PermissionsOf(role string) ([]string, error)
+}
+
+// RoleConfiguration 角色配置 authz.yaml
+//
+//	roles:
+//	  admin: ["*"]
+//	  editor: [doc.read, doc.write]
+//	bindings:
+//	  - role: editor
+//	    account_types: [user]
+//	  - role: admin
+//	    users: [3f9c0e...]
+type RoleConfiguration struct {
+	Roles    map[string][]string `yaml:"roles"`
+	Bindings []RoleBinding       `yaml:"bindings"`
+}
+
+// RoleBinding 将角色授予调用方，任一列表命中即授予
+type RoleBinding struct {
+	Role         string   `yaml:"role"`
+	Users        []string `yaml:"users"`         // user_id 或 client_id
+	AccountTypes []string `yaml:"account_types"` // 如 user
+	ClientTypes  []string `yaml:"client_types"`
+}
+
+// ConfigRoleStore 基于配置文件的角色数据源
+type ConfigRoleStore struct {
+	conf RoleConfiguration
+}
+
+// NewConfigRoleStore .
+func NewConfigRoleStore(conf RoleConfiguration) *ConfigRoleStore {
+	return &ConfigRoleStore{conf: conf}
+}
+
+// LoadConfigRoleStore 从配置目录读取角色配置文件
+func LoadConfigRoleStore(file string) (*ConfigRoleStore, error) {
+	var conf RoleConfiguration
+	if err := config.Configure(&conf, file); err != nil {
+		return nil, err
+	}
+	return NewConfigRoleStore(conf), nil
+}
+
+// RolesOf .
+func (s *ConfigRoleStore) RolesOf(subject Subject) (roles []string, err error) {
+	for _, binding := range s.conf.Bindings {
+		if contains(binding.Users, subject.ID()) ||
+			contains(binding.AccountTypes, subject.AccountType) ||
+			contains(binding.ClientTypes, subject.ClientType) {
+			roles = append(roles, binding.Role)
+		}
+	}
+	return
+}
+
+// PermissionsOf .
+func (s *ConfigRoleStore) PermissionsOf(role string) ([]string, error) {
+	return s.conf.Roles[role], nil
+}
+
+// defaultRoleCacheEntries DBRoleStore 进程内缓存的默认最大条数
+const defaultRoleCacheEntries = 10000
+
+// DBRoleStore 基于数据库的角色数据源，结果在进程内 LRU 中缓存 TTL
+//
+//	UserRoleTable:       subject_id, role
+//	RolePermissionTable: role, permission
+type DBRoleStore struct {
+	DB                  func() *gorm.DB
+	UserRoleTable       string
+	RolePermissionTable string
+	TTL                 time.Duration
+	MaxEntries          int // 调用方角色与角色权限合计缓存的最大条数 默认10000
+
+	mu      sync.Mutex
+	entries *cache.LRU
+}
+
+type cachedList struct {
+	values   []string
+	expireAt time.Time
+}
+
+// NewDBRoleStore .
+func NewDBRoleStore(db func() *gorm.DB, userRoleTable, rolePermissionTable string, ttl time.Duration) *DBRoleStore {
+	return &DBRoleStore{
+		DB:                  db,
+		UserRoleTable:       userRoleTable,
+		RolePermissionTable: rolePermissionTable,
+		TTL:                 ttl,
+	}
+}
+
+// RolesOf .
+func (s *DBRoleStore) RolesOf(subject Subject) ([]string, error) {
+	return s.load("subject:"+subject.ID(), func(roles *[]string) error {
+		return s.DB().Table(s.UserRoleTable).Where("subject_id = ?", subject.ID()).Pluck("role", roles).Error
+	})
+}
+
+// PermissionsOf .
+func (s *DBRoleStore) PermissionsOf(role string) ([]string, error) {
+	return s.load("role:"+role, func(perms *[]string) error {
+		return s.DB().Table(s.RolePermissionTable).Where("role = ?", role).Pluck("permission", perms).Error
+	})
+}
+
+func (s *DBRoleStore) load(key string, query func(*[]string) error) ([]string, error) {
+	if cached, ok := s.get(key); ok {
+		return cached, nil
+	}
+	values := make([]string, 0)
+	if err := query(&values); err != nil {
+		return nil, err
+	}
+	if s.TTL > 0 {
+		s.mu.Lock()
+		s.lru().Add(key, cachedList{values: values, expireAt: time.Now().Add(s.TTL)})
+		s.mu.Unlock()
+	}
+	return values, nil
+}
+
+func (s *DBRoleStore) get(key string) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.lru().Get(key)
+	if !ok {
+		return nil, false
+	}
+	if cached := value.(cachedList); time.Now().Before(cached.expireAt) {
+		return cached.values, true
+	}
+	s.entries.Remove(key)
+	return nil, false
+}
+
+// lru 首次使用时创建，零值的 DBRoleStore 同样可用
+func (s *DBRoleStore) lru() *cache.LRU {
+	if s.entries == nil {
+		size := s.MaxEntries
+		if size <= 0 {
+			size = defaultRoleCacheEntries
+		}
+		s.entries, _ = cache.NewLRU(size, nil)
+	}
+	return s.entries
+}
+
+func contains(list []string, v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
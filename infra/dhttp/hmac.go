@@ -0,0 +1,112 @@
+package dhttp
+
+/**
+服务间 HMAC 请求签名
+
+	签名串: METHOD \n PATH?QUERY \n hex(sha256(body)) \n TIMESTAMP \n NONCE
+	签名:   base64(HMAC-SHA256(secret, 签名串))
+
+Created by Dustin.zhu on 2023/08/28.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderKeyID 签名密钥标识
+	HeaderKeyID = "X-Dt-Key-Id"
+	// HeaderTimestamp 签名时间 unix 秒
+	HeaderTimestamp = "X-Dt-Timestamp"
+	// HeaderNonce 一次性随机串 防重放
+	HeaderNonce = "X-Dt-Nonce"
+	// HeaderContentSHA256 请求体摘要
+	HeaderContentSHA256 = "X-Dt-Content-Sha256"
+	// HeaderSignature 签名
+	HeaderSignature = "X-Dt-Signature"
+)
+
+// NewHMACSigner 返回为请求签名的中间件 dhttp.InstallMiddleware(dhttp.NewHMACSigner(keyID, secret))
+func NewHMACSigner(keyID string, secret []byte) Handler {
+	return func(middle Middleware) {
+		req := middle.GetRequest()
+		u, err := url.Parse(middle.URL())
+		if err != nil {
+			middle.Stop(err)
+			return
+		}
+		if err = SignHMAC(req, u, keyID, secret); err != nil {
+			middle.Stop(err)
+			return
+		}
+		middle.Next()
+	}
+}
+
+// SignHMAC 为请求添加签名头, u 为空时使用 req.URL
+func SignHMAC(req *http.Request, u *url.URL, keyID string, secret []byte) error {
+	if u == nil {
+		u = req.URL
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	digest := ContentSHA256(body)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceStr := hex.EncodeToString(nonce)
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonceStr)
+	req.Header.Set(HeaderContentSHA256, digest)
+	req.Header.Set(HeaderSignature, HMACSignature(secret, req.Method, u, digest, timestamp, nonceStr))
+	return nil
+}
+
+// HMACSignature 计算签名
+func HMACSignature(secret []byte, method string, u *url.URL, contentSHA256, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(HMACCanonicalString(method, u, contentSHA256, timestamp, nonce)))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HMACCanonicalString 签名串
+func HMACCanonicalString(method string, u *url.URL, contentSHA256, timestamp, nonce string) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if query := u.Query().Encode(); query != "" {
+		path += "?" + query
+	}
+	return strings.Join([]string{strings.ToUpper(method), path, contentSHA256, timestamp, nonce}, "\n")
+}
+
+// ContentSHA256 请求体摘要
+func ContentSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -10,6 +10,7 @@ type Middleware interface {
 	Next()
 	Stop(...error)
 	GetRequest() *http.Request
+	URL() string
 	GetRespone() *Response
 	GetResponeBody() []byte
 	IsStopped() bool
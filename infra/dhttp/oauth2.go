@@ -0,0 +1,309 @@
+package dhttp
+
+/**
+OAuth2 下游凭据与令牌交换
+
+	1.按下游 audience + scopes 注册多套 client_credentials 凭据
+	2.代理调用(on-behalf-of)：按 RFC 8693 用调用方的 token 交换下游 token
+	3.令牌缓存至过期前 TokenExpiryDelta
+
+Created by Dustin.zhu on 2023/08/14.
+*/
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// GrantTypeTokenExchange RFC 8693 token exchange grant type.
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// TokenTypeAccessToken RFC 8693 access token type identifier.
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	// TokenExpiryDelta tokens are refreshed this long before they expire.
+	TokenExpiryDelta = 30 * time.Second
+
+	oauth2Clients   sync.Map // audience key -> *http.Client
+	tokenExchangers sync.Map // audience key -> *TokenExchanger
+)
+
+// Oauth2Credential is the client credentials used to call one downstream audience.
+type Oauth2Credential struct {
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+	Audience     string
+	Scopes       []string
+}
+
+// RegisterOauth2Client registers a client_credentials client for cred.Audience and cred.Scopes.
+// base is the client used to reach the token endpoint and the downstream, nil means DefaultHTTPClient.
+func RegisterOauth2Client(cred Oauth2Credential, base *http.Client) *http.Client {
+	if base == nil {
+		base = DefaultHTTPClient
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, base)
+	credConf := &clientcredentials.Config{
+		ClientID:     cred.ClientID,
+		ClientSecret: cred.ClientSecret,
+		TokenURL:     cred.TokenURL,
+		Scopes:       cred.Scopes,
+	}
+	if cred.Audience != "" {
+		credConf.EndpointParams = url.Values{"audience": {cred.Audience}}
+	}
+	source := &cachedTokenSource{fetch: func() (*oauth2.Token, error) {
+		return credConf.Token(ctx)
+	}}
+	client := &http.Client{
+		Transport: &oauth2.Transport{Source: source, Base: base.Transport},
+		Timeout:   base.Timeout,
+	}
+	oauth2Clients.Store(audienceKey(cred.Audience, cred.Scopes), client)
+	return client
+}
+
+// Oauth2Client returns the client registered for audience and scopes.
+func Oauth2Client(audience string, scopes ...string) (*http.Client, bool) {
+	client, ok := oauth2Clients.Load(audienceKey(audience, scopes))
+	if !ok {
+		return nil, false
+	}
+	return client.(*http.Client), true
+}
+
+// NewOauth2RequestFor returns a request authorized with the credentials registered for audience and scopes.
+func NewOauth2RequestFor(rawurl, audience string, scopes ...string) Request {
+	result := NewHTTPRequest(rawurl).(*HTTPRequest)
+	client, ok := Oauth2Client(audience, scopes...)
+	if !ok {
+		result.Response.Error = fmt.Errorf("oauth2 client not registered, audience: %s, scopes: %v", audience, scopes)
+		return result
+	}
+	result.Client = client
+	return result
+}
+
+// RegisterTokenExchanger registers a token exchanger for cred.Audience and cred.Scopes.
+func RegisterTokenExchanger(cred Oauth2Credential, base *http.Client) *TokenExchanger {
+	if base == nil {
+		base = DefaultHTTPClient
+	}
+	exchanger := &TokenExchanger{
+		cred:   cred,
+		client: base,
+		tokens: make(map[string]*oauth2.Token),
+	}
+	tokenExchangers.Store(audienceKey(cred.Audience, cred.Scopes), exchanger)
+	return exchanger
+}
+
+// NewOnBehalfOfRequest returns a request authorized with a downstream token
+// exchanged from subjectToken, the caller's own access token.
+// ctx is the caller's request context, it bounds both the token exchange and the request.
+func NewOnBehalfOfRequest(ctx context.Context, rawurl, subjectToken, audience string, scopes ...string) Request {
+	result := NewHTTPRequest(rawurl).(*HTTPRequest)
+	result.WithContext(ctx)
+	exchanger, ok := tokenExchangers.Load(audienceKey(audience, scopes))
+	if !ok {
+		result.Response.Error = fmt.Errorf("token exchanger not registered, audience: %s, scopes: %v", audience, scopes)
+		return result
+	}
+	if subjectToken == "" {
+		result.Response.Error = errors.New("subject token is empty")
+		return result
+	}
+	base := exchanger.(*TokenExchanger).client
+	result.Client = &http.Client{
+		Transport: &onBehalfOfTransport{exchanger: exchanger.(*TokenExchanger), subjectToken: subjectToken, base: base.Transport},
+		Timeout:   base.Timeout,
+	}
+	return result
+}
+
+// onBehalfOfTransport exchanges the subject token with the outgoing request's context.
+type onBehalfOfTransport struct {
+	exchanger    *TokenExchanger
+	subjectToken string
+	base         http.RoundTripper
+}
+
+// RoundTrip .
+func (t *onBehalfOfTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tok, err := t.exchanger.Exchange(req.Context(), t.subjectToken)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	authorized := req.Clone(req.Context())
+	tok.SetAuthHeader(authorized)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(authorized)
+}
+
+// TokenExchanger exchanges caller tokens for downstream tokens (RFC 8693) and caches the results.
+type TokenExchanger struct {
+	cred   Oauth2Credential
+	client *http.Client
+	group  singleflight.Group
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token // sha256(subject token) -> downstream token
+}
+
+// TokenSource returns a TokenSource exchanging subjectToken on demand, for callers without a request context.
+func (te *TokenExchanger) TokenSource(subjectToken string) oauth2.TokenSource {
+	return tokenSourceFunc(func() (*oauth2.Token, error) {
+		return te.Exchange(context.Background(), subjectToken)
+	})
+}
+
+// Exchange returns a cached downstream token for subjectToken, or requests a new one.
+func (te *TokenExchanger) Exchange(ctx context.Context, subjectToken string) (*oauth2.Token, error) {
+	sum := sha256.Sum256([]byte(subjectToken))
+	key := hex.EncodeToString(sum[:])
+
+	te.mu.Lock()
+	tok, ok := te.tokens[key]
+	te.mu.Unlock()
+	if ok && tokenFresh(tok) {
+		return tok, nil
+	}
+
+	v, err, _ := te.group.Do(key, func() (interface{}, error) {
+		tok, err := te.exchange(ctx, subjectToken)
+		if err != nil {
+			return nil, err
+		}
+		te.mu.Lock()
+		defer te.mu.Unlock()
+		for k, t := range te.tokens {
+			if !tokenFresh(t) {
+				delete(te.tokens, k)
+			}
+		}
+		te.tokens[key] = tok
+		return tok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*oauth2.Token), nil
+}
+
+func (te *TokenExchanger) exchange(ctx context.Context, subjectToken string) (*oauth2.Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", GrantTypeTokenExchange)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", TokenTypeAccessToken)
+	form.Set("requested_token_type", TokenTypeAccessToken)
+	if te.cred.Audience != "" {
+		form.Set("audience", te.cred.Audience)
+	}
+	if len(te.cred.Scopes) > 0 {
+		form.Set("scope", strings.Join(te.cred.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, te.cred.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(te.cred.ClientID), url.QueryEscape(te.cred.ClientSecret))
+	resp, err := te.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token exchange failed, status code is %d, body:%s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		TokenType       string `json:"token_type"`
+		ExpiresIn       int64  `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("%s, body:%s", err.Error(), string(body))
+	}
+	if result.AccessToken == "" {
+		return nil, fmt.Errorf("token exchange returned an empty access token, body:%s", string(body))
+	}
+	tok := &oauth2.Token{AccessToken: result.AccessToken, TokenType: result.TokenType}
+	if result.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// cachedTokenSource caches the fetched token until TokenExpiryDelta before its expiry.
+type cachedTokenSource struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	fetch func() (*oauth2.Token, error)
+}
+
+// Token .
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && tokenFresh(s.token) {
+		return s.token, nil
+	}
+	tok, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	s.token = tok
+	return tok, nil
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+// Token .
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+func tokenFresh(tok *oauth2.Token) bool {
+	if tok.AccessToken == "" {
+		return false
+	}
+	if tok.Expiry.IsZero() {
+		return true
+	}
+	return time.Until(tok.Expiry) > TokenExpiryDelta
+}
+
+func audienceKey(audience string, scopes []string) string {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	return audience + "|" + strings.Join(sorted, " ")
+}
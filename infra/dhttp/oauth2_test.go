@@ -0,0 +1,126 @@
+package dhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTokenServer serves /token (client_credentials and token exchange) and /api echoing the Authorization header.
+func fakeTokenServer(t *testing.T, expiresIn int64, issued *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		id, secret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "svc", id)
+		assert.Equal(t, "secret", secret)
+		n := atomic.AddInt32(issued, 1)
+
+		token := "cc-" + r.PostForm.Get("audience")
+		if r.PostForm.Get("grant_type") == GrantTypeTokenExchange {
+			assert.Equal(t, TokenTypeAccessToken, r.PostForm.Get("subject_token_type"))
+			assert.Equal(t, "read write", r.PostForm.Get("scope"))
+			token = "obo-" + r.PostForm.Get("subject_token")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      token,
+			"token_type":        "bearer",
+			"expires_in":        expiresIn,
+			"issued_token_type": TokenTypeAccessToken,
+			"n":                 n,
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestOauth2ClientPerAudience(t *testing.T) {
+	var issued int32
+	server := fakeTokenServer(t, 3600, &issued)
+	defer server.Close()
+
+	for _, audience := range []string{"doc", "user"} {
+		RegisterOauth2Client(Oauth2Credential{
+			ClientID:     "svc",
+			ClientSecret: "secret",
+			TokenURL:     server.URL + "/token",
+			Audience:     audience,
+		}, server.Client())
+	}
+
+	for i := 0; i < 3; i++ {
+		value, resp := NewOauth2RequestFor(server.URL+"/api", "doc").Get().ToString()
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, "Bearer cc-doc", value)
+	}
+	value, resp := NewOauth2RequestFor(server.URL+"/api", "user").Get().ToString()
+	assert.NoError(t, resp.Error)
+	assert.Equal(t, "Bearer cc-user", value)
+	// 每个下游只申请一次 token
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+
+	_, resp = NewOauth2RequestFor(server.URL+"/api", "unknown").Get().ToString()
+	assert.Error(t, resp.Error)
+}
+
+func TestOnBehalfOfRequest(t *testing.T) {
+	var issued int32
+	server := fakeTokenServer(t, 3600, &issued)
+	defer server.Close()
+
+	RegisterTokenExchanger(Oauth2Credential{
+		ClientID:     "svc",
+		ClientSecret: "secret",
+		TokenURL:     server.URL + "/token",
+		Audience:     "report",
+		Scopes:       []string{"read", "write"},
+	}, server.Client())
+
+	for _, subject := range []string{"alice", "alice", "bob"} {
+		value, resp := NewOnBehalfOfRequest(context.Background(), server.URL+"/api", subject, "report", "write", "read").Get().ToString()
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, "Bearer obo-"+subject, value)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+
+	_, resp := NewOnBehalfOfRequest(context.Background(), server.URL+"/api", "", "report", "read", "write").Get().ToString()
+	assert.Error(t, resp.Error)
+
+	// 调用方的 ctx 取消后不再换取 token
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, resp = NewOnBehalfOfRequest(ctx, server.URL+"/api", "carol", "report", "write", "read").Get().ToString()
+	assert.ErrorIs(t, resp.Error, context.Canceled)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	var issued int32
+	// 有效期短于 TokenExpiryDelta 的 token 不会被复用
+	server := fakeTokenServer(t, int64(TokenExpiryDelta/time.Second)-1, &issued)
+	defer server.Close()
+
+	exchanger := RegisterTokenExchanger(Oauth2Credential{
+		ClientID:     "svc",
+		ClientSecret: "secret",
+		TokenURL:     server.URL + "/token",
+		Audience:     "short",
+		Scopes:       []string{"read", "write"},
+	}, server.Client())
+	for i := 0; i < 2; i++ {
+		tok, err := exchanger.Exchange(context.Background(), "alice")
+		assert.NoError(t, err)
+		assert.Equal(t, "obo-alice", tok.AccessToken)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&issued))
+}
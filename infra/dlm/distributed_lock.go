@@ -17,8 +17,9 @@ import (
 	3.锁的自动续期：利用 Go 协程实现锁资源的自动续期(看门狗机制)，避免出现业务时间>锁超时时间导致并发安全问题
 	4.TryLock机制：仅尝试一次锁的获取，如果失败，那么不会阻塞，直接返回
 	5.自旋锁：提供自旋锁 API 来实现分布式锁的自旋获取
+	6.多节点：InstallRedlock 安装多个独立的 redis 节点后使用 Redlock 算法
 不支持如下特性：
-	1.重入性：分布式锁不可重入，Go 语言并没有优雅的方式来实现 Java 中的 ThreadLocal 机制
+	1.重入性：分布式锁不可重入，需要重入与 fencing token 时使用 ReentrantLock，读写锁使用 RWLock
 	2.非公平性：分布式锁存在非公平问题，在极端情况下会导致饥饿问题
 
 Created by Dustin.zhu on 2023/05/10.
@@ -69,6 +70,7 @@ type DLMImpl struct {
 	expiration time.Duration
 	cancelFunc context.CancelFunc
 	ctx        context.Context
+	nodes      []redis.Cmdable // redlock 节点 未安装时使用单节点
 }
 
 // BeginRequest .
@@ -76,7 +78,8 @@ func (dlm *DLMImpl) BeginRequest(worker dt.Worker) {
 	dlm.expiration = DefaultExpiration * time.Second
 	dlm.client = dlm.Redis()
 	dlm.ctx = context.Background()
-	dlm.value = time.Now().GoString()
+	dlm.value = newOwnerID()
+	dlm.nodes = currentRedlockNodes()
 	dlm.Infra.BeginRequest(worker)
 }
 
@@ -124,8 +127,12 @@ func (dlm *DLMImpl) Lock(key string) (err error) {
 func (dlm *DLMImpl) TryLock(key string) (ok bool, err error) {
 	mutex.Lock()
 	defer mutex.Unlock()
-	ok, err = dlm.client.SetNX(dlm.ctx, key, dlm.value, dlm.expiration).Result()
-	if err != nil {
+	if len(dlm.nodes) > 0 {
+		ok, err = dlm.tryLockQuorum(key)
+	} else {
+		ok, err = dlm.client.SetNX(dlm.ctx, key, dlm.value, dlm.expiration).Result()
+	}
+	if err != nil || !ok {
 		return
 	}
 	// 使用context控制watchdog协程的执行和取消
@@ -143,7 +150,14 @@ func (dlm *DLMImpl) watchdog(ctx context.Context, key string) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			dlm.client.Expire(ctx, key, dlm.expiration).Result()
+			if len(dlm.nodes) == 0 {
+				dlm.client.Expire(ctx, key, dlm.expiration).Result()
+				continue
+			}
+			if !dlm.renewQuorum(ctx, key) && ctx.Err() == nil {
+				dt.Logger().Warnf("redlock: lost quorum while renewing %s", key)
+				return
+			}
 		}
 	}
 }
@@ -165,6 +179,13 @@ func (dlm *DLMImpl) SpinLock(key string, times int) error {
 
 // Unlock .
 func (dlm *DLMImpl) Unlock(key string) error {
+	if len(dlm.nodes) > 0 {
+		if dlm.unlockAll(key) == 0 {
+			return fmt.Errorf("unlock script fail: %v", key)
+		}
+		dlm.cancelFunc()
+		return nil
+	}
 	script := redis.NewScript(fmt.Sprintf(
 		`if redis.call("get", KEYS[1]) == "%s" then return redis.call("del", KEYS[1]) else return 0 end`,
 		dlm.value))
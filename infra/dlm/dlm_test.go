@@ -0,0 +1,372 @@
+package dlm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeRedis(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func newReentrantLock(client redis.Cmdable, owner string) *ReentrantLockImpl {
+	return &ReentrantLockImpl{
+		owner:      owner,
+		client:     client,
+		expiration: time.Second,
+		ctx:        context.Background(),
+		watchdogs:  newWatchdogs(),
+	}
+}
+
+func newRWLock(client redis.Cmdable, owner string) *RWLockImpl {
+	return &RWLockImpl{
+		owner:      owner,
+		client:     client,
+		expiration: time.Second,
+		ctx:        context.Background(),
+		readers:    newWatchdogs(),
+		writers:    newWatchdogs(),
+	}
+}
+
+func TestReentrantLockHoldCount(t *testing.T) {
+	_, client := newFakeRedis(t)
+	alice, bob := newReentrantLock(client, "alice"), newReentrantLock(client, "bob")
+
+	token, ok, err := alice.TryLock("order:1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	again, ok, err := alice.TryLock("order:1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, token, again, "re-entry keeps the fencing token")
+
+	_, ok, err = bob.TryLock("order:1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, alice.Unlock("order:1"))
+	_, ok, _ = bob.TryLock("order:1")
+	assert.False(t, ok, "lock is held until every Lock is matched by Unlock")
+
+	require.NoError(t, alice.Unlock("order:1"))
+	next, ok, err := bob.TryLock("order:1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Greater(t, next, token)
+
+	assert.Equal(t, ErrLockNotHeld, alice.Unlock("order:1"))
+	require.NoError(t, bob.Unlock("order:1"))
+}
+
+func TestReentrantLockBlocksUntilReleased(t *testing.T) {
+	_, client := newFakeRedis(t)
+	alice, bob := newReentrantLock(client, "alice"), newReentrantLock(client, "bob")
+
+	_, err := alice.Lock("report")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		alice.Unlock("report")
+	}()
+	_, err = bob.Lock("report")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	alice.SetContext(ctx)
+	_, err = alice.Lock("report")
+	assert.Error(t, err)
+}
+
+func TestFencingTokenRejectsExpiredHolder(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	alice, bob := newReentrantLock(client, "alice"), newReentrantLock(client, "bob")
+	ctx := context.Background()
+
+	stale, err := alice.Lock("stock")
+	require.NoError(t, err)
+	require.NoError(t, ValidateFencingToken(ctx, client, "stock", stale))
+
+	// alice 暂停期间锁过期，bob 获取锁
+	alice.watchdogs.stop("stock")
+	mr.FastForward(2 * time.Second)
+	fresh, err := bob.Lock("stock")
+	require.NoError(t, err)
+	assert.Greater(t, fresh, stale)
+
+	assert.Equal(t, ErrStaleFencingToken, ValidateFencingToken(ctx, client, "stock", stale))
+	assert.NoError(t, ValidateFencingToken(ctx, client, "stock", fresh))
+	assert.Equal(t, ErrLockNotHeld, alice.Unlock("stock"))
+}
+
+func TestWatchdogRenewsLock(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	alice := newReentrantLock(client, "alice")
+	alice.SetExpiration(300 * time.Millisecond)
+
+	_, err := alice.Lock("job")
+	require.NoError(t, err)
+	mr.SetTTL(lockKey("job"), 10*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	assert.Greater(t, mr.TTL(lockKey("job")), 100*time.Millisecond)
+
+	require.NoError(t, alice.Unlock("job"))
+	assert.False(t, mr.Exists(lockKey("job")))
+}
+
+func TestRWLockSharedReaders(t *testing.T) {
+	_, client := newFakeRedis(t)
+	r1, r2, w := newRWLock(client, "r1"), newRWLock(client, "r2"), newRWLock(client, "w")
+
+	ok, err := r1.TryRLock("catalog")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = r2.TryRLock("catalog")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = w.TryLock("catalog")
+	require.NoError(t, err)
+	assert.False(t, ok, "writer waits for readers")
+
+	require.NoError(t, r1.RUnlock("catalog"))
+	_, ok, _ = w.TryLock("catalog")
+	assert.False(t, ok)
+	require.NoError(t, r2.RUnlock("catalog"))
+
+	token, ok, err := w.TryLock("catalog")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Positive(t, token)
+
+	ok, err = r1.TryRLock("catalog")
+	require.NoError(t, err)
+	assert.False(t, ok, "readers wait for writer")
+
+	assert.Equal(t, ErrLockNotHeld, r1.RUnlock("catalog"))
+	require.NoError(t, w.Unlock("catalog"))
+	ok, _ = r1.TryRLock("catalog")
+	assert.True(t, ok)
+}
+
+func TestRWLockWriterReentry(t *testing.T) {
+	_, client := newFakeRedis(t)
+	w, other := newRWLock(client, "w"), newRWLock(client, "other")
+	rl := newReentrantLock(client, "rl")
+
+	first, err := w.Lock("catalog")
+	require.NoError(t, err)
+	second, err := w.Lock("catalog")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	_, ok, _ := other.TryLock("catalog")
+	assert.False(t, ok)
+	require.NoError(t, w.Unlock("catalog"))
+	_, ok, _ = other.TryLock("catalog")
+	assert.False(t, ok)
+	require.NoError(t, w.Unlock("catalog"))
+
+	third, ok, err := other.TryLock("catalog")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Greater(t, third, second)
+
+	// 读写锁与可重入锁共用 fencing 计数器
+	token, err := rl.Lock("catalog")
+	require.NoError(t, err)
+	assert.Greater(t, token, third)
+}
+
+func newRedlockDLM(nodes []redis.Cmdable) *DLMImpl {
+	if mutex == nil {
+		mutex = &sync.Mutex{}
+	}
+	return &DLMImpl{
+		value:      newOwnerID(),
+		expiration: time.Second,
+		ctx:        context.Background(),
+		nodes:      nodes,
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	servers := make([]*miniredis.Miniredis, 3)
+	nodes := make([]redis.Cmdable, 3)
+	for i := range nodes {
+		servers[i], nodes[i] = newFakeRedis(t)
+	}
+	alice, bob := newRedlockDLM(nodes), newRedlockDLM(nodes)
+
+	// 一个节点故障时仍可在多数节点上加锁
+	servers[2].Close()
+	ok, err := alice.TryLock("invoice")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = bob.TryLock("invoice")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, alice.Unlock("invoice"))
+	assert.False(t, servers[0].Exists("invoice"))
+	assert.False(t, servers[1].Exists("invoice"))
+
+	// 存活节点中只有一个可加锁时未达到多数，已加锁的节点被释放
+	servers[0].Set("invoice", "stale-owner")
+	ok, err = bob.TryLock("invoice")
+	require.NoError(t, err)
+	assert.False(t, ok, "only one of two live nodes acquired")
+	assert.False(t, servers[1].Exists("invoice"), "partial locks are released")
+
+	// 多数节点不可用时返回错误
+	servers[1].Close()
+	servers[0].Del("invoice")
+	ok, err = bob.TryLock("invoice")
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestSemaphoreLimit(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	sem := &SemaphoreImpl{client: client, expiration: time.Second, dogs: newWatchdogs(), held: make(map[Lease]struct{})}
+
+	first, ok, err := sem.TryAcquire("export", 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = sem.TryAcquire("export", 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = sem.TryAcquire("export", 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, sem.Release(first))
+	assert.Equal(t, ErrLockNotHeld, sem.Release(first))
+	_, ok, _ = sem.TryAcquire("export", 2)
+	assert.True(t, ok)
+
+	// 崩溃进程的租约到期后被清理
+	sem.releaseAll()
+	mr.ZAdd(semaphoreKey("export"), float64(nowMillis()-1), "crashed")
+	count, err := sem.Count("export")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	_, ok, _ = sem.TryAcquire("export", 1)
+	assert.True(t, ok)
+}
+
+func newElection(client redis.Cmdable, candidate string) *ElectionImpl {
+	return &ElectionImpl{
+		client:     client,
+		candidate:  candidate,
+		expiration: 300 * time.Millisecond,
+		terms:      make(map[string]*term),
+		changed:    make(map[string]chan struct{}),
+	}
+}
+
+func TestElectionObserveAndResign(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	a, b := newElection(client, "a"), newElection(client, "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := b.Observe(ctx, "retry-pub")
+	assert.Equal(t, "", <-changes)
+
+	ok, err := a.TryCampaign("retry-pub")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = b.TryCampaign("retry-pub")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "a", <-changes)
+
+	// 看门狗续期
+	time.Sleep(400 * time.Millisecond)
+	assert.True(t, mr.Exists(leaderKey("retry-pub")))
+
+	require.NoError(t, a.Resign("retry-pub"))
+	assert.False(t, a.IsLeader("retry-pub"))
+	assert.Equal(t, "", <-changes)
+	require.NoError(t, b.Campaign(ctx, "retry-pub"))
+	assert.Equal(t, "b", <-changes)
+}
+
+func TestRunAsLeaderFailover(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	a, b := newElection(client, "a"), newElection(client, "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running := make(chan string, 2)
+	run := func(e *ElectionImpl) {
+		e.RunAsLeader(ctx, "loop", func(ctx context.Context) {
+			running <- e.Candidate()
+			<-ctx.Done()
+		})
+	}
+	go run(a)
+	assert.Equal(t, "a", <-running)
+	go run(b)
+
+	// a 的 leader 被删除后 a 停止执行，b 接管
+	mr.Del(leaderKey("loop"))
+	select {
+	case leader := <-running:
+		assert.Equal(t, "b", leader)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no failover")
+	}
+	assert.Eventually(t, func() bool { return !a.IsLeader("loop") }, time.Second, 10*time.Millisecond)
+	assert.True(t, b.IsLeader("loop"))
+}
+
+func TestElectionStepsDownBeforeExpiry(t *testing.T) {
+	mr, client := newFakeRedis(t)
+	a := newElection(client, "a")
+	start := time.Now()
+	ok, err := a.TryCampaign("expiry")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// redis 不可用时 leader 在 key 过期前(有效期 - 续期间隔)失去 leader
+	mr.SetError("connection refused")
+	assert.Eventually(t, func() bool { return !a.IsLeader("expiry") }, time.Second, 5*time.Millisecond)
+	assert.Less(t, int64(time.Since(start)), int64(300*time.Millisecond))
+}
+
+func TestSetExpirationConcurrent(t *testing.T) {
+	_, client := newFakeRedis(t)
+	e := newElection(client, "a")
+	s := &SemaphoreImpl{client: client, expiration: time.Second, dogs: newWatchdogs(), held: make(map[Lease]struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			e.SetExpiration(time.Second)
+			s.SetExpiration(time.Second)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		e.TryCampaign("concurrent")
+		lease, ok, err := s.TryAcquire("concurrent", 200)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, s.Release(lease))
+	}
+	<-done
+	require.NoError(t, e.Resign("concurrent"))
+}
@@ -0,0 +1,336 @@
+package dlm
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+leader 选举组件
+	1.同一选举只有一个候选者成为 leader，例如只由一个 pod 执行领域事件的重试循环
+	2.leader 由看门狗续期，续期失败且 key 将在下一次续期前过期时视为失去 leader，进程崩溃后随 key 过期重新选举
+	3.Observe 通知 leader 的变化，本进程的变化立即通知，其它进程的变化在 有效期/3 内通知
+	4.RunAsLeader 成为 leader 后执行函数，失去 leader 时取消函数的 ctx 并重新参选
+	5.单例组件，服务关闭时放弃本进程当选的全部选举
+
+Created by Dustin.zhu on 2023/09/22.
+*/
+
+var election *ElectionImpl
+
+func init() {
+	election = &ElectionImpl{
+		candidate:  newCandidateID(),
+		expiration: DefaultExpiration * time.Second,
+		terms:      make(map[string]*term),
+		changed:    make(map[string]chan struct{}),
+	}
+	dt.Prepare(func(initiator dt.Initiator) {
+		// 单例
+		initiator.BindInfra(true, initiator.IsPrivate(), election)
+	})
+}
+
+// GetElection .
+func GetElection() *ElectionImpl {
+	return election
+}
+
+// campaignScript KEYS[1] 选举 ARGV[1] 候选者 ARGV[2] 有效期(毫秒)
+var campaignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// Election .
+type Election interface {
+	// SetExpiration leader 有效期 默认30秒，进程级配置对全部选举生效，应在启动阶段设置
+	SetExpiration(time.Duration) Election
+	// Candidate 本进程的候选者ID
+	Candidate() string
+	// Campaign blocked until become the leader or ctx done
+	Campaign(ctx context.Context, name string) error
+	// TryCampaign try become the leader only once
+	TryCampaign(name string) (bool, error)
+	// Resign 放弃 leader
+	Resign(name string) error
+	// IsLeader 本进程是否为 leader
+	IsLeader(name string) bool
+	// Leader 当前 leader 的候选者ID 没有 leader 时返回空
+	Leader(name string) (string, error)
+	// Observe leader 变化时发送新的 leader，ctx 结束时关闭
+	Observe(ctx context.Context, name string) <-chan string
+	// RunAsLeader 当选后执行 f，失去 leader 时取消 f 的 ctx 并重新参选，直到 ctx 结束
+	RunAsLeader(ctx context.Context, name string, f func(ctx context.Context))
+}
+
+var _ Election = (*ElectionImpl)(nil)
+
+// ElectionImpl .
+type ElectionImpl struct {
+	dt.Infra
+	client     redis.Cmdable
+	candidate  string
+	expiration time.Duration // 由 mu 保护
+	mu         sync.Mutex
+	terms      map[string]*term         // 本进程当选的选举
+	changed    map[string]chan struct{} // 本进程 leader 变化时关闭，唤醒 Observe
+}
+
+// term 任期
+type term struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Booting .
+func (e *ElectionImpl) Booting(singleBoot dt.SingleBoot) {
+	if e.client == nil {
+		e.client = e.Redis()
+	}
+	singleBoot.RegisterShutdown(e.resignAll)
+}
+
+// SetExpiration 已当选的选举在下次参选时使用新的有效期
+func (e *ElectionImpl) SetExpiration(ex time.Duration) Election {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expiration = ex
+	return e
+}
+
+func (e *ElectionImpl) ttl() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expiration
+}
+
+// Candidate .
+func (e *ElectionImpl) Candidate() string {
+	return e.candidate
+}
+
+// Campaign .
+func (e *ElectionImpl) Campaign(ctx context.Context, name string) error {
+	return waitLock(ctx, func() (bool, error) {
+		return e.TryCampaign(name)
+	})
+}
+
+// TryCampaign .
+func (e *ElectionImpl) TryCampaign(name string) (bool, error) {
+	if e.IsLeader(name) {
+		return true, nil
+	}
+	expiration := e.ttl()
+	start := time.Now()
+	ok, err := campaignScript.Run(context.Background(), e.client, []string{leaderKey(name)}, e.candidate, expiration.Milliseconds()).Bool()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.mu.Lock()
+	e.terms[name] = &term{ctx: ctx, cancel: cancel}
+	e.mu.Unlock()
+	e.notify(name)
+	go e.keepalive(ctx, name, expiration, start)
+	return true, nil
+}
+
+// keepalive 续期，续期失败且 key 可能在下一次续期前过期时失去 leader
+// 以发起续期的时间计算 key 的过期时间，并提前一个续期间隔，保证其它进程当选前本进程已不再是 leader
+func (e *ElectionImpl) keepalive(ctx context.Context, name string, expiration time.Duration, renewedAt time.Time) {
+	interval := expiration / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			renewCtx, cancel := context.WithTimeout(ctx, interval/2)
+			ok, err := redlockRenewScript.Run(renewCtx, e.client, []string{leaderKey(name)}, e.candidate, expiration.Milliseconds()).Bool()
+			cancel()
+			if err == nil && ok {
+				renewedAt = start
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil || time.Now().After(renewedAt.Add(expiration-interval)) {
+				dt.Logger().Warnf("election: lost leader of %s, err: %v", name, err)
+				e.endTerm(name)
+				return
+			}
+		}
+	}
+}
+
+// Resign .
+func (e *ElectionImpl) Resign(name string) error {
+	if !e.endTerm(name) {
+		return nil
+	}
+	_, err := redlockUnlockScript.Run(context.Background(), e.client, []string{leaderKey(name)}, e.candidate).Result()
+	return err
+}
+
+// IsLeader .
+func (e *ElectionImpl) IsLeader(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.terms[name]
+	return ok
+}
+
+// Leader .
+func (e *ElectionImpl) Leader(name string) (string, error) {
+	leader, err := e.client.Get(context.Background(), leaderKey(name)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return leader, err
+}
+
+// Observe .
+func (e *ElectionImpl) Observe(ctx context.Context, name string) <-chan string {
+	ch := make(chan string, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(e.ttl() / 3)
+		defer ticker.Stop()
+		last := ""
+		for first := true; ; first = false {
+			changed := e.changedCh(name)
+			if leader, err := e.Leader(name); err == nil && (first || leader != last) {
+				last = leader
+				select {
+				case ch <- leader:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-changed:
+			}
+		}
+	}()
+	return ch
+}
+
+// RunAsLeader .
+func (e *ElectionImpl) RunAsLeader(ctx context.Context, name string, f func(ctx context.Context)) {
+	for {
+		if err := e.Campaign(ctx, name); err != nil {
+			if ctx.Err() == nil {
+				dt.Logger().Errorf("election: campaign %s failed, err: %v", name, err)
+			}
+			return
+		}
+		termCtx, ok := e.termCtx(name)
+		if !ok {
+			continue
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-termCtx.Done():
+				cancel()
+			case <-runCtx.Done():
+			}
+		}()
+		f(runCtx)
+		cancel()
+		// 函数正常结束或 ctx 结束时放弃 leader，失去 leader 时重新参选
+		if ctx.Err() != nil || termCtx.Err() == nil {
+			e.Resign(name)
+			return
+		}
+	}
+}
+
+// resignAll 服务关闭时放弃本进程当选的选举
+func (e *ElectionImpl) resignAll() {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.terms))
+	for name := range e.terms {
+		names = append(names, name)
+	}
+	e.mu.Unlock()
+	for _, name := range names {
+		if err := e.Resign(name); err != nil {
+			dt.Logger().Warnf("election: resign %s failed, err: %v", name, err)
+		}
+	}
+}
+
+// endTerm 结束任期，本进程不是 leader 时返回 false
+func (e *ElectionImpl) endTerm(name string) bool {
+	e.mu.Lock()
+	t, ok := e.terms[name]
+	delete(e.terms, name)
+	e.mu.Unlock()
+	if !ok {
+		return false
+	}
+	t.cancel()
+	e.notify(name)
+	return true
+}
+
+func (e *ElectionImpl) termCtx(name string) (context.Context, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.terms[name]
+	if !ok {
+		return nil, false
+	}
+	return t.ctx, true
+}
+
+func (e *ElectionImpl) changedCh(name string) chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, ok := e.changed[name]
+	if !ok {
+		ch = make(chan struct{})
+		e.changed[name] = ch
+	}
+	return ch
+}
+
+// notify 唤醒 name 的全部 Observe
+func (e *ElectionImpl) notify(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ch, ok := e.changed[name]; ok {
+		close(ch)
+		delete(e.changed, name)
+	}
+}
+
+func leaderKey(name string) string {
+	return "dt:dlm:leader:{" + name + "}"
+}
+
+func newCandidateID() string {
+	host, _ := os.Hostname()
+	return host + "-" + newOwnerID()[:8]
+}
@@ -0,0 +1,43 @@
+package dlm
+
+import (
+	"context"
+	"errors"
+
+	redis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+/**
+fencing token 校验
+	锁的持有者暂停(GC、网络分区)期间锁可能过期并被其它持有者获取，暂停恢复后的写入需要被拒绝
+	1.ValidateFencingToken 写入前确认 token 仍是最新分配的 token
+	2.Fenced 将 token 作为写入条件，存储侧拒绝比已写入 token 更旧的写入
+
+Created by Dustin.zhu on 2023/09/18.
+*/
+
+// ErrStaleFencingToken 锁已被其它持有者获取
+var ErrStaleFencingToken = errors.New("stale fencing token")
+
+// ValidateFencingToken token 小于 key 最新分配的 token 时返回 ErrStaleFencingToken
+func ValidateFencingToken(ctx context.Context, client redis.Cmdable, key string, token int64) error {
+	latest, err := client.Get(ctx, fenceKey(key)).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if token < latest {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
+// Fenced 仅更新 column 不大于 token 的行，写入时需要同时将 column 更新为 token
+// RowsAffected 为0时说明已有更新的持有者写入
+//
+//	db.Model(&order).Scopes(dlm.Fenced("fence_token", token)).Updates(map[string]interface{}{"fence_token": token, "status": 2})
+func Fenced(column string, token int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(db.Statement.Quote(column)+" <= ?", token)
+	}
+}
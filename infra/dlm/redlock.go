@@ -0,0 +1,142 @@
+package dlm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+Redlock 多节点分布式锁
+	1.InstallRedlock 安装 N 个独立部署的 redis 节点后，DLM 组件自动使用多节点模式，接口不变
+	2.在多数节点(N/2+1)上加锁成功，且扣除加锁耗时与时钟漂移后锁仍有效，才视为获取锁
+	3.获取失败时释放所有节点上的锁，看门狗在多数节点上续期，续期不足多数时停止
+	4.解锁时释放所有节点
+
+Created by Dustin.zhu on 2023/09/20.
+*/
+
+const (
+	// ClockDriftFactor 时钟漂移系数
+	ClockDriftFactor = 0.01
+	// maxNodeTimeout 单个节点的请求超时，远小于锁有效期，避免在故障节点上等待
+	maxNodeTimeout = 50 * time.Millisecond
+)
+
+var (
+	redlockMu    sync.RWMutex
+	redlockNodes []redis.Cmdable
+
+	// redlockUnlockScript KEYS[1] 锁 ARGV[1] 持有者
+	redlockUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+	// redlockRenewScript KEYS[1] 锁 ARGV[1] 持有者 ARGV[2] 有效期(毫秒)
+	redlockRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// InstallRedlock 安装多节点分布式锁的 redis 节点，建议为奇数且不少于3个
+//
+//	dlm.InstallRedlock(utils.ConnectRedlockNodes(*cfg.Redis)...)
+func InstallRedlock(nodes ...redis.Cmdable) {
+	redlockMu.Lock()
+	defer redlockMu.Unlock()
+	redlockNodes = nodes
+}
+
+func currentRedlockNodes() []redis.Cmdable {
+	redlockMu.RLock()
+	defer redlockMu.RUnlock()
+	return redlockNodes
+}
+
+// quorum .
+func quorum(nodes []redis.Cmdable) int {
+	return len(nodes)/2 + 1
+}
+
+// nodeTimeout 单个节点的请求超时
+func nodeTimeout(expiration time.Duration) time.Duration {
+	if timeout := expiration / 10; timeout < maxNodeTimeout {
+		return timeout
+	}
+	return maxNodeTimeout
+}
+
+// tryLockQuorum 并发在所有节点加锁，返回扣除耗时与时钟漂移后的剩余有效期
+func (dlm *DLMImpl) tryLockQuorum(key string) (bool, error) {
+	start := time.Now()
+	acquired, errs := forEachNode(dlm.ctx, dlm.nodes, nodeTimeout(dlm.expiration), func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return node.SetNX(ctx, key, dlm.value, dlm.expiration).Result()
+	})
+	drift := time.Duration(float64(dlm.expiration)*ClockDriftFactor) + 2*time.Millisecond
+	validity := dlm.expiration - time.Since(start) - drift
+	if acquired >= quorum(dlm.nodes) && validity > 0 {
+		return true, nil
+	}
+
+	dlm.unlockAll(key)
+	if len(errs) >= quorum(dlm.nodes) {
+		return false, fmt.Errorf("redlock: quorum unavailable, %v", errs[0])
+	}
+	return false, nil
+}
+
+// renewQuorum 在多数节点续期成功时返回 true
+func (dlm *DLMImpl) renewQuorum(ctx context.Context, key string) bool {
+	renewed, _ := forEachNode(ctx, dlm.nodes, nodeTimeout(dlm.expiration), func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return redlockRenewScript.Run(ctx, node, []string{key}, dlm.value, dlm.expiration.Milliseconds()).Bool()
+	})
+	return renewed >= quorum(dlm.nodes)
+}
+
+// unlockAll 释放所有节点，返回释放成功的节点数
+func (dlm *DLMImpl) unlockAll(key string) int {
+	released, errs := forEachNode(context.Background(), dlm.nodes, nodeTimeout(dlm.expiration), func(ctx context.Context, node redis.Cmdable) (bool, error) {
+		return redlockUnlockScript.Run(ctx, node, []string{key}, dlm.value).Bool()
+	})
+	for _, err := range errs {
+		dt.Logger().Warnf("redlock: unlock %s failed on node, err: %v", key, err)
+	}
+	return released
+}
+
+// forEachNode 并发执行 f，返回成功的节点数与失败的错误
+func forEachNode(ctx context.Context, nodes []redis.Cmdable, timeout time.Duration, f func(ctx context.Context, node redis.Cmdable) (bool, error)) (succeeded int, errs []error) {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node redis.Cmdable) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			ok, err := f(nodeCtx, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && err != redis.Nil {
+				errs = append(errs, err)
+				return
+			}
+			if ok {
+				succeeded++
+			}
+		}(node)
+	}
+	wg.Wait()
+	return
+}
@@ -0,0 +1,259 @@
+package dlm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+可重入分布式锁组件
+	1.锁以 hash 保存持有者与持有次数，同一持有者可重复加锁，解锁次数与加锁次数相同时释放
+	2.首次获取锁时分配单调递增的 fencing token，重入时返回同一个 token
+	3.持有期间由看门狗按持有者续期，全部释放后停止续期
+
+Created by Dustin.zhu on 2023/09/18.
+*/
+
+func init() {
+	dt.Prepare(func(initiator dt.Initiator) {
+		initiator.BindInfra(false, initiator.IsPrivate(), func() *ReentrantLockImpl {
+			return &ReentrantLockImpl{}
+		})
+	})
+}
+
+// ErrLockNotHeld 解锁时当前持有者未持有锁
+var ErrLockNotHeld = errors.New("lock not held by owner")
+
+var (
+	// reentrantLockScript KEYS[1] 锁 KEYS[2] fencing 计数器 ARGV[1] 持有者 ARGV[2] 有效期(毫秒)
+	// 返回 fencing token，被其它持有者占用时返回 0
+	reentrantLockScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if not owner then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'count', 1, 'token', token)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return token
+end
+if owner == ARGV[1] then
+	redis.call('HINCRBY', KEYS[1], 'count', 1)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('HGET', KEYS[1], 'token'))
+end
+return 0`)
+
+	// reentrantUnlockScript KEYS[1] 锁 ARGV[1] 持有者，返回剩余持有次数，未持有时返回 -1
+	reentrantUnlockScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'owner') ~= ARGV[1] then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[1], 'count', -1)
+if count <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return count`)
+
+	// reentrantRenewScript KEYS[1] 锁 ARGV[1] 持有者 ARGV[2] 有效期(毫秒)
+	reentrantRenewScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'owner') == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// ReentrantLock .
+type ReentrantLock interface {
+	// SetExpiration Set a timeout period with a default duration of 30 second.
+	SetExpiration(time.Duration) ReentrantLock
+	// SetContext The timeout for obtaining locks can be controlled through context
+	SetContext(ctx context.Context) ReentrantLock
+	// SetOwner 设置持有者 默认每个请求生成一个持有者
+	SetOwner(owner string) ReentrantLock
+	// Owner 当前持有者
+	Owner() string
+	// Lock blocked until get lock, returns the fencing token
+	Lock(key string) (token int64, err error)
+	// TryLock try get lock only once, returns the fencing token if get the lock
+	TryLock(key string) (token int64, ok bool, err error)
+	// Unlock 持有次数减一，减为0时释放锁
+	Unlock(key string) error
+}
+
+// ReentrantLockImpl .
+type ReentrantLockImpl struct {
+	dt.Infra
+	owner      string
+	client     redis.Cmdable
+	expiration time.Duration
+	ctx        context.Context
+	watchdogs  *watchdogs
+}
+
+// BeginRequest .
+func (l *ReentrantLockImpl) BeginRequest(worker dt.Worker) {
+	l.expiration = DefaultExpiration * time.Second
+	l.client = l.Redis()
+	l.ctx = context.Background()
+	l.owner = newOwnerID()
+	l.watchdogs = newWatchdogs()
+	l.Infra.BeginRequest(worker)
+}
+
+// SetExpiration .
+func (l *ReentrantLockImpl) SetExpiration(ex time.Duration) ReentrantLock {
+	l.expiration = ex
+	return l
+}
+
+// SetContext .
+func (l *ReentrantLockImpl) SetContext(ctx context.Context) ReentrantLock {
+	l.ctx = ctx
+	return l
+}
+
+// SetOwner .
+func (l *ReentrantLockImpl) SetOwner(owner string) ReentrantLock {
+	l.owner = owner
+	return l
+}
+
+// Owner .
+func (l *ReentrantLockImpl) Owner() string {
+	return l.owner
+}
+
+// Lock .
+func (l *ReentrantLockImpl) Lock(key string) (token int64, err error) {
+	err = waitLock(l.ctx, func() (ok bool, err error) {
+		token, ok, err = l.TryLock(key)
+		return
+	})
+	return
+}
+
+// TryLock .
+func (l *ReentrantLockImpl) TryLock(key string) (token int64, ok bool, err error) {
+	token, err = reentrantLockScript.Run(l.ctx, l.client, []string{lockKey(key), fenceKey(key)}, l.owner, l.expiration.Milliseconds()).Int64()
+	if err != nil || token == 0 {
+		return 0, false, err
+	}
+	client, owner, expiration := l.client, l.owner, l.expiration
+	l.watchdogs.start(key, expiration, func(ctx context.Context) (bool, error) {
+		return reentrantRenewScript.Run(ctx, client, []string{lockKey(key)}, owner, expiration.Milliseconds()).Bool()
+	})
+	return token, true, nil
+}
+
+// Unlock .
+func (l *ReentrantLockImpl) Unlock(key string) error {
+	count, err := reentrantUnlockScript.Run(context.Background(), l.client, []string{lockKey(key)}, l.owner).Int64()
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return ErrLockNotHeld
+	}
+	l.watchdogs.stop(key)
+	return nil
+}
+
+// lockKey 锁与 fencing 计数器使用相同的 hash tag，集群模式下位于同一 slot
+func lockKey(key string) string {
+	return "dt:dlm:{" + key + "}"
+}
+
+func fenceKey(key string) string {
+	return "dt:dlm:{" + key + "}:fence"
+}
+
+func newOwnerID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// waitLock 每 DefaultTryLockInterval 尝试一次，直到获取锁或 ctx 结束
+func waitLock(ctx context.Context, try func() (bool, error)) error {
+	ok, err := try()
+	if err != nil || ok {
+		return err
+	}
+	ticker := time.NewTicker(DefaultTryLockInterval * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, err = try()
+			if err != nil || ok {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("get lock timeout.")
+		}
+	}
+}
+
+// watchdogs 按 key 记录持有次数，每个 key 只有一个续期协程，持有次数归零时停止
+type watchdogs struct {
+	mu    sync.Mutex
+	holds map[string]*watchdog
+}
+
+type watchdog struct {
+	count  int
+	cancel context.CancelFunc
+}
+
+func newWatchdogs() *watchdogs {
+	return &watchdogs{holds: make(map[string]*watchdog)}
+}
+
+func (w *watchdogs) start(key string, expiration time.Duration, renew func(ctx context.Context) (bool, error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if dog, ok := w.holds[key]; ok {
+		dog.count++
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.holds[key] = &watchdog{count: 1, cancel: cancel}
+	go func() {
+		ticker := time.NewTicker(expiration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// 锁已丢失时停止续期
+				if ok, err := renew(ctx); err == nil && !ok {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (w *watchdogs) stop(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dog, ok := w.holds[key]
+	if !ok {
+		return
+	}
+	if dog.count--; dog.count <= 0 {
+		dog.cancel()
+		delete(w.holds, key)
+	}
+}
@@ -0,0 +1,215 @@
+package dlm
+
+import (
+	"context"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+分布式读写锁组件
+	1.读锁共享，写锁独占，读写锁均可由同一持有者重入
+	2.写锁首次获取时分配 fencing token，与 ReentrantLock 共用计数器
+	3.持有写锁时不能再获取读锁，持有读锁时不能升级为写锁
+	4.读锁共用一个有效期，宕机的读者在其余读者全部释放后随锁过期清理
+
+Created by Dustin.zhu on 2023/09/18.
+*/
+
+func init() {
+	dt.Prepare(func(initiator dt.Initiator) {
+		initiator.BindInfra(false, initiator.IsPrivate(), func() *RWLockImpl {
+			return &RWLockImpl{}
+		})
+	})
+}
+
+var (
+	// readLockScript KEYS[1] 锁 ARGV[1] 持有者 ARGV[2] 有效期(毫秒)
+	readLockScript = redis.NewScript(`
+local mode = redis.call('HGET', KEYS[1], 'mode')
+if mode == 'write' then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'mode', 'read')
+redis.call('HINCRBY', KEYS[1], 'r:' .. ARGV[1], 1)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1`)
+
+	// writeLockScript KEYS[1] 锁 KEYS[2] fencing 计数器 ARGV[1] 持有者 ARGV[2] 有效期(毫秒)
+	writeLockScript = redis.NewScript(`
+local mode = redis.call('HGET', KEYS[1], 'mode')
+if not mode then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1], 'mode', 'write', 'w:' .. ARGV[1], 1, 'token', token)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return token
+end
+if mode == 'write' and redis.call('HEXISTS', KEYS[1], 'w:' .. ARGV[1]) == 1 then
+	redis.call('HINCRBY', KEYS[1], 'w:' .. ARGV[1], 1)
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return tonumber(redis.call('HGET', KEYS[1], 'token'))
+end
+return 0`)
+
+	// rwUnlockScript KEYS[1] 锁 ARGV[1] 持有者字段，返回剩余持有次数，未持有时返回 -1
+	rwUnlockScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local count = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if count > 0 then
+	return count
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+local fields = redis.call('HKEYS', KEYS[1])
+for i = 1, #fields do
+	if fields[i] ~= 'mode' and fields[i] ~= 'token' then
+		return 0
+	end
+end
+redis.call('DEL', KEYS[1])
+return 0`)
+
+	// rwRenewScript KEYS[1] 锁 ARGV[1] 持有者字段 ARGV[2] 有效期(毫秒)
+	rwRenewScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// RWLock .
+type RWLock interface {
+	// SetExpiration Set a timeout period with a default duration of 30 second.
+	SetExpiration(time.Duration) RWLock
+	// SetContext The timeout for obtaining locks can be controlled through context
+	SetContext(ctx context.Context) RWLock
+	// SetOwner 设置持有者 默认每个请求生成一个持有者
+	SetOwner(owner string) RWLock
+	// RLock blocked until get read lock
+	RLock(key string) error
+	// TryRLock try get read lock only once
+	TryRLock(key string) (bool, error)
+	// RUnlock release the read lock
+	RUnlock(key string) error
+	// Lock blocked until get write lock, returns the fencing token
+	Lock(key string) (token int64, err error)
+	// TryLock try get write lock only once, returns the fencing token if get the lock
+	TryLock(key string) (token int64, ok bool, err error)
+	// Unlock release the write lock
+	Unlock(key string) error
+}
+
+// RWLockImpl .
+type RWLockImpl struct {
+	dt.Infra
+	owner      string
+	client     redis.Cmdable
+	expiration time.Duration
+	ctx        context.Context
+	readers    *watchdogs
+	writers    *watchdogs
+}
+
+// BeginRequest .
+func (l *RWLockImpl) BeginRequest(worker dt.Worker) {
+	l.expiration = DefaultExpiration * time.Second
+	l.client = l.Redis()
+	l.ctx = context.Background()
+	l.owner = newOwnerID()
+	l.readers = newWatchdogs()
+	l.writers = newWatchdogs()
+	l.Infra.BeginRequest(worker)
+}
+
+// SetExpiration .
+func (l *RWLockImpl) SetExpiration(ex time.Duration) RWLock {
+	l.expiration = ex
+	return l
+}
+
+// SetContext .
+func (l *RWLockImpl) SetContext(ctx context.Context) RWLock {
+	l.ctx = ctx
+	return l
+}
+
+// SetOwner .
+func (l *RWLockImpl) SetOwner(owner string) RWLock {
+	l.owner = owner
+	return l
+}
+
+// RLock .
+func (l *RWLockImpl) RLock(key string) error {
+	return waitLock(l.ctx, func() (bool, error) {
+		return l.TryRLock(key)
+	})
+}
+
+// TryRLock .
+func (l *RWLockImpl) TryRLock(key string) (bool, error) {
+	ok, err := readLockScript.Run(l.ctx, l.client, []string{rwLockKey(key)}, l.owner, l.expiration.Milliseconds()).Bool()
+	if err != nil || !ok {
+		return false, err
+	}
+	l.readers.start(key, l.expiration, l.renew(key, "r:"+l.owner))
+	return true, nil
+}
+
+// RUnlock .
+func (l *RWLockImpl) RUnlock(key string) error {
+	return l.unlock(key, "r:"+l.owner, l.readers)
+}
+
+// Lock .
+func (l *RWLockImpl) Lock(key string) (token int64, err error) {
+	err = waitLock(l.ctx, func() (ok bool, err error) {
+		token, ok, err = l.TryLock(key)
+		return
+	})
+	return
+}
+
+// TryLock .
+func (l *RWLockImpl) TryLock(key string) (token int64, ok bool, err error) {
+	token, err = writeLockScript.Run(l.ctx, l.client, []string{rwLockKey(key), fenceKey(key)}, l.owner, l.expiration.Milliseconds()).Int64()
+	if err != nil || token == 0 {
+		return 0, false, err
+	}
+	l.writers.start(key, l.expiration, l.renew(key, "w:"+l.owner))
+	return token, true, nil
+}
+
+// Unlock .
+func (l *RWLockImpl) Unlock(key string) error {
+	return l.unlock(key, "w:"+l.owner, l.writers)
+}
+
+func (l *RWLockImpl) unlock(key, field string, dogs *watchdogs) error {
+	count, err := rwUnlockScript.Run(context.Background(), l.client, []string{rwLockKey(key)}, field).Int64()
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return ErrLockNotHeld
+	}
+	dogs.stop(key)
+	return nil
+}
+
+func (l *RWLockImpl) renew(key, field string) func(ctx context.Context) (bool, error) {
+	client, expiration := l.client, l.expiration
+	return func(ctx context.Context) (bool, error) {
+		return rwRenewScript.Run(ctx, client, []string{rwLockKey(key)}, field, expiration.Milliseconds()).Bool()
+	}
+}
+
+// rwLockKey 与 fencing 计数器使用相同的 hash tag
+func rwLockKey(key string) string {
+	return "dt:dlm:{" + key + "}:rw"
+}
@@ -0,0 +1,192 @@
+package dlm
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+分布式信号量组件
+	1.限制集群内同一资源的并发数，例如全集群最多 N 个导出任务
+	2.每次获取得到一个租约，租约以 zset 保存，score 为租约到期时间
+	3.持有期间由看门狗续期，进程崩溃后租约到期自动清理，获取时顺带清理过期租约
+	4.单例组件，服务关闭时释放本进程持有的全部租约
+	5.租约到期时间使用本机时间，各节点需要时钟同步
+
+Created by Dustin.zhu on 2023/09/22.
+*/
+
+var semaphore *SemaphoreImpl
+
+func init() {
+	semaphore = &SemaphoreImpl{expiration: DefaultExpiration * time.Second, dogs: newWatchdogs(), held: make(map[Lease]struct{})}
+	dt.Prepare(func(initiator dt.Initiator) {
+		// 单例
+		initiator.BindInfra(true, initiator.IsPrivate(), semaphore)
+	})
+}
+
+// GetSemaphore .
+func GetSemaphore() *SemaphoreImpl {
+	return semaphore
+}
+
+var (
+	// semAcquireScript KEYS[1] 信号量 ARGV[1] 租约 ARGV[2] 并发上限 ARGV[3] 当前时间(毫秒) ARGV[4] 有效期(毫秒)
+	semAcquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], tonumber(ARGV[3]) + tonumber(ARGV[4]), ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[4]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+end
+return 1`)
+
+	// semRenewScript KEYS[1] 信号量 ARGV[1] 租约 ARGV[2] 当前时间(毫秒) ARGV[3] 有效期(毫秒)
+	semRenewScript = redis.NewScript(`
+local expiry = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not expiry or tonumber(expiry) <= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], tonumber(ARGV[2]) + tonumber(ARGV[3]), ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1`)
+)
+
+// Lease 信号量租约
+type Lease struct {
+	Name string // 信号量
+	ID   string // 租约ID
+}
+
+// Semaphore .
+type Semaphore interface {
+	// SetExpiration 租约有效期 默认30秒，进程级配置对全部信号量生效，应在启动阶段设置
+	SetExpiration(time.Duration) Semaphore
+	// Acquire blocked until get a lease or ctx done
+	Acquire(ctx context.Context, name string, limit int) (Lease, error)
+	// TryAcquire try get a lease only once
+	TryAcquire(name string, limit int) (lease Lease, ok bool, err error)
+	// Release 释放租约，租约已过期时返回 ErrLockNotHeld
+	Release(lease Lease) error
+	// Count 当前有效的租约数
+	Count(name string) (int64, error)
+}
+
+var _ Semaphore = (*SemaphoreImpl)(nil)
+
+// SemaphoreImpl .
+type SemaphoreImpl struct {
+	dt.Infra
+	client     redis.Cmdable
+	expiration time.Duration // 由 mu 保护
+	dogs       *watchdogs
+	mu         sync.Mutex
+	held       map[Lease]struct{}
+}
+
+// Booting .
+func (s *SemaphoreImpl) Booting(singleBoot dt.SingleBoot) {
+	if s.client == nil {
+		s.client = s.Redis()
+	}
+	singleBoot.RegisterShutdown(s.releaseAll)
+}
+
+// SetExpiration 已持有的租约仍按获取时的有效期续期
+func (s *SemaphoreImpl) SetExpiration(ex time.Duration) Semaphore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiration = ex
+	return s
+}
+
+// Acquire .
+func (s *SemaphoreImpl) Acquire(ctx context.Context, name string, limit int) (lease Lease, err error) {
+	err = waitLock(ctx, func() (ok bool, err error) {
+		lease, ok, err = s.TryAcquire(name, limit)
+		return
+	})
+	return
+}
+
+// TryAcquire .
+func (s *SemaphoreImpl) TryAcquire(name string, limit int) (Lease, bool, error) {
+	lease := Lease{Name: name, ID: newOwnerID()}
+	s.mu.Lock()
+	expiration := s.expiration
+	s.mu.Unlock()
+	ok, err := semAcquireScript.Run(context.Background(), s.client, []string{semaphoreKey(name)},
+		lease.ID, limit, nowMillis(), expiration.Milliseconds()).Bool()
+	if err != nil || !ok {
+		return Lease{}, false, err
+	}
+
+	s.mu.Lock()
+	s.held[lease] = struct{}{}
+	s.mu.Unlock()
+	client := s.client
+	s.dogs.start(lease.key(), expiration, func(ctx context.Context) (bool, error) {
+		return semRenewScript.Run(ctx, client, []string{semaphoreKey(name)}, lease.ID, nowMillis(), expiration.Milliseconds()).Bool()
+	})
+	return lease, true, nil
+}
+
+// Release .
+func (s *SemaphoreImpl) Release(lease Lease) error {
+	s.mu.Lock()
+	delete(s.held, lease)
+	s.mu.Unlock()
+	s.dogs.stop(lease.key())
+
+	removed, err := s.client.ZRem(context.Background(), semaphoreKey(lease.Name), lease.ID).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Count .
+func (s *SemaphoreImpl) Count(name string) (int64, error) {
+	return s.client.ZCount(context.Background(), semaphoreKey(name), "("+strconv.FormatInt(nowMillis(), 10), "+inf").Result()
+}
+
+// releaseAll 服务关闭时释放本进程持有的租约
+func (s *SemaphoreImpl) releaseAll() {
+	s.mu.Lock()
+	leases := make([]Lease, 0, len(s.held))
+	for lease := range s.held {
+		leases = append(leases, lease)
+	}
+	s.mu.Unlock()
+	for _, lease := range leases {
+		if err := s.Release(lease); err != nil && err != ErrLockNotHeld {
+			dt.Logger().Warnf("semaphore: release %s failed, err: %v", lease.Name, err)
+		}
+	}
+}
+
+func (l Lease) key() string {
+	return l.Name + "/" + l.ID
+}
+
+func semaphoreKey(name string) string {
+	return "dt:dlm:sem:{" + name + "}"
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
@@ -23,6 +23,9 @@ import (
 //go:generate mockgen -package mock_infra -source manager.go -destination ./mock/domainevent_mock.go
 
 const (
+	tablePublish   = "domain_event_publish"
+	tableSubscribe = "domain_event_subscribe"
+
 	// DelayInterval 延迟启动间隔
 	DelayInterval int = 5
 	// RetryInterval 重试扫描失败的事件间隔
@@ -76,11 +79,14 @@ type EventManager interface {
 	DeleteSubEvent(eventID int) error
 	// SetSubEventFail 将订阅事件置为失败状态
 	SetSubEventFail(eventID int) error
+	// SetDataSource 设置领域事件表所在的数据源，默认为默认数据源
+	SetDataSource(name string)
 }
 
 type EventManagerImpl struct {
 	dt.Infra
 	uniqueID   uniqueid.Sonyflaker                      // 唯一性ID组件
+	datasource string                                   // 领域事件表所在的数据源
 	pubHandler func(topic string, content string) error // 发布事件函数 由使用方自定义
 	subHandler func(topic string, content string) error // 订阅事件函数 由使用方自定义
 }
@@ -100,6 +106,12 @@ func (m *EventManagerImpl) RegisterSubHandler(f func(topic string, content strin
 	m.subHandler = f
 }
 
+// SetDataSource 在 Application.Run 之前调用
+// 保存领域事件的事务需使用同一数据源 EventTransaction.WithDataSource
+func (m *EventManagerImpl) SetDataSource(name string) {
+	m.datasource = name
+}
+
 // RetryPubEvent 定时器扫描表中失败的Pub事件
 func (m *EventManagerImpl) RetryPubEvent(app dt.Application) {
 	time.Sleep(time.Duration(DelayInterval) * time.Second) //延迟，等待程序Application.Run
@@ -146,7 +158,7 @@ func (m *EventManagerImpl) RetrySubEvent(app dt.Application) {
 
 // Save 保存领域事件
 func (m *EventManagerImpl) Save(repo *dt.Repository, entity dt.Entity) (err error) {
-	txDB := getTxDB(repo)
+	txDB := m.getTxDB(repo)
 
 	// 删除实体里的全部事件
 	defer entity.RemoveAllPubEvent()
@@ -156,8 +168,8 @@ func (m *EventManagerImpl) Save(repo *dt.Repository, entity dt.Entity) (err erro
 	// Insert PubEvent
 	for _, domainEvent := range entity.GetPubEvent() {
 		uid, _ := m.uniqueID.NextID()
-		sqlStr := "INSERT INTO %v.domain_event_publish (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)"
-		sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
+		sqlStr := "INSERT INTO %v (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)"
+		sqlStr = fmt.Sprintf(sqlStr, m.table(txDB, tablePublish))
 		txDB.Exec(sqlStr, uid, domainEvent.Topic(), string(domainEvent.Marshal()), ct, ct, 0)
 		domainEvent.SetIdentity(uid)
 	}
@@ -165,8 +177,8 @@ func (m *EventManagerImpl) Save(repo *dt.Repository, entity dt.Entity) (err erro
 
 	// Insert SubEvent
 	for _, subEvent := range entity.GetSubEvent() {
-		sqlStr := "INSERT INTO %v.domain_event_subscribe (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)"
-		sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
+		sqlStr := "INSERT INTO %v (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)"
+		sqlStr = fmt.Sprintf(sqlStr, m.table(txDB, tableSubscribe))
 		txDB.Exec(sqlStr, subEvent.Identity(), subEvent.Topic(), string(subEvent.Marshal()), ct, ct, 0)
 	}
 	return
@@ -174,9 +186,10 @@ func (m *EventManagerImpl) Save(repo *dt.Repository, entity dt.Entity) (err erro
 
 // DeleteSubEvent 删除领域订阅事件
 func (m *EventManagerImpl) DeleteSubEvent(eventID int) error {
-	sqlStr := "DELETE FROM %v.domain_event_subscribe WHERE id = ?"
-	sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
-	m.db().Exec(sqlStr, eventID)
+	db := m.db()
+	sqlStr := "DELETE FROM %v WHERE id = ?"
+	sqlStr = fmt.Sprintf(sqlStr, m.table(db, tableSubscribe))
+	db.Exec(sqlStr, eventID)
 	return nil
 }
 
@@ -186,10 +199,9 @@ func (m *EventManagerImpl) SetSubEventFail(eventID int) (err error) {
 	sub.SetStatus(1)
 	sub.SetUpdated(utils.NowTimestamp())
 	changes := sub.TakeChanges()
-	if changes != "" {
-		sqlStr := "UPDATE %v.domain_event_subscribe SET ? WHERE id = ?"
-		sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
-		m.db().Exec(sqlStr, changes, eventID)
+	if len(changes) > 0 {
+		db := m.db()
+		db.Table(m.tableName(db, tableSubscribe)).Where("id = ?", eventID).Updates(changes)
 	}
 	return nil
 }
@@ -273,7 +285,8 @@ func (m *EventManagerImpl) retryPub() (needTimer bool) {
 func (m *EventManagerImpl) getFailSubEvents(n int) (subs []map[string]interface{}, err error) {
 	subs = make([]map[string]interface{}, 0)
 
-	rows, err := m.db().Table(fmt.Sprintf("%v.domain_event_subscribe", m.dbConfig().DBName)).Where("status = ?", 1).Limit(n).Rows()
+	db := m.db()
+	rows, err := db.Table(m.tableName(db, tableSubscribe)).Select("id, topic, content").Where("status = ?", 1).Limit(n).Rows()
 	defer utils.CloseRows(rows)
 	if err != nil {
 		return
@@ -293,7 +306,8 @@ func (m *EventManagerImpl) getFailSubEvents(n int) (subs []map[string]interface{
 // getFailPubEvents 获取n个处理失败的领域发布事件(id,topic,content)
 func (m *EventManagerImpl) getFailPubEvents(n int) (pubs []map[string]interface{}, err error) {
 	pubs = make([]map[string]interface{}, 0)
-	rows, err := m.db().Table(fmt.Sprintf("%v.domain_event_publish", m.dbConfig().DBName)).Where("status = ?", 1).Limit(n).Rows()
+	db := m.db()
+	rows, err := db.Table(m.tableName(db, tablePublish)).Select("id, topic, content").Where("status = ?", 1).Limit(n).Rows()
 	defer utils.CloseRows(rows)
 	if err != nil {
 		return
@@ -312,9 +326,10 @@ func (m *EventManagerImpl) getFailPubEvents(n int) (pubs []map[string]interface{
 
 // DeletePubEvent 删除领域发布事件
 func (m *EventManagerImpl) DeletePubEvent(eventID int) error {
-	sqlStr := "DELETE FROM %v.domain_event_publish WHERE id = ?"
-	sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
-	m.db().Exec(sqlStr, eventID)
+	db := m.db()
+	sqlStr := "DELETE FROM %v WHERE id = ?"
+	sqlStr = fmt.Sprintf(sqlStr, m.table(db, tablePublish))
+	db.Exec(sqlStr, eventID)
 	return nil
 }
 
@@ -365,17 +380,14 @@ func (m *EventManagerImpl) push(event dt.DomainEvent) {
 				publish.SetStatus(1)
 				publish.SetUpdated(utils.NowTimestamp())
 				changes := publish.TakeChanges()
-				if changes != "" {
-					sqlStr := "UPDATE %v.domain_event_publish SET ? WHERE id = ?"
-					sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
-					m.db().Exec(sqlStr, changes, eventID)
+				if len(changes) > 0 {
+					db := m.db()
+					db.Table(m.tableName(db, tablePublish)).Where("id = ?", eventID).Updates(changes)
 				}
 				return
 			}
 			// push 成功后删除事件
-			sqlStr := "DELETE FROM %v.domain_event_publish WHERE id = ?"
-			sqlStr = fmt.Sprintf(sqlStr, m.dbConfig().DBName)
-			m.db().Exec(sqlStr, eventID)
+			eventManager.DeletePubEvent(eventID)
 		}()
 		publish = &domainEventPublish{ID: eventID}
 		// 发布事件
@@ -395,16 +407,33 @@ func (m *EventManagerImpl) closeRows(rows *sql.Rows) {
 	}
 }
 
+// table 返回按方言加引号的领域事件表名，供原生 SQL 使用，与 Table() 生成的表名一致，
+// 达梦会把未加引号的标识符转为大写
+func (m *EventManagerImpl) table(db *gorm.DB, name string) string {
+	return db.Statement.Quote(m.tableName(db, name))
+}
+
+// tableName 返回未加引号的表名，供 Table() 使用；mysql 与达梦以库名(模式)限定，
+// postgres 的库不能限定表名，使用连接的 search_path
+func (m *EventManagerImpl) tableName(db *gorm.DB, name string) string {
+	dbName := m.dbConfig().DBName
+	if dbName == "" || db.Dialector.Name() == utils.DialectPostgres || db.Dialector.Name() == utils.DialectSQLite {
+		return name
+	}
+	return dbName + "." + name
+}
+
+// dbConfig 以资源库方式注入的实例同样使用单例设置的数据源
 func (m *EventManagerImpl) dbConfig() config.DBConfiguration {
-	return *config.NewConfiguration().DB
+	return *config.NewConfiguration().DataSource(eventManager.datasource)
 }
 
 func (m *EventManagerImpl) db() *gorm.DB {
-	return m.SourceDB().(*gorm.DB)
+	return m.SourceDB(eventManager.datasource).(*gorm.DB)
 }
 
-func getTxDB(repo *dt.Repository) (db *gorm.DB) {
-	if err := repo.FetchDB(&db); err != nil {
+func (m *EventManagerImpl) getTxDB(repo *dt.Repository) (db *gorm.DB) {
+	if err := repo.FetchDB(&db, eventManager.datasource); err != nil {
 		panic(err)
 	}
 	return
@@ -0,0 +1,110 @@
+package domainevent
+
+import (
+	"testing"
+
+	dt "DT-Go"
+	"DT-Go/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAggregate(repo *dt.Repository) *testAggregate {
+	aggregate := &testAggregate{}
+	repo.InjectBaseEntity(aggregate)
+	aggregate.AddPubEvent(&testEvent{topic: "order.created"})
+	aggregate.AddSubEvent(&testEvent{topic: "user.created", identity: 2})
+	return aggregate
+}
+
+func TestManagerSQLite(t *testing.T) {
+	setup(t)
+	eventManager.SetDataSource(utils.DialectSQLite)
+	defer eventManager.SetDataSource("")
+
+	repo := newRepository()
+	assert.Nil(t, eventManager.Save(repo, newAggregate(repo)))
+	db := eventManager.db()
+	var pubs []domainEventPublish
+	assert.Nil(t, db.Find(&pubs).Error)
+	assert.Len(t, pubs, 1)
+	assert.Equal(t, "order.created", pubs[0].Topic)
+	events, _ := repo.Worker().Store().Get(workerStorePubEventKey).([]dt.DomainEvent)
+	assert.Len(t, events, 1)
+
+	assert.Nil(t, eventManager.SetSubEventFail(2))
+	subs, err := eventManager.getFailSubEvents(SingleRetryNum)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": 2, "topic": "user.created", "content": "{}"}}, subs)
+	assert.Nil(t, eventManager.DeleteSubEvent(2))
+	subs, _ = eventManager.getFailSubEvents(SingleRetryNum)
+	assert.Len(t, subs, 0)
+
+	assert.Nil(t, eventManager.DeletePubEvent(pubs[0].ID))
+	var count int64
+	db.Model(&domainEventPublish{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestManagerDialectSQL(t *testing.T) {
+	setup(t)
+	defer eventManager.SetDataSource("")
+
+	cases := []struct {
+		dialect, insert, delete, update, query string
+	}{
+		{
+			dialect: utils.DialectMySQL,
+			insert:  "INSERT INTO `dt`.`domain_event_subscribe` (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)",
+			delete:  "DELETE FROM `dt`.`domain_event_subscribe` WHERE id = ?",
+			update:  "UPDATE `dt`.`domain_event_subscribe` SET `status`=?,`updated`=? WHERE id = ?",
+			query:   "SELECT id, topic, content FROM `dt`.`domain_event_subscribe` WHERE status = ? LIMIT 100",
+		},
+		{
+			dialect: utils.DialectPostgres,
+			insert:  `INSERT INTO "domain_event_subscribe" (id, topic, content, created, updated, status) VALUES ($1, $2, $3, $4, $5, $6)`,
+			delete:  `DELETE FROM "domain_event_subscribe" WHERE id = $1`,
+			update:  `UPDATE "domain_event_subscribe" SET "status"=$1,"updated"=$2 WHERE id = $3`,
+			query:   `SELECT id, topic, content FROM "domain_event_subscribe" WHERE status = $1 LIMIT 100`,
+		},
+		{
+			dialect: utils.DialectDM8,
+			insert:  `INSERT INTO "dt"."domain_event_subscribe" (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)`,
+			delete:  `DELETE FROM "dt"."domain_event_subscribe" WHERE id = ?`,
+			update:  `UPDATE "dt"."domain_event_subscribe" SET "status"=?,"updated"=? WHERE id = ?`,
+			query:   `SELECT id, topic, content FROM "dt"."domain_event_subscribe" WHERE status = ? LIMIT 100`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.dialect, func(t *testing.T) {
+			eventManager.SetDataSource(c.dialect)
+			mock := mocks[c.dialect]
+
+			repo := newRepository()
+			aggregate := &testAggregate{}
+			repo.InjectBaseEntity(aggregate)
+			aggregate.AddSubEvent(&testEvent{topic: "user.created", identity: 2})
+			mock.ExpectExec(c.insert).
+				WithArgs(2, "user.created", "{}", sqlmock.AnyArg(), sqlmock.AnyArg(), 0).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			assert.Nil(t, eventManager.Save(repo, aggregate))
+
+			mock.ExpectExec(c.delete).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+			assert.Nil(t, eventManager.DeleteSubEvent(2))
+
+			mock.ExpectBegin()
+			mock.ExpectExec(c.update).WithArgs(1, sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			assert.Nil(t, eventManager.SetSubEventFail(2))
+
+			mock.ExpectQuery(c.query).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "content"}).AddRow(2, "user.created", "{}"))
+			subs, err := eventManager.getFailSubEvents(SingleRetryNum)
+			assert.Nil(t, err)
+			assert.Len(t, subs, 1)
+
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -1,10 +1,5 @@
 package domainevent
 
-import (
-	"fmt"
-	"strings"
-)
-
 // domainEventPublish .
 type domainEventPublish struct {
 	changes map[string]interface{}
@@ -22,14 +17,8 @@ func (obj *domainEventPublish) TableName() string {
 }
 
 // TakeChanges .
-func (obj *domainEventPublish) TakeChanges() (result string) {
-	if obj.changes == nil {
-		return ""
-	}
-	for k, v := range obj.changes {
-		result += fmt.Sprintf("%v=%v,", k, v)
-	}
-	result = strings.TrimRight(result, ",")
+func (obj *domainEventPublish) TakeChanges() (result map[string]interface{}) {
+	result = obj.changes
 	obj.changes = nil
 	return result
 }
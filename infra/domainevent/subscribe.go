@@ -1,10 +1,5 @@
 package domainevent
 
-import (
-	"fmt"
-	"strings"
-)
-
 // domainEventSubscribe .
 type domainEventSubscribe struct {
 	changes map[string]interface{}
@@ -22,14 +17,8 @@ func (obj *domainEventSubscribe) TableName() string {
 }
 
 // TakeChanges .
-func (obj *domainEventSubscribe) TakeChanges() (result string) {
-	if obj.changes == nil {
-		return ""
-	}
-	for k, v := range obj.changes {
-		result += fmt.Sprintf("%v=%v,", k, v)
-	}
-	result = strings.TrimRight(result, ",")
+func (obj *domainEventSubscribe) TakeChanges() (result map[string]interface{}) {
+	result = obj.changes
 	obj.changes = nil
 	return result
 }
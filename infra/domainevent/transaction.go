@@ -17,51 +17,101 @@ func init() {
 
 const workerStorePubEventKey = "WORKER_STORE_PUB_EVENT_KEY"
 
+// CommitHook 事务提交后调用，events 为事务内保存的发布事件
+type CommitHook func(tx *EventTransaction, events []dt.DomainEvent)
+
+var commitHooks []CommitHook
+
+// RegisterCommitHook 注册事务提交钩子，在 dt.Prepare 阶段调用
+func RegisterCommitHook(hooks ...CommitHook) {
+	commitHooks = append(commitHooks, hooks...)
+}
+
 // EventTransaction .
 type EventTransaction struct {
 	transaction.SqlDBImpl
 }
 
+// BeginRequest .
+func (et *EventTransaction) BeginRequest(worker dt.Worker) {
+	et.SqlDBImpl.BeginRequest(worker)
+	// 重试前丢弃失败的执行中收集的事件，重新执行时重新收集，避免重复发布
+	et.OnRetry(func(attempt int, err error) {
+		et.Worker().Store().Remove(workerStorePubEventKey)
+	})
+}
+
+// WithDataSource 返回使用命名数据源的事务，领域事件表所在的数据源应与 EventManager.SetDataSource 一致
+func (et *EventTransaction) WithDataSource(name string) transaction.Transaction {
+	return &EventTransaction{SqlDBImpl: *et.SqlDBImpl.WithDataSource(name).(*transaction.SqlDBImpl)}
+}
+
+// SetRetry 返回 EventTransaction，链式调用时仍在提交后发布事件
+func (et *EventTransaction) SetRetry(policy transaction.RetryPolicy) transaction.Transaction {
+	et.SqlDBImpl.SetRetry(policy)
+	return et
+}
+
+// OnRetry .
+func (et *EventTransaction) OnRetry(f func(attempt int, err error)) transaction.Transaction {
+	et.SqlDBImpl.OnRetry(f)
+	return et
+}
+
 // Execute .
 func (et *EventTransaction) Execute(f func() error) (err error) {
-	defer func() {
-		et.Worker().Store().Remove(workerStorePubEventKey)
-	}()
+	return et.ExecuteWith(transaction.Required, f, nil)
+}
 
-	if err = et.SqlDBImpl.Execute(f); err != nil {
-		return
-	}
-	et.pushEvent()
-	return
+// ExecuteTx .
+func (et *EventTransaction) ExecuteTx(f func() error, opts *sql.TxOptions) (err error) {
+	return et.ExecuteWith(transaction.Required, f, opts)
 }
 
 // ExecuteTX .
 func (et *EventTransaction) ExecuteTX(f func() error, opts *sql.TxOptions) (err error) {
+	return et.ExecuteWith(transaction.Required, f, opts)
+}
+
+// ExecuteWith 开启新事务时在提交后发布事务内保存的事件
+// 加入外层事务或使用 SAVEPOINT 时事件随外层事务提交后发布，SAVEPOINT 回滚时丢弃
+func (et *EventTransaction) ExecuteWith(propagation transaction.Propagation, f func() error, opts *sql.TxOptions) (err error) {
+	begin := propagation == transaction.RequiresNew || !et.InTransaction()
+	outer := et.takeEvents()
 	defer func() {
-		et.Worker().Store().Remove(workerStorePubEventKey)
+		events := et.takeEvents()
+		if err == nil && !begin {
+			outer = append(outer, events...)
+		}
+		if len(outer) > 0 {
+			et.Worker().Store().Set(workerStorePubEventKey, outer)
+		}
+		if err == nil && begin {
+			et.pushEvent(events)
+		}
 	}()
 
-	if err = et.SqlDBImpl.ExecuteTx(f, opts); err != nil {
-		return
-	}
-	et.pushEvent()
-	return
+	return et.SqlDBImpl.ExecuteWith(propagation, f, opts)
 }
 
-// pushEvent 发布事件 使用manager推送
-func (et *EventTransaction) pushEvent() {
-	pubs := et.Worker().Store().Get(workerStorePubEventKey)
-	if pubs == nil {
-		return
-	}
+// takeEvents 取出并清空 store 中的发布事件
+func (et *EventTransaction) takeEvents() []dt.DomainEvent {
+	store := et.Worker().Store()
+	pubEvents, _ := store.Get(workerStorePubEventKey).([]dt.DomainEvent)
+	store.Remove(workerStorePubEventKey)
+	return pubEvents
+}
 
-	pubEvents, ok := pubs.([]dt.DomainEvent)
-	if !ok {
+// pushEvent 发布事件 使用manager推送
+func (et *EventTransaction) pushEvent(pubEvents []dt.DomainEvent) {
+	if len(pubEvents) == 0 {
 		return
 	}
 
 	for _, pubEvent := range pubEvents {
 		eventManager.push(pubEvent)
 	}
-	return
+	for _, hook := range commitHooks {
+		hook(et, pubEvents)
+	}
 }
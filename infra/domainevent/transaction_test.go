@@ -0,0 +1,152 @@
+package domainevent
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/config"
+	"DT-Go/infra/transaction"
+	"DT-Go/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testEvent struct {
+	topic      string
+	identity   interface{}
+	prototypes map[string]interface{}
+}
+
+func (e *testEvent) Topic() string                          { return e.topic }
+func (e *testEvent) SetPrototypes(m map[string]interface{}) { e.prototypes = m }
+func (e *testEvent) GetPrototypes() map[string]interface{}  { return e.prototypes }
+func (e *testEvent) Marshal() []byte                        { return []byte(`{}`) }
+func (e *testEvent) Identity() interface{}                  { return e.identity }
+func (e *testEvent) SetIdentity(identity interface{})       { e.identity = identity }
+
+type testAggregate struct {
+	dt.Entity
+}
+
+var (
+	setupOnce sync.Once
+	mock      sqlmock.Sqlmock
+	mocks     = make(map[string]sqlmock.Sqlmock)
+)
+
+// setup 默认数据源使用 mysql 方言的 sqlmock，SQL 按原文匹配
+// 另外每种方言安装一个同名的命名数据源，sqlite 使用内存数据库，其它方言使用 sqlmock
+func setup(t *testing.T) {
+	setupOnce.Do(func() {
+		dialectors := map[string]func(conn *sql.DB) gorm.Dialector{
+			utils.DialectMySQL: func(conn *sql.DB) gorm.Dialector {
+				return gormmysql.New(gormmysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+			},
+			utils.DialectPostgres: func(conn *sql.DB) gorm.Dialector {
+				return postgres.New(postgres.Config{Conn: conn})
+			},
+			utils.DialectDM8: func(conn *sql.DB) gorm.Dialector {
+				return &utils.DMDialector{Conn: conn}
+			},
+		}
+		open := func(dialect string) (*gorm.DB, sqlmock.Sqlmock) {
+			conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			assert.Nil(t, err)
+			db, err := gorm.Open(dialectors[dialect](conn), &gorm.Config{})
+			assert.Nil(t, err)
+			return db, mock
+		}
+
+		u := dt.NewUnitTest(false)
+		conf := config.NewConfiguration()
+		conf.DB.DBName = "dt"
+		var db *gorm.DB
+		db, mock = open(utils.DialectMySQL)
+		u.InstallDB(func() interface{} { return db })
+
+		conf.DataSources = make(map[string]*config.DBConfiguration)
+		for name := range dialectors {
+			named, m := open(name)
+			mocks[name] = m
+			u.InstallDB(func() interface{} { return named }, name)
+			conf.DataSources[name] = &config.DBConfiguration{DBName: "dt"}
+		}
+
+		sqliteDB, err := gorm.Open(sqlite.Open("file:domainevent?mode=memory&cache=shared"), &gorm.Config{})
+		assert.Nil(t, err)
+		assert.Nil(t, sqliteDB.AutoMigrate(&domainEventPublish{}, &domainEventSubscribe{}))
+		u.InstallDB(func() interface{} { return sqliteDB }, utils.DialectSQLite)
+		conf.DataSources[utils.DialectSQLite] = &config.DBConfiguration{DBName: "dt"}
+		u.Run()
+	})
+}
+
+func newRepository() *dt.Repository {
+	repo := &dt.Repository{}
+	repo.BeginRequest(dt.NewWorker(false, nil))
+	return repo
+}
+
+func TestEventTransactionRetry(t *testing.T) {
+	setup(t)
+	var (
+		mu        sync.Mutex
+		published []string
+	)
+	eventManager.RegisterPubHandler(func(topic string, content string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, topic)
+		return nil
+	})
+	defer eventManager.RegisterPubHandler(nil)
+
+	insert := "INSERT INTO `dt`.`domain_event_publish` (id, topic, content, created, updated, status) VALUES (?, ?, ?, ?, ?, ?)"
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET status = 1").WillReturnError(deadlock)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET status = 1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// 发布成功后删除事件
+	mock.ExpectExec("DELETE FROM `dt`.`domain_event_publish` WHERE id = ?").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := newRepository()
+	et := &EventTransaction{}
+	et.BeginRequest(repo.Worker())
+	// 链式调用 SetRetry 返回的仍是 EventTransaction，提交后发布事件
+	tx := et.SetRetry(transaction.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	_, ok := tx.(*EventTransaction)
+	assert.True(t, ok)
+	attempts := 0
+	assert.Nil(t, tx.Execute(func() error {
+		attempts++
+		aggregate := &testAggregate{}
+		repo.InjectBaseEntity(aggregate)
+		aggregate.AddPubEvent(&testEvent{topic: "order.created"})
+		assert.Nil(t, eventManager.Save(repo, aggregate))
+		var db *gorm.DB
+		assert.Nil(t, repo.FetchDB(&db))
+		return db.Exec("UPDATE orders SET status = 1").Error
+	}))
+	assert.Equal(t, 2, attempts)
+
+	// 失败的执行中保存的事件已丢弃，只发布一次
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"order.created"}, published)
+	assert.Nil(t, repo.Worker().Store().Get(workerStorePubEventKey))
+}
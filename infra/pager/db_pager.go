@@ -6,6 +6,7 @@ package pager
 	1.单字段统一升降序分页器
 	2.多字段统一升降序分页器
 	3.自定义不同字段的升降序分页器
+	4.ExecuteShards 跨分片分页，各分片查询前 page*pageSize 条后归并排序
 
 Created by Dustin.zhu on 2023/05/03.
 */
@@ -31,6 +32,8 @@ func init() {
 type Pager interface {
 	// Execute 执行数据库操作
 	Execute(db *gorm.DB, object interface{}) error
+	// ExecuteShards 在多个分片上执行相同的查询，按排序字段归并结果，object 为结构体或 map 切片的指针
+	ExecuteShards(dbs []*gorm.DB, object interface{}) error
 	// SetPage 设置分页参数 页数/每页数量
 	SetPage(page, pageSize int) Pager
 	// TotalPage 总页数
@@ -105,14 +108,14 @@ func (p *PagerImpl) TotalPage() int {
 	return p.totalPage
 }
 
-// order 排序
-func (p *PagerImpl) order() interface{} {
+// order 排序，字段名按 db 的方言加引号
+func (p *PagerImpl) order(db *gorm.DB) interface{} {
 	if len(p.fields) == 0 {
 		return nil
 	}
 	args := []string{}
 	for i := 0; i < len(p.fields); i++ {
-		args = append(args, fmt.Sprintf("`%s` %s", p.fields[i], p.items[i]))
+		args = append(args, fmt.Sprintf("%s %s", db.Statement.Quote(p.fields[i]), p.items[i]))
 	}
 	return strings.Join(args, ",")
 }
@@ -120,7 +123,7 @@ func (p *PagerImpl) order() interface{} {
 // Execute .
 func (p *PagerImpl) Execute(db *gorm.DB, object interface{}) (err error) {
 	pageFind := false
-	orderValue := p.order()
+	orderValue := p.order(db)
 	if orderValue != nil {
 		db = db.Order(orderValue)
 	} else {
@@ -0,0 +1,48 @@
+package pager
+
+import (
+	"database/sql"
+	"testing"
+
+	"DT-Go/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestExecuteDialect(t *testing.T) {
+	cases := []struct {
+		dialector func(conn *sql.DB) gorm.Dialector
+		query     string
+	}{
+		{
+			dialector: func(conn *sql.DB) gorm.Dialector {
+				return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+			},
+			query: "SELECT * FROM `orders` ORDER BY `created` desc",
+		},
+		{
+			dialector: func(conn *sql.DB) gorm.Dialector { return postgres.New(postgres.Config{Conn: conn}) },
+			query:     `SELECT * FROM "orders" ORDER BY "created" desc`,
+		},
+		{
+			dialector: func(conn *sql.DB) gorm.Dialector { return &utils.DMDialector{Conn: conn} },
+			query:     `SELECT * FROM "orders" ORDER BY "created" desc`,
+		},
+	}
+	for _, c := range cases {
+		conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		assert.Nil(t, err)
+		db, err := gorm.Open(c.dialector(conn), &gorm.Config{})
+		assert.Nil(t, err)
+
+		mock.ExpectQuery(c.query).WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, 2))
+		var rows []map[string]interface{}
+		assert.Nil(t, (&PagerImpl{}).DescPager("created").Execute(db.Table("orders"), &rows))
+		assert.Len(t, rows, 1)
+		assert.Nil(t, mock.ExpectationsWereMet(), db.Dialector.Name())
+	}
+}
@@ -0,0 +1,274 @@
+package pager
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ExecuteShards 各分片并发查询前 page*pageSize 条，合并后按排序字段排序再截取当前页，总页数为各分片总数之和计算
+// 未指定排序字段时按主键降序，页数越大每个分片读取的数据越多，深分页应使用游标条件
+func (p *PagerImpl) ExecuteShards(dbs []*gorm.DB, object interface{}) (err error) {
+	slice := reflect.ValueOf(object)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("pager: object must be a pointer to slice")
+	}
+	slice = slice.Elem()
+	if len(dbs) == 0 {
+		slice.Set(reflect.MakeSlice(slice.Type(), 0, 0))
+		return
+	}
+
+	elemType := slice.Type().Elem()
+	sorter := *p
+	var sch *schema.Schema
+	if elemType.Kind() != reflect.Map {
+		if sch, err = parseSchema(dbs[0], elemType); err != nil {
+			return
+		}
+		if len(sorter.fields) == 0 {
+			if sch.PrioritizedPrimaryField == nil {
+				return errors.New("pager: order fields are required without a primary key")
+			}
+			sorter.fields, sorter.items = []string{sch.PrioritizedPrimaryField.DBName}, []string{"desc"}
+		}
+	} else if len(sorter.fields) == 0 {
+		return errors.New("pager: order fields are required for map results")
+	}
+
+	pageFind := p.page != 0 && p.pageSize != 0
+	offset, limit := 0, 0
+	if pageFind {
+		offset = (p.page - 1) * p.pageSize
+		limit = offset + p.pageSize
+	}
+
+	results := make([]reflect.Value, len(dbs))
+	counts := make([]int64, len(dbs))
+	var group errgroup.Group
+	for i := range dbs {
+		i := i
+		group.Go(func() error {
+			if pageFind {
+				if err := dbs[i].Session(&gorm.Session{}).Count(&counts[i]).Error; err != nil {
+					return err
+				}
+			}
+			db := dbs[i].Session(&gorm.Session{})
+			db = db.Order(sorter.order(db))
+			if pageFind {
+				db = db.Limit(limit)
+			}
+			rows := reflect.New(slice.Type())
+			if err := db.Scan(rows.Interface()).Error; err != nil {
+				return err
+			}
+			results[i] = rows.Elem()
+			return nil
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return
+	}
+
+	merged := reflect.MakeSlice(slice.Type(), 0, len(dbs)*limit)
+	for _, rows := range results {
+		merged = reflect.AppendSlice(merged, rows)
+	}
+	sorter.sort(merged, sch)
+	if pageFind {
+		if offset > merged.Len() {
+			offset = merged.Len()
+		}
+		if limit > merged.Len() {
+			limit = merged.Len()
+		}
+		merged = merged.Slice(offset, limit)
+	}
+	slice.Set(merged)
+
+	if !pageFind {
+		return
+	}
+	var count int64
+	for _, n := range counts {
+		count += n
+	}
+	p.totalPage = int((count + int64(p.pageSize) - 1) / int64(p.pageSize))
+	return
+}
+
+// sort 按排序字段稳定排序
+func (p *PagerImpl) sort(rows reflect.Value, sch *schema.Schema) {
+	ctx := context.Background()
+	value := func(row reflect.Value, field string) interface{} {
+		if row.Kind() == reflect.Map {
+			v := row.MapIndex(reflect.ValueOf(field))
+			if !v.IsValid() {
+				return nil
+			}
+			return v.Interface()
+		}
+		f := sch.LookUpField(field)
+		if f == nil {
+			return nil
+		}
+		v, _ := f.ValueOf(ctx, row)
+		return v
+	}
+	sort.SliceStable(rows.Interface(), func(i, j int) bool {
+		for n, field := range p.fields {
+			c := compareValue(value(rows.Index(i), field), value(rows.Index(j), field))
+			if c == 0 {
+				continue
+			}
+			if strings.EqualFold(p.items[n], "desc") {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// parseSchema .
+func parseSchema(db *gorm.DB, elemType reflect.Type) (*schema.Schema, error) {
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(reflect.New(elemType).Interface()); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// compareValue 比较两个字段值，nil 最小
+func compareValue(a, b interface{}) int {
+	a, b = indirect(a), indirect(b)
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if x {
+				return 1
+			}
+			return -1
+		} else if ok {
+			return 0
+		}
+	}
+
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isInt(av) && isInt(bv):
+		return compareInt(av, bv)
+	case isNumber(av) && isNumber(bv):
+		return compareOrdered(toFloat(av), toFloat(bv))
+	case av.Kind() == reflect.String && bv.Kind() == reflect.String:
+		return strings.Compare(av.String(), bv.String())
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// indirect 解引用指针并取出 driver.Valuer 的值
+func indirect(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	v = rv.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		if value, err := valuer.Value(); err == nil {
+			return value
+		}
+	}
+	return v
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isNumber(v reflect.Value) bool {
+	return isInt(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+// compareInt 有符号与无符号整数比较，不损失精度
+func compareInt(a, b reflect.Value) int {
+	aNeg, bNeg := a.CanInt() && a.Int() < 0, b.CanInt() && b.Int() < 0
+	switch {
+	case aNeg && bNeg:
+		return compareOrdered(a.Int(), b.Int())
+	case aNeg:
+		return -1
+	case bNeg:
+		return 1
+	}
+	return compareOrdered(toUint(a), toUint(b))
+}
+
+func toUint(v reflect.Value) uint64 {
+	if v.CanInt() {
+		return uint64(v.Int())
+	}
+	return v.Uint()
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func compareOrdered[T int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+
+	dt "DT-Go"
+	"DT-Go/errors"
+	"DT-Go/infra/domainevent"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+/**
+泛型资源库
+	1.Find、FindAll 读取聚合并记录快照，Save 只更新与快照不同的字段；未经读取或 Track 的聚合 Save 时插入
+	2.表中有 version 字段时使用乐观锁，更新与删除以读取时的版本为条件，版本不一致返回 errors.ConflictErr
+	3.Save、Delete 成功后通过 EventManager.Save 保存聚合的领域事件，写入与事件需要原子性时在 EventTransaction 中调用
+	4.T 为聚合的指针类型，聚合需匿名嵌入 dt.Entity 并标记 gorm:"-"
+
+	type Order struct {
+		dt.Entity `gorm:"-"`
+		ID      int
+		Status  int
+		Version int
+	}
+
+	type OrderRepository struct {
+		repository.Repository[*Order, int]
+	}
+
+	order, err := repo.Find(id)
+	order.Status = 2
+	err = repo.Save(order) // UPDATE orders SET status=?,version=? WHERE id = ? AND version = ?
+
+Created by Dustin.zhu on 2023/10/16.
+*/
+
+// VersionColumn 乐观锁的版本字段
+const VersionColumn = "version"
+
+// Repository 嵌入到聚合的资源库中使用，随资源库在每个请求中创建
+type Repository[T dt.Entity, ID comparable] struct {
+	dt.Repository
+	datasource string
+	snapshots  map[dt.Entity]map[string]interface{}
+}
+
+// SetDataSource 设置聚合所在的数据源，默认为默认数据源，在 BeginRequest 中调用
+func (r *Repository[T, ID]) SetDataSource(name string) {
+	r.datasource = name
+}
+
+// DB 返回聚合所在数据源的连接，事务中返回事务连接；自定义查询得到的聚合需 Track 后才能按字段更新
+func (r *Repository[T, ID]) DB() (db *gorm.DB, err error) {
+	err = r.FetchDB(&db, r.datasource)
+	return
+}
+
+// Find 按主键读取聚合，不存在时返回 errors.ResourceNotFoundErr
+func (r *Repository[T, ID]) Find(id ID) (entity T, err error) {
+	db, err := r.DB()
+	if err != nil {
+		return
+	}
+	model := r.newEntity()
+	sch, err := parseSchema(db, model)
+	if err != nil {
+		return
+	}
+	if sch.PrioritizedPrimaryField == nil {
+		return entity, errors.New(r.language(), errors.InternalErr, "primary key not found.", map[string]string{"table": sch.Table})
+	}
+	err = db.Where(clause.Eq{Column: column(sch.PrioritizedPrimaryField), Value: id}).Take(model).Error
+	if err == gorm.ErrRecordNotFound {
+		return entity, errors.New(r.language(), errors.ResourceNotFoundErr, "record not found.", map[string]interface{}{"table": sch.Table, "id": id})
+	}
+	if err != nil {
+		return
+	}
+	r.Track(model)
+	return model, nil
+}
+
+// FindAll 按条件读取聚合，conds 同 gorm.DB.Find
+func (r *Repository[T, ID]) FindAll(conds ...interface{}) (entities []T, err error) {
+	db, err := r.DB()
+	if err != nil {
+		return
+	}
+	if err = db.Find(&entities, conds...).Error; err != nil {
+		return
+	}
+	r.Track(entities...)
+	return
+}
+
+// Track 注入基础实体并记录快照，之后 Save 只更新变化的字段
+func (r *Repository[T, ID]) Track(entities ...T) {
+	db, err := r.DB()
+	if err != nil {
+		return
+	}
+	for _, entity := range entities {
+		sch, err := parseSchema(db, entity)
+		if err != nil {
+			return
+		}
+		r.InjectBaseEntity(entity)
+		r.track(sch, entity)
+	}
+}
+
+// Save 插入或更新聚合，保存成功后保存聚合的领域事件
+func (r *Repository[T, ID]) Save(entity T) (err error) {
+	db, err := r.DB()
+	if err != nil {
+		return
+	}
+	sch, err := parseSchema(db, entity)
+	if err != nil {
+		return
+	}
+	r.InjectBaseEntity(entity)
+	if snapshot, ok := r.snapshots[entity]; ok {
+		err = r.update(db, sch, entity, snapshot)
+	} else {
+		err = r.insert(db, sch, entity)
+	}
+	if err != nil {
+		return
+	}
+	r.track(sch, entity)
+	return r.saveEvents(entity)
+}
+
+// Delete 删除聚合，删除成功后保存聚合的领域事件
+func (r *Repository[T, ID]) Delete(entity T) (err error) {
+	db, err := r.DB()
+	if err != nil {
+		return
+	}
+	sch, err := parseSchema(db, entity)
+	if err != nil {
+		return
+	}
+	r.InjectBaseEntity(entity)
+	version := versionField(sch)
+	if version != nil {
+		db = db.Where(clause.Eq{Column: column(version), Value: r.version(version, entity)})
+	}
+	result := db.Delete(entity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if version != nil && result.RowsAffected == 0 {
+		return r.conflict(sch, entity)
+	}
+	delete(r.snapshots, entity)
+	return r.saveEvents(entity)
+}
+
+// saveEvents 聚合没有领域事件时不访问领域事件表
+func (r *Repository[T, ID]) saveEvents(entity T) error {
+	if len(entity.GetPubEvent()) == 0 && len(entity.GetSubEvent()) == 0 {
+		return nil
+	}
+	return domainevent.GetEventManager().Save(&r.Repository, entity)
+}
+
+// insert 版本从 1 开始
+func (r *Repository[T, ID]) insert(db *gorm.DB, sch *schema.Schema, entity T) error {
+	if version := versionField(sch); version != nil {
+		rv := reflect.ValueOf(entity)
+		if _, zero := version.ValueOf(context.Background(), rv); zero {
+			if err := version.Set(context.Background(), rv, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return db.Create(entity).Error
+}
+
+// update 只更新与快照不同的字段，有版本字段时以快照的版本为条件并递增版本
+func (r *Repository[T, ID]) update(db *gorm.DB, sch *schema.Schema, entity T, snapshot map[string]interface{}) error {
+	version := versionField(sch)
+	rv := reflect.ValueOf(entity)
+	changes := make(map[string]interface{})
+	for _, field := range sch.Fields {
+		if field.DBName == "" || field.PrimaryKey || field == version || !field.Updatable {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), rv)
+		if !reflect.DeepEqual(copyValue(value), snapshot[field.DBName]) {
+			changes[field.DBName] = value
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	db = db.Model(entity)
+	if version != nil {
+		// 版本为 NULL 时以 IS NULL 为条件，更新为 1
+		current := snapshot[version.DBName]
+		next := reflect.New(version.IndirectFieldType).Elem()
+		switch cv := reflect.ValueOf(current); {
+		case !cv.IsValid():
+			next.Set(reflect.ValueOf(1).Convert(next.Type()))
+		case cv.CanInt():
+			next.SetInt(cv.Int() + 1)
+		default:
+			next.SetUint(cv.Uint() + 1)
+		}
+		changes[version.DBName] = next.Interface()
+		db = db.Where(clause.Eq{Column: column(version), Value: current})
+	}
+	result := db.Updates(changes)
+	if result.Error != nil {
+		return result.Error
+	}
+	if version != nil && result.RowsAffected == 0 {
+		return r.conflict(sch, entity)
+	}
+	return nil
+}
+
+// track 记录聚合当前的字段值
+func (r *Repository[T, ID]) track(sch *schema.Schema, entity T) {
+	if r.snapshots == nil {
+		r.snapshots = make(map[dt.Entity]map[string]interface{})
+	}
+	rv := reflect.ValueOf(entity)
+	snapshot := make(map[string]interface{}, len(sch.Fields))
+	for _, field := range sch.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), rv)
+		snapshot[field.DBName] = copyValue(value)
+	}
+	r.snapshots[entity] = snapshot
+}
+
+// version 已读取的聚合使用读取时的版本
+func (r *Repository[T, ID]) version(version *schema.Field, entity T) interface{} {
+	if snapshot, ok := r.snapshots[entity]; ok {
+		return snapshot[version.DBName]
+	}
+	value, _ := version.ValueOf(context.Background(), reflect.ValueOf(entity))
+	return value
+}
+
+// conflict .
+func (r *Repository[T, ID]) conflict(sch *schema.Schema, entity T) error {
+	detail := map[string]interface{}{"table": sch.Table}
+	if sch.PrioritizedPrimaryField != nil {
+		detail["id"], _ = sch.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(entity))
+	}
+	return errors.New(r.language(), errors.ConflictErr, "version conflict, the record has been modified or deleted.", detail)
+}
+
+// newEntity T 为指针类型
+func (r *Repository[T, ID]) newEntity() T {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Ptr {
+		panic("repository: entity must be a pointer type, " + t.String())
+	}
+	return reflect.New(t.Elem()).Interface().(T)
+}
+
+// language .
+func (r *Repository[T, ID]) language() string {
+	if r.Worker() == nil {
+		return ""
+	}
+	return r.Worker().Bus().Get("language")
+}
+
+// parseSchema .
+func parseSchema(db *gorm.DB, model interface{}) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// versionField 整数类型的 version 字段
+func versionField(sch *schema.Schema) *schema.Field {
+	field := sch.LookUpField(VersionColumn)
+	if field == nil || (field.DataType != schema.Int && field.DataType != schema.Uint) {
+		return nil
+	}
+	return field
+}
+
+// column .
+func column(field *schema.Field) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+}
+
+// copyValue 复制指针指向的值与字节切片，避免快照随聚合一起修改
+func copyValue(value interface{}) interface{} {
+	rv := reflect.ValueOf(value)
+	switch {
+	case !rv.IsValid():
+		return nil
+	case rv.Kind() == reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return copyValue(rv.Elem().Interface())
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		if rv.IsNil() {
+			return nil
+		}
+		return append([]byte(nil), rv.Bytes()...)
+	}
+	return value
+}
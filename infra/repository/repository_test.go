@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"sync"
+	"testing"
+
+	dt "DT-Go"
+	"DT-Go/errors"
+	"DT-Go/infra/domainevent"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type order struct {
+	dt.Entity `gorm:"-"`
+	ID        int `gorm:"primaryKey;autoIncrement:false"`
+	Status    int
+	Remark    *string
+	Version   int
+}
+
+type orderRepository struct {
+	Repository[*order, int]
+}
+
+type orderCreated struct {
+	identity   interface{}
+	prototypes map[string]interface{}
+}
+
+func (e *orderCreated) Topic() string                          { return "order.created" }
+func (e *orderCreated) SetPrototypes(m map[string]interface{}) { e.prototypes = m }
+func (e *orderCreated) GetPrototypes() map[string]interface{}  { return e.prototypes }
+func (e *orderCreated) Marshal() []byte                        { return []byte(`{}`) }
+func (e *orderCreated) Identity() interface{}                  { return e.identity }
+func (e *orderCreated) SetIdentity(identity interface{})       { e.identity = identity }
+
+var (
+	setupOnce sync.Once
+	sqliteDB  *gorm.DB
+	mock      sqlmock.Sqlmock
+)
+
+// setup sqlite 数据源 orders 与 sqlmock 数据源 mysql
+func setup(t *testing.T) {
+	setupOnce.Do(func() {
+		u := dt.NewUnitTest(false)
+		var err error
+		sqliteDB, err = gorm.Open(sqlite.Open("file:repository?mode=memory&cache=shared"), &gorm.Config{})
+		assert.Nil(t, err)
+		assert.Nil(t, sqliteDB.AutoMigrate(&order{}))
+		assert.Nil(t, sqliteDB.Exec("CREATE TABLE domain_event_publish (id INTEGER, topic TEXT, content TEXT, created INTEGER, updated INTEGER, status INTEGER)").Error)
+		u.InstallDB(func() interface{} { return sqliteDB }, "orders")
+
+		conn, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		assert.Nil(t, err)
+		mysqlDB, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{})
+		assert.Nil(t, err)
+		mock = m
+		u.InstallDB(func() interface{} { return mysqlDB }, "mysql")
+		u.Run()
+	})
+	assert.Nil(t, sqliteDB.Where("1 = 1").Delete(&order{}).Error)
+	assert.Nil(t, sqliteDB.Exec("DELETE FROM domain_event_publish").Error)
+}
+
+func newRepository(datasource string) *orderRepository {
+	repo := &orderRepository{}
+	repo.BeginRequest(dt.NewWorker(false, nil))
+	repo.SetDataSource(datasource)
+	return repo
+}
+
+func TestRepository(t *testing.T) {
+	setup(t)
+	domainevent.GetEventManager().SetDataSource("orders")
+	defer domainevent.GetEventManager().SetDataSource("")
+
+	repo := newRepository("orders")
+	o := &order{ID: 1, Status: 1}
+	repo.InjectBaseEntity(o)
+	o.AddPubEvent(&orderCreated{})
+	assert.Nil(t, repo.Save(o))
+	assert.Equal(t, 1, o.Version)
+	var events int64
+	sqliteDB.Table("domain_event_publish").Count(&events)
+	assert.Equal(t, int64(1), events)
+
+	// 未修改时不更新
+	assert.Nil(t, repo.Save(o))
+	assert.Equal(t, 1, o.Version)
+
+	repo = newRepository("orders")
+	found, err := repo.Find(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, found.Status)
+	remark := "urgent"
+	found.Remark = &remark
+	assert.Nil(t, repo.Save(found))
+	assert.Equal(t, 2, found.Version)
+
+	// 其它请求读取的旧版本
+	stale := newRepository("orders")
+	old, err := stale.FindAll("status = ?", 1)
+	assert.Nil(t, err)
+	assert.Len(t, old, 1)
+	*found.Remark = "normal"
+	assert.Nil(t, repo.Save(found))
+	assert.Equal(t, 3, found.Version)
+	old[0].Status = 2
+	err = stale.Save(old[0])
+	assert.Equal(t, errors.ConflictErr, err.(*errors.ErrorResp).Code())
+	assert.Equal(t, errors.ConflictErr, stale.Delete(old[0]).(*errors.ErrorResp).Code())
+
+	var saved order
+	assert.Nil(t, sqliteDB.First(&saved, 1).Error)
+	assert.Equal(t, 1, saved.Status)
+	assert.Equal(t, "normal", *saved.Remark)
+
+	assert.Nil(t, repo.Delete(found))
+	_, err = repo.Find(1)
+	assert.Equal(t, errors.ResourceNotFoundErr, err.(*errors.ErrorResp).Code())
+}
+
+func TestRepositoryUpdateSQL(t *testing.T) {
+	setup(t)
+	repo := newRepository("mysql")
+
+	mock.ExpectQuery("SELECT * FROM `orders` WHERE `orders`.`id` = ? LIMIT 1").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "remark", "version"}).AddRow(7, 1, nil, 4))
+	o, err := repo.Find(7)
+	assert.Nil(t, err)
+
+	o.Status = 2
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE `orders` SET `status`=?,`version`=? WHERE `orders`.`version` = ? AND `id` = ?").
+		WithArgs(2, 5, 4, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.Nil(t, repo.Save(o))
+	assert.Equal(t, 5, o.Version)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,236 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+cron 表达式解析
+	1.标准5段：分 时 日 月 周，6段时首段为秒
+	2.每段支持 * ? , - / 与月、周的英文缩写，日与周同时指定时满足其一即可
+	3.支持 @yearly @monthly @weekly @daily @hourly 与 @every 30s
+
+Created by Dustin.zhu on 2023/09/25.
+*/
+
+// Schedule 计算下一次触发时间
+type Schedule interface {
+	// Next 返回晚于 t 的下一次触发时间，没有时返回零值
+	Next(t time.Time) time.Time
+}
+
+// Every 固定间隔，间隔不足1秒时按1秒计算
+func Every(interval time.Duration) Schedule {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return everySchedule(interval - interval%time.Second)
+}
+
+type everySchedule time.Duration
+
+// Next 按间隔对齐到整点，多个实例的触发时间相同
+func (e everySchedule) Next(t time.Time) time.Time {
+	interval := time.Duration(e)
+	return t.Truncate(interval).Add(interval)
+}
+
+// ParseCron 解析 cron 表达式
+func ParseCron(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@") {
+		return parseDescriptor(spec)
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: expected 5 or 6 fields, found %d: %s", len(fields), spec)
+	}
+
+	s := &cronSchedule{}
+	var err error
+	if s.second, err = parseField(fields[0], seconds); err != nil {
+		return nil, err
+	}
+	if s.minute, err = parseField(fields[1], minutes); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[2], hours); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[3], doms); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[4], months); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[5], dows); err != nil {
+		return nil, err
+	}
+	// 周日可写作 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseDescriptor(spec string) (Schedule, error) {
+	switch spec {
+	case "@yearly", "@annually":
+		return ParseCron("0 0 1 1 *")
+	case "@monthly":
+		return ParseCron("0 0 1 * *")
+	case "@weekly":
+		return ParseCron("0 0 * * 0")
+	case "@daily", "@midnight":
+		return ParseCron("0 0 * * *")
+	case "@hourly":
+		return ParseCron("0 * * * *")
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("cron: %s, %v", spec, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("cron: interval must be positive: %s", spec)
+		}
+		return Every(interval), nil
+	}
+	return nil, fmt.Errorf("cron: unrecognized descriptor: %s", spec)
+}
+
+// starBit 段为 * 或 ? 时标记，用于日与周的组合判断
+const starBit = 1 << 63
+
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// parseField 解析一段，返回取值的位图
+func parseField(field string, b bounds) (uint64, error) {
+	var bitsSet uint64
+	for _, expr := range strings.Split(field, ",") {
+		rangeExpr, step := expr, uint(1)
+		if i := strings.Index(expr, "/"); i >= 0 {
+			n, err := strconv.ParseUint(expr[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("cron: invalid step: %s", expr)
+			}
+			rangeExpr, step = expr[:i], uint(n)
+		}
+
+		var start, end uint
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			start, end = b.min, b.max
+			if step == 1 {
+				bitsSet |= starBit
+			}
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if start, err = parseValue(parts[0], b); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(parts[1], b); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := parseValue(rangeExpr, b)
+			if err != nil {
+				return 0, err
+			}
+			start, end = v, v
+			if step > 1 {
+				end = b.max
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("cron: invalid range: %s", expr)
+		}
+		for v := start; v <= end; v += step {
+			bitsSet |= 1 << v
+		}
+	}
+	return bitsSet, nil
+}
+
+func parseValue(s string, b bounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(n) < b.min || uint(n) > b.max {
+		return 0, fmt.Errorf("cron: value %s out of range [%d, %d]", s, b.min, b.max)
+	}
+	return uint(n), nil
+}
+
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+}
+
+// Next .
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	// 最多查找5年，避免 2月30日 之类的表达式死循环
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches 日与周均指定时满足其一即可
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.dom&starBit != 0 || s.dow&starBit != 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
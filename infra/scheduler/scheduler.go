@@ -0,0 +1,502 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+分布式定时任务组件
+	1.支持 cron 表达式与固定间隔，在 dt.Prepare 或 Starter 中注册
+	2.每次执行创建独立的 dt.Worker，任务函数的参数为 service 时通过 CallService 注入
+	  任务属于注册时正在 Prepare 的应用，在 Starter 等 Prepare 之外注册时使用 WithPrivate 指定
+	3.同一任务的同一触发时间在集群内只执行一次，执行期间持有 redis 租约并续期，进程崩溃后租约到期
+	4.错过触发(进程暂停、上次执行超时)时按 MisfirePolicy 处理，启动时补偿停机期间错过的触发
+	5.执行记录保存在 redis，服务关闭时停止调度并等待执行中的任务结束
+
+	scheduler.GetScheduler().Cron("close-expired-orders", "@every 5m", func(order *service.OrderService) error {
+		return order.CloseExpired()
+	}, scheduler.WithJitter(10*time.Second))
+
+Created by Dustin.zhu on 2023/09/25.
+*/
+
+const (
+	// DefaultLease 执行租约的有效期，执行期间每 1/3 有效期续期一次
+	DefaultLease = 60 * time.Second
+	// DefaultHistorySize 每个任务保存的执行记录数
+	DefaultHistorySize = 20
+	// DefaultStopTimeout 服务关闭时等待执行中任务的时间
+	DefaultStopTimeout = 30 * time.Second
+	// MisfireThreshold 实际触发晚于计划时间(不含抖动)超过该值时视为错过触发
+	MisfireThreshold = time.Second
+)
+
+// MisfirePolicy 错过触发的处理策略
+type MisfirePolicy int
+
+const (
+	// MisfireFireOnce 错过的触发合并为一次立即执行
+	MisfireFireOnce MisfirePolicy = iota
+	// MisfireSkip 跳过错过的触发，等待下一次触发
+	MisfireSkip
+)
+
+var (
+	// ErrJobExists 任务名重复
+	ErrJobExists = errors.New("scheduler: job already exists")
+
+	workerType = reflect.TypeOf((*dt.Worker)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+
+	// claimScript KEYS[1] 租约 KEYS[2] 最后触发时间 ARGV[1] 实例 ARGV[2] 触发时间(毫秒) ARGV[3] 租约有效期(毫秒)
+	// 上次执行未结束或该触发时间已被执行时返回 0
+	claimScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local last = redis.call('GET', KEYS[2])
+if last and tonumber(last) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+redis.call('SET', KEYS[2], ARGV[2])
+return 1`)
+
+	// renewScript KEYS[1] 租约 ARGV[1] 实例 ARGV[2] 租约有效期(毫秒)
+	renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// releaseScript KEYS[1] 租约 ARGV[1] 实例
+	releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+var scheduler *SchedulerImpl
+
+func init() {
+	scheduler = newScheduler()
+	dt.Prepare(func(initiator dt.Initiator) {
+		scheduler.private = initiator.IsPrivate()
+		// 单例
+		initiator.BindInfra(true, initiator.IsPrivate(), scheduler)
+	})
+}
+
+// GetScheduler .
+func GetScheduler() *SchedulerImpl {
+	return scheduler
+}
+
+// Scheduler .
+type Scheduler interface {
+	// Cron 按 cron 表达式注册任务
+	// fun 为 func(*Service) error、func(*Service)、func(dt.Worker) error 或 func(dt.Worker)
+	Cron(name, spec string, fun interface{}, opts ...JobOption) error
+	// Every 按固定间隔注册任务，触发时间按间隔对齐，各实例一致
+	Every(name string, interval time.Duration, fun interface{}, opts ...JobOption) error
+	// Schedule 按自定义 Schedule 注册任务
+	Schedule(name string, schedule Schedule, fun interface{}, opts ...JobOption) error
+	// History 最近 n 次执行记录，最新的在前
+	History(name string, n int) ([]Run, error)
+	// Stop 停止调度，等待执行中的任务至多 timeout 后取消其 ctx
+	Stop(timeout time.Duration)
+}
+
+var _ Scheduler = (*SchedulerImpl)(nil)
+
+// Run 执行记录
+type Run struct {
+	Job      string    `json:"job"`
+	Instance string    `json:"instance"`
+	Fire     time.Time `json:"fire"`     // 计划触发时间
+	Start    time.Time `json:"start"`    // 实际开始时间
+	Duration int64     `json:"duration"` // 执行耗时(毫秒)
+	Misfire  bool      `json:"misfire"`  // 是否为错过触发后的补偿执行
+	Error    string    `json:"error,omitempty"`
+}
+
+// JobOption .
+type JobOption func(*job)
+
+// WithMisfire 错过触发的处理策略，默认 MisfireFireOnce
+func WithMisfire(policy MisfirePolicy) JobOption {
+	return func(j *job) {
+		j.misfire = policy
+	}
+}
+
+// WithPrivate 任务所属的应用，默认为注册时正在 Prepare 的应用
+func WithPrivate(private bool) JobOption {
+	return func(j *job) {
+		j.private = private
+	}
+}
+
+// WithJitter 每次触发随机延迟 [0, jitter)，分散各任务的执行时间
+func WithJitter(jitter time.Duration) JobOption {
+	return func(j *job) {
+		j.jitter = jitter
+	}
+}
+
+// WithTimeout 单次执行超时，超时后取消 Worker.Context()，默认不超时
+func WithTimeout(timeout time.Duration) JobOption {
+	return func(j *job) {
+		j.timeout = timeout
+	}
+}
+
+// WithLease 执行租约的有效期，默认 DefaultLease
+func WithLease(lease time.Duration) JobOption {
+	return func(j *job) {
+		j.lease = lease
+	}
+}
+
+// WithHistorySize 保存的执行记录数，默认 DefaultHistorySize
+func WithHistorySize(size int) JobOption {
+	return func(j *job) {
+		j.historySize = size
+	}
+}
+
+type job struct {
+	name        string
+	schedule    Schedule
+	call        func(worker dt.Worker) error
+	private     bool
+	misfire     MisfirePolicy
+	jitter      time.Duration
+	timeout     time.Duration
+	lease       time.Duration
+	historySize int
+}
+
+// SchedulerImpl .
+type SchedulerImpl struct {
+	dt.Infra
+	client   redis.Cmdable
+	private  bool // 正在 Prepare 的应用，注册任务时记录到任务
+	instance string
+	mu       sync.Mutex
+	jobs     map[string]*job
+	started  bool
+	ctx      context.Context // 结束时停止调度
+	cancel   context.CancelFunc
+	runCtx   context.Context // 执行中任务的 ctx，停止超时后取消
+	abort    context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newScheduler() *SchedulerImpl {
+	s := &SchedulerImpl{instance: newInstanceID(), jobs: make(map[string]*job)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.runCtx, s.abort = context.WithCancel(context.Background())
+	return s
+}
+
+// Booting .
+func (s *SchedulerImpl) Booting(singleBoot dt.SingleBoot) {
+	if s.client == nil {
+		s.client = s.Redis()
+	}
+	s.start()
+	singleBoot.RegisterShutdown(func() {
+		s.Stop(DefaultStopTimeout)
+	})
+}
+
+// Cron .
+func (s *SchedulerImpl) Cron(name, spec string, fun interface{}, opts ...JobOption) error {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	return s.Schedule(name, schedule, fun, opts...)
+}
+
+// Every .
+func (s *SchedulerImpl) Every(name string, interval time.Duration, fun interface{}, opts ...JobOption) error {
+	return s.Schedule(name, Every(interval), fun, opts...)
+}
+
+// Schedule .
+func (s *SchedulerImpl) Schedule(name string, schedule Schedule, fun interface{}, opts ...JobOption) error {
+	call, err := s.parseJobFunc(fun)
+	if err != nil {
+		return fmt.Errorf("scheduler: job %s, %v", name, err)
+	}
+	j := &job{name: name, schedule: schedule, call: call, private: s.private, lease: DefaultLease, historySize: DefaultHistorySize}
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return ErrJobExists
+	}
+	s.jobs[name] = j
+	if s.started {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	return nil
+}
+
+// History .
+func (s *SchedulerImpl) History(name string, n int) ([]Run, error) {
+	if s.client == nil {
+		return nil, errors.New("scheduler: redis is not installed")
+	}
+	values, err := s.client.LRange(context.Background(), historyKey(name), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]Run, 0, len(values))
+	for _, value := range values {
+		var run Run
+		if err := json.Unmarshal([]byte(value), &run); err != nil {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// Stop .
+func (s *SchedulerImpl) Stop(timeout time.Duration) {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		dt.Logger().Warnf("scheduler: jobs are still running after %v, cancel them", timeout)
+		s.abort()
+	}
+}
+
+func (s *SchedulerImpl) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// loop 调度一个任务，同一实例内任务串行执行
+func (s *SchedulerImpl) loop(j *job) {
+	defer s.wg.Done()
+	now := time.Now()
+	next := j.schedule.Next(now)
+	// 启动时补偿停机期间错过的触发
+	if j.misfire == MisfireFireOnce {
+		if last := s.lastFire(j); !last.IsZero() {
+			if missed := j.schedule.Next(last); !missed.IsZero() && missed.Before(now) {
+				s.run(j, missed, true)
+			}
+		}
+	}
+
+	for !next.IsZero() {
+		var jitter time.Duration
+		if j.jitter > 0 {
+			jitter = time.Duration(mrand.Int63n(int64(j.jitter)))
+		}
+		timer := time.NewTimer(time.Until(next) + jitter)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		fire := next
+		next = j.schedule.Next(now)
+		misfire := now.Sub(fire)-jitter > MisfireThreshold
+		if misfire && j.misfire == MisfireSkip {
+			dt.Logger().Warnf("scheduler: job %s misfired at %v, skipped", j.name, fire)
+			continue
+		}
+		s.run(j, fire, misfire)
+	}
+}
+
+// run 获取租约后执行，其它实例已执行该触发时间时跳过
+func (s *SchedulerImpl) run(j *job, fire time.Time, misfire bool) {
+	if s.client != nil {
+		ok, err := claimScript.Run(context.Background(), s.client, []string{leaseKey(j.name), lastFireKey(j.name)},
+			s.instance, fire.UnixMilli(), j.lease.Milliseconds()).Bool()
+		if err != nil {
+			dt.Logger().Errorf("scheduler: claim job %s failed, err: %v", j.name, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		keepCtx, stopKeep := context.WithCancel(context.Background())
+		go s.keepLease(keepCtx, j)
+		defer func() {
+			stopKeep()
+			releaseScript.Run(context.Background(), s.client, []string{leaseKey(j.name)}, s.instance)
+		}()
+	}
+
+	record := Run{Job: j.name, Instance: s.instance, Fire: fire, Start: time.Now(), Misfire: misfire}
+	if err := s.execute(j); err != nil {
+		record.Error = err.Error()
+		dt.Logger().Errorf("scheduler: job %s failed, err: %v", j.name, err)
+	}
+	record.Duration = time.Since(record.Start).Milliseconds()
+	s.saveHistory(j, record)
+}
+
+// execute 使用独立的 Worker 执行任务
+func (s *SchedulerImpl) execute(j *job) (err error) {
+	ctx, cancel := s.runCtx, context.CancelFunc(func() {})
+	if j.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+	}
+	defer cancel()
+	worker := dt.NewWorker(j.private, nil)
+	worker.WithContext(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.call(worker)
+}
+
+func (s *SchedulerImpl) keepLease(ctx context.Context, j *job) {
+	ticker := time.NewTicker(j.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := renewScript.Run(ctx, s.client, []string{leaseKey(j.name)}, s.instance, j.lease.Milliseconds()).Bool()
+			if err == nil && !ok {
+				dt.Logger().Warnf("scheduler: lease of job %s lost", j.name)
+				return
+			}
+		}
+	}
+}
+
+// lastFire 集群内最后一次执行的触发时间
+func (s *SchedulerImpl) lastFire(j *job) time.Time {
+	if s.client == nil {
+		return time.Time{}
+	}
+	ms, err := s.client.Get(context.Background(), lastFireKey(j.name)).Int64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func (s *SchedulerImpl) saveHistory(j *job, run Run) {
+	if s.client == nil || j.historySize <= 0 {
+		return
+	}
+	data, _ := json.Marshal(run)
+	pipe := s.client.Pipeline()
+	pipe.LPush(context.Background(), historyKey(j.name), data)
+	pipe.LTrim(context.Background(), historyKey(j.name), 0, int64(j.historySize-1))
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		dt.Logger().Warnf("scheduler: save history of job %s failed, err: %v", j.name, err)
+	}
+}
+
+// parseJobFunc 将任务函数转换为以 Worker 调用的函数
+func (s *SchedulerImpl) parseJobFunc(fun interface{}) (func(worker dt.Worker) error, error) {
+	fv := reflect.ValueOf(fun)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 1 || ft.NumOut() > 1 || (ft.NumOut() == 1 && ft.Out(0) != errorType) {
+		return nil, errors.New("job must be func(*Service) error or func(dt.Worker) error")
+	}
+	result := func(out []reflect.Value) error {
+		if len(out) == 0 || out[0].IsNil() {
+			return nil
+		}
+		return out[0].Interface().(error)
+	}
+
+	in := ft.In(0)
+	if in == workerType {
+		return func(worker dt.Worker) error {
+			return result(fv.Call([]reflect.Value{reflect.ValueOf(&worker).Elem()}))
+		}, nil
+	}
+	if in.Kind() != reflect.Ptr {
+		return nil, errors.New("the parameter must be a service pointer or dt.Worker")
+	}
+	// CallService 仅接受无返回值的函数，通过闭包取回错误
+	return func(worker dt.Worker) (err error) {
+		wrapper := reflect.MakeFunc(reflect.FuncOf([]reflect.Type{in}, nil, false), func(args []reflect.Value) []reflect.Value {
+			err = result(fv.Call(args))
+			return nil
+		})
+		appOf(worker.IsPrivate()).CallService(wrapper.Interface(), worker)
+		return
+	}, nil
+}
+
+func appOf(private bool) dt.Application {
+	if private {
+		return dt.NewPrivateApplication()
+	}
+	return dt.NewPublicApplication()
+}
+
+func leaseKey(name string) string {
+	return "dt:cron:{" + name + "}:lease"
+}
+
+func lastFireKey(name string) string {
+	return "dt:cron:{" + name + "}:last"
+}
+
+func historyKey(name string) string {
+	return "dt:cron:{" + name + "}:history"
+}
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2023, 9, 23, 10, 7, 30, 0, time.Local) // 周六
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2023, 9, 23, 10, 15, 0, 0, time.Local)},
+		{"0 9 * * mon-fri", time.Date(2023, 9, 25, 9, 0, 0, 0, time.Local)},
+		{"30 0 1 * *", time.Date(2023, 10, 1, 0, 30, 0, 0, time.Local)},
+		{"0 0 13 * 5", time.Date(2023, 9, 29, 0, 0, 0, 0, time.Local)}, // 13日或周五
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.Local)},
+		{"*/20 * * * * *", time.Date(2023, 9, 23, 10, 7, 40, 0, time.Local)},
+		{"@hourly", time.Date(2023, 9, 23, 11, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2023, 9, 24, 0, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		schedule, err := ParseCron(c.spec)
+		require.NoError(t, err, c.spec)
+		assert.Equal(t, c.next, schedule.Next(base), c.spec)
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every -1s", "@fortnightly"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func newTestScheduler(client redis.Cmdable) *SchedulerImpl {
+	s := newScheduler()
+	s.client = client
+	return s
+}
+
+func TestSchedulerRunsOncePerFire(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	var runs int32
+	job := func(worker dt.Worker) error {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	a, b := newTestScheduler(client), newTestScheduler(client)
+	require.NoError(t, a.Every("sync", time.Second, job, WithMisfire(MisfireSkip)))
+	require.NoError(t, b.Every("sync", time.Second, job, WithMisfire(MisfireSkip)))
+	assert.Equal(t, ErrJobExists, a.Every("sync", time.Second, job))
+	a.start()
+	b.start()
+	time.Sleep(2500 * time.Millisecond)
+	a.Stop(time.Second)
+	b.Stop(time.Second)
+
+	history, err := a.History("sync", 10)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, len(history), 2)
+	assert.Len(t, history, int(atomic.LoadInt32(&runs)))
+	seen := make(map[time.Time]bool)
+	for _, run := range history {
+		assert.False(t, seen[run.Fire], "fire %v ran twice", run.Fire)
+		seen[run.Fire] = true
+	}
+}
+
+func TestSchedulerMisfireOnStartup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	lastRun := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10)
+
+	for _, policy := range []MisfirePolicy{MisfireFireOnce, MisfireSkip} {
+		mr.FlushAll()
+		mr.Set(lastFireKey("report"), lastRun)
+		s := newTestScheduler(client)
+		require.NoError(t, s.Cron("report", "0 * * * *", func(worker dt.Worker) error {
+			return assert.AnError
+		}, WithMisfire(policy)))
+		s.start()
+		time.Sleep(100 * time.Millisecond)
+		s.Stop(time.Second)
+
+		history, err := s.History("report", 10)
+		require.NoError(t, err)
+		if policy == MisfireSkip {
+			assert.Empty(t, history)
+			continue
+		}
+		require.Len(t, history, 1)
+		assert.True(t, history[0].Misfire)
+		assert.Equal(t, assert.AnError.Error(), history[0].Error)
+	}
+}
+
+func TestSchedulerStopCancelsRunningJob(t *testing.T) {
+	s := newTestScheduler(nil)
+	canceled := make(chan struct{})
+	require.NoError(t, s.Schedule("slow", Every(time.Second), func(worker dt.Worker) {
+		<-worker.Context().Done()
+		close(canceled)
+	}))
+	s.start()
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(1100 * time.Millisecond)))
+	s.Stop(50 * time.Millisecond)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("running job was not canceled")
+	}
+}
+
+func TestSchedulerJobApplication(t *testing.T) {
+	s := newTestScheduler(nil)
+	private := make(map[string]bool)
+	record := func(name string) func(worker dt.Worker) {
+		return func(worker dt.Worker) {
+			private[name] = worker.IsPrivate()
+		}
+	}
+	// 任务属于注册时正在 Prepare 的应用，之后 Prepare 其它应用不影响已注册的任务
+	s.private = true
+	require.NoError(t, s.Every("private-job", time.Minute, record("private-job")))
+	s.private = false
+	require.NoError(t, s.Every("public-job", time.Minute, record("public-job")))
+	require.NoError(t, s.Every("explicit-job", time.Minute, record("explicit-job"), WithPrivate(true)))
+	for _, name := range []string{"private-job", "public-job", "explicit-job"} {
+		require.NoError(t, s.execute(s.jobs[name]))
+	}
+	assert.Equal(t, map[string]bool{"private-job": true, "public-job": false, "explicit-job": true}, private)
+}
@@ -0,0 +1,215 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	dt "DT-Go"
+	"DT-Go/infra/uniqueid"
+
+	"gorm.io/gorm"
+)
+
+/**
+分表分库路由组件
+	1.按逻辑表注册分片规则，分片策略将分片键映射到分片，分片对应数据源与物理表
+	2.FetchDB 返回分片所在数据源、限定了物理表的连接，事务中返回该数据源的事务连接
+	3.FetchAllDB 返回全部分片的连接，配合 Pager.ExecuteShards 跨分片查询并归并排序
+	4.NextID 生成编码了分片序号的 ID，HashMod 策略下以该 ID 为分片键路由到同一分片，分片数不超过 uniqueid.MaxShards
+
+	sharding.GetRouter().Register(sharding.Rule{Table: "orders", Strategy: sharding.HashMod(16), DataSources: []string{"order0", "order1"}})
+
+	var db *gorm.DB
+	err := repo.Sharding.FetchDB(&repo.Repository, &db, "orders", userID)
+	err = db.Create(&order).Error
+
+Created by Dustin.zhu on 2023/10/09.
+*/
+
+var (
+	router *RouterImpl
+
+	// ErrRuleNotFound 逻辑表没有注册分片规则
+	ErrRuleNotFound = errors.New("sharding: rule not found")
+	// ErrRuleExists 逻辑表重复注册
+	ErrRuleExists = errors.New("sharding: rule already exists")
+	// ErrTooManyShards 分片数超过 uniqueid.MaxShards，无法在 ID 中编码分片序号
+	ErrTooManyShards = errors.New("sharding: too many shards to encode in id")
+)
+
+func init() {
+	router = &RouterImpl{rules: make(map[string]*Rule)}
+	dt.Prepare(func(initiator dt.Initiator) {
+		// 单例
+		initiator.BindInfra(true, initiator.IsPrivate(), router)
+	})
+}
+
+// GetRouter .
+func GetRouter() *RouterImpl {
+	return router
+}
+
+// Rule 逻辑表的分片规则
+type Rule struct {
+	Table    string   // 逻辑表名
+	Strategy Strategy // 分片策略
+	// DataSources 分片所在的数据源，分片 i 位于 DataSources[i%len(DataSources)]，为空时使用默认数据源
+	DataSources []string
+	// TableName 分片的物理表名，默认为 {Table}_{分片序号}，只分库不分表时返回 Table
+	TableName func(shard int) string
+}
+
+// Target 分片的位置
+type Target struct {
+	Shard      int
+	DataSource string
+	Table      string
+}
+
+// Router .
+type Router interface {
+	// Register 注册分片规则，在 dt.Prepare 或 Starter 中调用
+	Register(rule Rule) error
+	// Route 返回分片键所在的分片
+	Route(table string, key interface{}) (Target, error)
+	// Targets 返回逻辑表的全部分片
+	Targets(table string) ([]Target, error)
+	// FetchDB 返回分片键所在分片的连接
+	FetchDB(repo *dt.Repository, db **gorm.DB, table string, key interface{}) error
+	// FetchAllDB 返回全部分片的连接，顺序与 Targets 一致
+	FetchAllDB(repo *dt.Repository, table string) ([]*gorm.DB, error)
+	// NextID 生成位于分片键所在分片的 ID
+	NextID(table string, key interface{}) (int, error)
+}
+
+var _ Router = (*RouterImpl)(nil)
+
+// RouterImpl .
+type RouterImpl struct {
+	dt.Infra
+	mu       sync.RWMutex
+	rules    map[string]*Rule
+	uniqueID uniqueid.SonyflakerImpl
+}
+
+// Booting .
+func (r *RouterImpl) Booting(singleBoot dt.SingleBoot) {
+}
+
+// Register .
+func (r *RouterImpl) Register(rule Rule) error {
+	if rule.Table == "" || rule.Strategy == nil {
+		return errors.New("sharding: rule requires Table and Strategy")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rules[rule.Table]; ok {
+		return fmt.Errorf("%w: %s", ErrRuleExists, rule.Table)
+	}
+	r.rules[rule.Table] = &rule
+	return nil
+}
+
+// Route .
+func (r *RouterImpl) Route(table string, key interface{}) (Target, error) {
+	rule, err := r.rule(table)
+	if err != nil {
+		return Target{}, err
+	}
+	shard, err := rule.Strategy.Shard(key)
+	if err != nil {
+		return Target{}, err
+	}
+	return rule.target(shard), nil
+}
+
+// Targets .
+func (r *RouterImpl) Targets(table string) ([]Target, error) {
+	rule, err := r.rule(table)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, rule.Strategy.Shards())
+	for shard := range targets {
+		targets[shard] = rule.target(shard)
+	}
+	return targets, nil
+}
+
+// FetchDB .
+func (r *RouterImpl) FetchDB(repo *dt.Repository, db **gorm.DB, table string, key interface{}) error {
+	target, err := r.Route(table, key)
+	if err != nil {
+		return err
+	}
+	*db, err = fetch(repo, target)
+	return err
+}
+
+// FetchAllDB .
+func (r *RouterImpl) FetchAllDB(repo *dt.Repository, table string) ([]*gorm.DB, error) {
+	targets, err := r.Targets(table)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make([]*gorm.DB, 0, len(targets))
+	for _, target := range targets {
+		db, err := fetch(repo, target)
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// NextID HashMod 策略的 ID 取模即为分片序号，其它策略仅保证 ID 唯一
+func (r *RouterImpl) NextID(table string, key interface{}) (int, error) {
+	rule, err := r.rule(table)
+	if err != nil {
+		return 0, err
+	}
+	shards := rule.Strategy.Shards()
+	if shards > uniqueid.MaxShards {
+		return 0, fmt.Errorf("%w: %s has %d shards, at most %d", ErrTooManyShards, table, shards, uniqueid.MaxShards)
+	}
+	shard, err := rule.Strategy.Shard(key)
+	if err != nil {
+		return 0, err
+	}
+	return r.uniqueID.ShardID(shard, shards), nil
+}
+
+func (r *RouterImpl) rule(table string) (*Rule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rule, ok := r.rules[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRuleNotFound, table)
+	}
+	return rule, nil
+}
+
+func (rule *Rule) target(shard int) Target {
+	target := Target{Shard: shard, DataSource: dt.DefaultDataSource}
+	if len(rule.DataSources) > 0 {
+		target.DataSource = rule.DataSources[shard%len(rule.DataSources)]
+	}
+	if rule.TableName != nil {
+		target.Table = rule.TableName(shard)
+	} else {
+		target.Table = fmt.Sprintf("%s_%d", rule.Table, shard)
+	}
+	return target
+}
+
+// fetch 返回限定了物理表的新会话，可以重复使用
+func fetch(repo *dt.Repository, target Target) (*gorm.DB, error) {
+	var db *gorm.DB
+	if err := repo.FetchDB(&db, target.DataSource); err != nil {
+		return nil, err
+	}
+	return db.Table(target.Table).Session(&gorm.Session{}), nil
+}
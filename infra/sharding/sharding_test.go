@@ -0,0 +1,155 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/infra/pager"
+	"DT-Go/infra/uniqueid"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestHashMod(t *testing.T) {
+	s := HashMod(4)
+	assert.Equal(t, 4, s.Shards())
+	shard, err := s.Shard(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, shard)
+	shard, _ = s.Shard(int64(-7))
+	assert.Equal(t, 3, shard)
+	a, _ := s.Shard("user-1")
+	b, _ := s.Shard([]byte("user-1"))
+	assert.Equal(t, a, b)
+	_, err = s.Shard(1.5)
+	assert.True(t, errors.Is(err, ErrUnsupportedKey))
+}
+
+func TestRange(t *testing.T) {
+	s := RangeByID(100, 200)
+	assert.Equal(t, 3, s.Shards())
+	for key, want := range map[int]int{0: 0, 99: 0, 100: 1, 199: 1, 200: 2, 1000: 2} {
+		shard, err := s.Shard(key)
+		assert.Nil(t, err)
+		assert.Equal(t, want, shard, key)
+	}
+
+	start := time.Date(2023, 1, 15, 0, 0, 0, 0, time.Local)
+	m := Monthly(start, 12)
+	assert.Equal(t, 12, m.Shards())
+	shard, _ := m.Shard(time.Date(2022, 12, 31, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, 0, shard)
+	shard, _ = m.Shard(time.Date(2023, 3, 1, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, 2, shard)
+	shard, _ = m.Shard(time.Date(2023, 3, 1, 0, 0, 0, 0, time.Local).UnixMilli())
+	assert.Equal(t, 2, shard)
+	shard, _ = m.Shard(time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, 11, shard)
+}
+
+func TestRoute(t *testing.T) {
+	r := &RouterImpl{rules: make(map[string]*Rule)}
+	assert.Nil(t, r.Register(Rule{Table: "orders", Strategy: HashMod(4), DataSources: []string{"s0", "s1"}}))
+	assert.True(t, errors.Is(r.Register(Rule{Table: "orders", Strategy: HashMod(2)}), ErrRuleExists))
+	assert.Nil(t, r.Register(Rule{Table: "logs", Strategy: RangeByID(100), TableName: func(int) string { return "logs" }}))
+
+	target, err := r.Route("orders", 7)
+	assert.Nil(t, err)
+	assert.Equal(t, Target{Shard: 3, DataSource: "s1", Table: "orders_3"}, target)
+	target, _ = r.Route("logs", 150)
+	assert.Equal(t, Target{Shard: 1, DataSource: dt.DefaultDataSource, Table: "logs"}, target)
+	_, err = r.Route("users", 1)
+	assert.True(t, errors.Is(err, ErrRuleNotFound))
+
+	targets, _ := r.Targets("orders")
+	assert.Len(t, targets, 4)
+
+	// ID 与分片键路由到同一分片
+	for userID := 0; userID < 8; userID++ {
+		id, err := r.NextID("orders", userID)
+		assert.Nil(t, err)
+		byUser, _ := r.Route("orders", userID)
+		byID, _ := r.Route("orders", id)
+		assert.Equal(t, byUser, byID)
+		assert.Equal(t, byUser.Shard, uniqueid.ShardOf(id, 4))
+	}
+
+	// 分片数超过 MaxShards 时无法编码分片序号
+	assert.Nil(t, r.Register(Rule{Table: "events", Strategy: HashMod(uniqueid.MaxShards + 1)}))
+	_, err = r.NextID("events", 1)
+	assert.True(t, errors.Is(err, ErrTooManyShards))
+}
+
+type order struct {
+	ID      int `gorm:"primaryKey;autoIncrement:false"`
+	UserID  int
+	Created int64
+}
+
+func TestFetchDBAndPager(t *testing.T) {
+	u := dt.NewUnitTest(false)
+	for _, name := range []string{"s0", "s1"} {
+		db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:sharding_%s?mode=memory&cache=shared", name)), &gorm.Config{})
+		assert.Nil(t, err)
+		for shard := 0; shard < 4; shard++ {
+			table := fmt.Sprintf("orders_%d", shard)
+			assert.Nil(t, db.Migrator().DropTable(table))
+			assert.Nil(t, db.Table(table).AutoMigrate(&order{}))
+		}
+		u.InstallDB(func() interface{} { return db }, name)
+	}
+	u.Run()
+
+	r := &RouterImpl{rules: make(map[string]*Rule)}
+	assert.Nil(t, r.Register(Rule{Table: "orders", Strategy: HashMod(4), DataSources: []string{"s0", "s1"}}))
+	repo := &dt.Repository{}
+	repo.BeginRequest(dt.NewWorker(false, nil))
+
+	for i := 0; i < 10; i++ {
+		userID := i % 5
+		id, err := r.NextID("orders", userID)
+		assert.Nil(t, err)
+		var db *gorm.DB
+		assert.Nil(t, r.FetchDB(repo, &db, "orders", id))
+		assert.Nil(t, db.Create(&order{ID: id, UserID: userID, Created: int64(i)}).Error)
+	}
+
+	var db *gorm.DB
+	assert.Nil(t, r.FetchDB(repo, &db, "orders", 3))
+	var orders []order
+	assert.Nil(t, db.Find(&orders).Error)
+	assert.Len(t, orders, 2)
+	for _, o := range orders {
+		assert.Equal(t, 3, o.UserID)
+	}
+
+	dbs, err := r.FetchAllDB(repo, "orders")
+	assert.Nil(t, err)
+	assert.Len(t, dbs, 4)
+
+	p := (&pager.PagerImpl{}).DescPager("created").SetPage(2, 4)
+	orders = nil
+	assert.Nil(t, p.ExecuteShards(dbs, &orders))
+	assert.Equal(t, 3, p.TotalPage())
+	created := []int64{}
+	for _, o := range orders {
+		created = append(created, o.Created)
+	}
+	assert.Equal(t, []int64{5, 4, 3, 2}, created)
+
+	for i := range dbs {
+		dbs[i] = dbs[i].Where("user_id IN ?", []int{1, 2})
+	}
+	var rows []map[string]interface{}
+	assert.Nil(t, (&pager.PagerImpl{}).AscPager("created").ExecuteShards(dbs, &rows))
+	created = []int64{}
+	for _, row := range rows {
+		created = append(created, row["created"].(int64))
+	}
+	assert.Equal(t, []int64{1, 2, 6, 7}, created)
+}
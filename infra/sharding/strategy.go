@@ -0,0 +1,169 @@
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// ErrUnsupportedKey 分片键的类型不被分片策略支持
+var ErrUnsupportedKey = errors.New("sharding: unsupported shard key")
+
+// Strategy 分片策略，将分片键映射到分片序号 [0, Shards())
+type Strategy interface {
+	Shard(key interface{}) (int, error)
+	Shards() int
+}
+
+// hashMod .
+type hashMod struct {
+	n int
+}
+
+// HashMod 按分片键取模分为 n 片，整数直接取模，字符串与 []byte 先做 fnv 哈希
+// 使用 Router.NextID 生成的 ID 取模后与生成时的分片一致
+func HashMod(n int) Strategy {
+	if n <= 0 {
+		panic("sharding: HashMod requires n > 0")
+	}
+	return &hashMod{n: n}
+}
+
+// Shard .
+func (h *hashMod) Shard(key interface{}) (int, error) {
+	var v uint64
+	switch k := key.(type) {
+	case string:
+		v = fnv32([]byte(k))
+	case []byte:
+		v = fnv32(k)
+	default:
+		i, ok := toInt64(key)
+		if !ok {
+			return 0, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+		}
+		if i < 0 {
+			i = -i
+		}
+		v = uint64(i)
+	}
+	return int(v % uint64(h.n)), nil
+}
+
+// Shards .
+func (h *hashMod) Shards() int {
+	return h.n
+}
+
+// rangeByID .
+type rangeByID struct {
+	bounds []int64
+}
+
+// RangeByID 按整数范围分片，bounds 为升序的分界值，分片 i 保存 [bounds[i-1], bounds[i]) 的数据，共 len(bounds)+1 片
+//
+//	RangeByID(10000000, 20000000) // [0, 1e7) [1e7, 2e7) [2e7, +∞)
+func RangeByID(bounds ...int64) Strategy {
+	if !sort.SliceIsSorted(bounds, func(i, j int) bool { return bounds[i] < bounds[j] }) {
+		panic("sharding: RangeByID bounds must be ascending")
+	}
+	return &rangeByID{bounds: bounds}
+}
+
+// Shard .
+func (r *rangeByID) Shard(key interface{}) (int, error) {
+	i, ok := toInt64(key)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return sort.Search(len(r.bounds), func(n int) bool { return r.bounds[n] > i }), nil
+}
+
+// Shards .
+func (r *rangeByID) Shards() int {
+	return len(r.bounds) + 1
+}
+
+// rangeByTime .
+type rangeByTime struct {
+	bounds []time.Time
+}
+
+// RangeByTime 按时间范围分片，bounds 为升序的分界时间，分片 i 保存 [bounds[i-1], bounds[i]) 的数据，共 len(bounds)+1 片
+// 分片键为 time.Time 或毫秒时间戳
+func RangeByTime(bounds ...time.Time) Strategy {
+	if !sort.SliceIsSorted(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) }) {
+		panic("sharding: RangeByTime bounds must be ascending")
+	}
+	return &rangeByTime{bounds: bounds}
+}
+
+// Monthly 从 start 所在月份开始按自然月分为 months 片，早于 start 的数据在第一片，晚于最后一个月的数据在最后一片
+func Monthly(start time.Time, months int) Strategy {
+	first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location())
+	bounds := make([]time.Time, 0, months)
+	for i := 1; i < months; i++ {
+		bounds = append(bounds, first.AddDate(0, i, 0))
+	}
+	return RangeByTime(bounds...)
+}
+
+// Shard .
+func (r *rangeByTime) Shard(key interface{}) (int, error) {
+	var t time.Time
+	switch k := key.(type) {
+	case time.Time:
+		t = k
+	case *time.Time:
+		if k == nil {
+			return 0, fmt.Errorf("%w: nil", ErrUnsupportedKey)
+		}
+		t = *k
+	default:
+		ms, ok := toInt64(key)
+		if !ok {
+			return 0, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+		}
+		t = time.UnixMilli(ms)
+	}
+	return sort.Search(len(r.bounds), func(n int) bool { return r.bounds[n].After(t) }), nil
+}
+
+// Shards .
+func (r *rangeByTime) Shards() int {
+	return len(r.bounds) + 1
+}
+
+func fnv32(b []byte) uint64 {
+	h := fnv.New32a()
+	h.Write(b)
+	return uint64(h.Sum32())
+}
+
+func toInt64(key interface{}) (int64, bool) {
+	switch k := key.(type) {
+	case int:
+		return int64(k), true
+	case int8:
+		return int64(k), true
+	case int16:
+		return int64(k), true
+	case int32:
+		return int64(k), true
+	case int64:
+		return k, true
+	case uint:
+		return int64(k), true
+	case uint8:
+		return int64(k), true
+	case uint16:
+		return int64(k), true
+	case uint32:
+		return int64(k), true
+	case uint64:
+		return int64(k), true
+	}
+	return 0, false
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// loadTimeout 加载数据源的超时时间，加载方与等待方共用
+var loadTimeout = 20 * time.Second
+
+// loadFlights 读取数据源的按 key 防击穿，Get 与 GetMany 共用，同一 key 同时只有一个 loader 在加载
+var loadFlights = &flightGroup{calls: make(map[string]*flightCall)}
+
+type flightCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// claim 返回本次需要加载的 key 与其它调用正在加载的 key
+func (g *flightGroup) claim(keys []string) (owned map[string]*flightCall, waiting map[string]*flightCall) {
+	owned = make(map[string]*flightCall)
+	waiting = make(map[string]*flightCall)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		if call, ok := g.calls[key]; ok {
+			waiting[key] = call
+			continue
+		}
+		call := &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		owned[key] = call
+	}
+	return
+}
+
+// finish 发布加载结果
+func (g *flightGroup) finish(owned map[string]*flightCall, values map[string][]byte, err error) {
+	g.mu.Lock()
+	for key := range owned {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	for key, call := range owned {
+		call.value, call.err = values[key], err
+		close(call.done)
+	}
+}
+
+// GetMany 批量读取缓存，依次读取一级缓存、进程内缓存、redis，未命中的 key 一次性交给 loader 加载并通过 pipeline 反写
+// 返回结果只包含存在的 key，开启负缓存时 loader 未返回的 key 按负缓存时间缓存
+func (cache *CacheImpl) GetMany(keys []string, loader func(missing []string) (map[string][]byte, error), expiration ...time.Duration) (result map[string][]byte, err error) {
+	result = make(map[string][]byte, len(keys))
+	expire := cache.expiration
+	if len(expiration) != 0 {
+		expire = expiration[0]
+	}
+	tier := cache.local()
+	for _, key := range keys {
+		cache.recordAccess(key)
+	}
+
+	// 读取一级缓存与进程内缓存
+	missing := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := result[key]; ok {
+			continue
+		}
+		cacheBytes, err := cache.getStore(key)
+		if err != nil {
+			return nil, err
+		}
+		if cacheBytes != nil {
+			cache.recordHit(tierStore, 1)
+			result[key] = cacheBytes
+			continue
+		}
+		if tier != nil {
+			if cacheBytes, ok := tier.get(key); ok {
+				cache.recordHit(tierLocal, 1)
+				if !isNegative(cacheBytes) {
+					cache.setStore(key, cacheBytes)
+					result[key] = cacheBytes
+				}
+				continue
+			}
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	// 读取二级缓存
+	fetched, err := cache.getRedisMany(missing)
+	if err != nil {
+		return nil, err
+	}
+	notCached := make([]string, 0, len(missing))
+	for _, key := range missing {
+		cacheBytes, ok := fetched[key]
+		if !ok {
+			notCached = append(notCached, key)
+			continue
+		}
+		cache.recordHit(tierRedis, 1)
+		if tier != nil {
+			tier.set(key, cacheBytes, cache.expirationOf(cacheBytes, expire))
+		}
+		if !isNegative(cacheBytes) {
+			cache.setStore(key, cacheBytes)
+			result[key] = cacheBytes
+		}
+	}
+
+	// 布隆过滤器判定不存在
+	if cache.bloom != nil {
+		candidates := notCached[:0]
+		for _, key := range notCached {
+			if cache.bloom.mightContain(cache.Worker().Context(), key) {
+				candidates = append(candidates, key)
+			}
+		}
+		notCached = candidates
+	}
+	if len(notCached) == 0 {
+		return
+	}
+
+	// 未命中缓存 读取DB
+	cache.recordMiss(len(notCached))
+	start := time.Now()
+	loaded, err := cache.loadMany(notCached, loader)
+	cache.recordLoad(start, err)
+	if err != nil {
+		return nil, err
+	}
+	writes := make(map[string][]byte, len(notCached))
+	for _, key := range notCached {
+		cacheBytes, ok := loaded[key]
+		if ok && cacheBytes != nil {
+			cache.setStore(key, cacheBytes)
+			result[key] = cacheBytes
+			writes[key] = cacheBytes
+		} else if cache.negativeExp > 0 {
+			writes[key] = negativeValue
+		}
+	}
+	for key, cacheBytes := range writes {
+		if tier != nil {
+			tier.set(key, cacheBytes, cache.expirationOf(cacheBytes, expire))
+		}
+	}
+	err = cache.setRedisMany(writes, expire, time.Since(start))
+	return
+}
+
+// loadMany 调用 loader 加载未被其它调用加载中的 key，并等待其余 key 的加载结果
+// 加载期间 Worker 的 Context 附加 loadTimeout，数据源经由 Worker 读取时随之超时，避免等待方一直阻塞在同一个 key 上
+func (cache *CacheImpl) loadMany(keys []string, loader func(missing []string) (map[string][]byte, error)) (map[string][]byte, error) {
+	if loader == nil {
+		return nil, errors.New("Undefined source")
+	}
+	if !cache.singleFlight {
+		return loader(keys)
+	}
+
+	owned, waiting := loadFlights.claim(keys)
+	cache.recordCoalesced(len(waiting))
+	result := make(map[string][]byte, len(keys))
+	if len(owned) > 0 {
+		ownedKeys := make([]string, 0, len(owned))
+		for key := range owned {
+			ownedKeys = append(ownedKeys, key)
+		}
+		var (
+			values map[string][]byte
+			err    error
+		)
+		func() {
+			worker := cache.Worker()
+			parent := worker.Context()
+			ctx, cancel := context.WithTimeout(parent, loadTimeout)
+			worker.WithContext(ctx)
+			defer func() {
+				worker.WithContext(parent)
+				cancel()
+				if perr := recover(); perr != nil {
+					err = fmt.Errorf(fmt.Sprint(perr))
+				}
+				loadFlights.finish(owned, values, err)
+			}()
+			values, err = loader(ownedKeys)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			result[key] = value
+		}
+	}
+
+	timeout := time.NewTimer(loadTimeout)
+	defer timeout.Stop()
+	for key, call := range waiting {
+		select {
+		case <-call.done:
+		case <-timeout.C:
+			return nil, errors.New("getCall timeout")
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		if call.value != nil {
+			result[key] = call.value
+		}
+	}
+	return result, nil
+}
+
+// getRedisMany 集群模式使用 pipeline 逐个 GET，避免跨 slot 的 MGET
+func (cache *CacheImpl) getRedisMany(keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	if cache.client == nil {
+		return result, nil
+	}
+	ctx := cache.Worker().Context()
+	if _, ok := cache.client.(*redis.ClusterClient); ok {
+		pipe := cache.client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		for i, cmd := range cmds {
+			if cacheBytes, err := cmd.Bytes(); err == nil {
+				setFetched(result, keys[i], cacheBytes)
+			}
+		}
+		return result, nil
+	}
+
+	values, err := cache.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if str, ok := value.(string); ok {
+			setFetched(result, keys[i], []byte(str))
+		}
+	}
+	return result, nil
+}
+
+// setFetched 逻辑过期的值视为未命中，批量读取时随其它未命中的 key 一起加载
+func setFetched(result map[string][]byte, key string, raw []byte) {
+	value, expireAt, _, wrapped := unwrapEntry(raw)
+	if wrapped && !time.Now().Before(expireAt) {
+		return
+	}
+	result[key] = value
+}
+
+// setRedisMany 通过 pipeline 反写二级缓存
+func (cache *CacheImpl) setRedisMany(values map[string][]byte, expire, delta time.Duration) (err error) {
+	if cache.client == nil || len(values) == 0 {
+		return
+	}
+	client, stale, tags, prefix := cache.client, cache.stale, cache.tags, cache.prefixLabel()
+	ctx := cache.Worker().Context()
+	write := func(ctx context.Context) error {
+		pipe := client.Pipeline()
+		keys := make([]string, 0, len(values))
+		for key, cacheBytes := range values {
+			keys = append(keys, key)
+			if !isNegative(cacheBytes) && stale.enabled() {
+				pipe.Set(ctx, key, wrapEntry(cacheBytes, time.Now().Add(expire), delta), expire+stale.window())
+				continue
+			}
+			pipe.Set(ctx, key, cacheBytes, cache.expirationOf(cacheBytes, expire))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		return tagKeys(ctx, client, tags, keys, expire+stale.window())
+	}
+	if !cache.asyncWrite {
+		return write(ctx)
+	}
+	go func() {
+		var err error
+		defer func() {
+			if perr := recover(); perr != nil {
+				err = fmt.Errorf(fmt.Sprint(perr))
+			}
+			if err != nil {
+				dt.Logger().Errorf("Failed to set cache, keys:%d, err:%v", len(values), err)
+				recordAsyncWriteFailure(prefix)
+			}
+		}()
+		err = write(ctx)
+	}()
+	return
+}
+
+// expirationOf 负缓存使用独立的有效期
+func (cache *CacheImpl) expirationOf(cacheBytes []byte, expire time.Duration) time.Duration {
+	if isNegative(cacheBytes) {
+		return cache.negativeExp
+	}
+	return expire
+}
@@ -0,0 +1,141 @@
+package store
+
+/*
+	布隆过滤器
+	防止缓存穿透，调用方将已存在的 key 写入过滤器，Cache.Get 在调用数据源前检查 key 是否可能存在
+	基于 redis 位图 (SETBIT/GETBIT)，未安装 redis 时使用进程内位图
+
+	Created by Dustin.zhu on 2023/09/04.
+*/
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// bloomRedisPrefix 位图 key 前缀
+const bloomRedisPrefix = "dt:bloom:"
+
+// BloomFilter .
+type BloomFilter struct {
+	client redis.Cmdable
+	key    string
+	bits   uint64 // 位图长度
+	hashes uint64 // 哈希函数个数
+
+	mu    sync.RWMutex
+	local []uint64 // 进程内位图 client 为 nil 时使用
+}
+
+// NewBloomFilter 按预计元素个数与期望误判率计算位图长度与哈希函数个数, client 为 nil 时使用进程内位图
+func NewBloomFilter(client redis.Cmdable, name string, expectedItems uint64, falsePositiveRate float64) *BloomFilter {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	bits := uint64(math.Ceil(-float64(expectedItems) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Max(1, math.Round(float64(bits)/float64(expectedItems)*math.Ln2)))
+	filter := &BloomFilter{
+		client: client,
+		key:    bloomRedisPrefix + name,
+		bits:   bits,
+		hashes: hashes,
+	}
+	if client == nil {
+		filter.local = make([]uint64, (bits+63)/64)
+	}
+	return filter
+}
+
+// Add 写入已存在的 key
+func (f *BloomFilter) Add(ctx context.Context, keys ...string) error {
+	if f.client == nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, key := range keys {
+			for _, offset := range f.offsets(key) {
+				f.local[offset/64] |= 1 << (offset % 64)
+			}
+		}
+		return nil
+	}
+	pipe := f.client.Pipeline()
+	for _, key := range keys {
+		for _, offset := range f.offsets(key) {
+			pipe.SetBit(ctx, f.key, int64(offset), 1)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// MightContain key 可能存在时返回 true，返回 false 时 key 一定不存在
+func (f *BloomFilter) MightContain(ctx context.Context, key string) (bool, error) {
+	offsets := f.offsets(key)
+	if f.client == nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		for _, offset := range offsets {
+			if f.local[offset/64]&(1<<(offset%64)) == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	pipe := f.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(offsets))
+	for i, offset := range offsets {
+		cmds[i] = pipe.GetBit(ctx, f.key, int64(offset))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Reset 清空过滤器，用于重建
+func (f *BloomFilter) Reset(ctx context.Context) error {
+	if f.client == nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.local = make([]uint64, (f.bits+63)/64)
+		return nil
+	}
+	return f.client.Del(ctx, f.key).Err()
+}
+
+// mightContain Cache.Get 使用，redis 异常时放行到数据源
+func (f *BloomFilter) mightContain(ctx context.Context, key string) bool {
+	ok, err := f.MightContain(ctx, key)
+	if err != nil {
+		dt.Logger().Warnf("bloom filter check failed, key: %s, err: %v", key, err)
+		return true
+	}
+	return ok
+}
+
+// offsets 双重哈希 h1 + i*h2
+func (f *BloomFilter) offsets(key string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	offsets := make([]uint64, f.hashes)
+	for i := uint64(0); i < f.hashes; i++ {
+		offsets[i] = (h1 + i*h2) % f.bits
+	}
+	return offsets
+}
@@ -4,7 +4,11 @@ package store
 	分布式缓存组件
 	实现了一级缓存 二级缓存 集成redis
 	一级缓存的生命周期为单个请求，仅用于函数间调用
+	InstallLocalCache 开启后，一级缓存与二级缓存之间增加进程内缓存，Delete 时广播到所有 pod
 	基于sync/singlefight实现缓存防击穿 Service->Cache->Singleflight->DB
+	写入时可通过 Tags 关联标签，InvalidateTag 删除标签下的全部缓存
+	SetStale 开启逻辑过期后，热点 key 过期时由单个 pod 后台刷新，防止缓存雪崩
+	SetNegativeExpiration 开启后，数据源返回 nil 时写入空值标记(负缓存)，有效期独立设置；可选布隆过滤器在读取数据源前拦截不存在的 key
 
 	采用 Cache-Aside Pattern （旁路缓存模式）机制实现
 	读：读的时候，先读缓存，缓存命中，直接返回数据。缓存没有命中，就去读数据库，然后用数据库的数据更新缓存，再返回数据
@@ -16,6 +20,7 @@ package store
 //go:generate mockgen -package mock_infra -source cacher.go -destination ./mock/cache_mock.go
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -39,10 +44,14 @@ func init() {
 type Cache interface {
 	// 获取缓存
 	Get(key string, expiration ...time.Duration) (cacheBytes []byte, err error)
+	// 批量获取缓存 未命中的 key 一次性交给 loader 加载
+	GetMany(keys []string, loader func(missing []string) (map[string][]byte, error), expiration ...time.Duration) (map[string][]byte, error)
 	// 删除实体缓存
 	Delete(key string, async ...bool) error
 	// 设置数据源
 	SetSource(func() (cacheBytes []byte, err error)) Cache
+	// 设置读取 Worker 的数据源 逻辑过期的后台刷新传入独立的 Worker
+	SetWorkerSource(func(worker dt.Worker) (cacheBytes []byte, err error)) Cache
 	// 设置前缀
 	SetPrefix(string) Cache
 	// 设置缓存时间 默认5分钟
@@ -53,8 +62,31 @@ type Cache interface {
 	SetSingleFlight(bool) Cache
 	// 关闭二级缓存 关闭后只有一级缓存生效
 	CloseSecondCache() Cache
+	// 设置进程内缓存 安装了前缀对应的进程内缓存时默认开启
+	SetLocalCache(bool) Cache
+	// 设置 Get[T] 使用的编解码器 默认 JSONCodec
+	SetCodec(Codec) Cache
+	// 设置 Get[T] 缓存值的版本 与类型结构版本共同写入缓存值头部
+	SetVersion(string) Cache
+	// Get[T] 使用的编解码器
+	Codec() Codec
+	// Get[T] 缓存值的版本
+	Version() string
+	// 设置负缓存时间 数据源返回 nil 时缓存空值 默认0 关闭
+	SetNegativeExpiration(time.Duration) Cache
+	// 设置布隆过滤器 过滤器判定不存在的 key 不读取数据源
+	SetBloomFilter(*BloomFilter) Cache
+	// 设置逻辑过期 提前刷新、旧值后台刷新与数据源出错时返回旧值
+	SetStale(StaleOptions) Cache
+	// 设置本次写入缓存关联的标签
+	Tags(tags ...string) Cache
+	// 删除标签下的全部缓存
+	InvalidateTag(tags ...string) error
 }
 
+// negativeValue 负缓存的空值标记
+var negativeValue = []byte("\x00dt:cache:nil")
+
 var sfGroup singleflight.Group
 
 // CacheImpl .
@@ -64,8 +96,16 @@ type CacheImpl struct {
 	prefix       string                                // 缓存前缀
 	expiration   time.Duration                         // 缓存有效期
 	call         func() (cacheBytes []byte, err error) // 未命中缓冲的回调函数
+	source       func(dt.Worker) ([]byte, error)       // 读取 Worker 的数据源 后台刷新使用
 	singleFlight bool                                  // 缓存防击穿
 	client       redis.Cmdable                         // redis client
+	localCache   bool                                  // 进程内缓存
+	codec        Codec                                 // Get[T] 编解码器
+	version      string                                // Get[T] 缓存值版本
+	negativeExp  time.Duration                         // 负缓存有效期
+	bloom        *BloomFilter                          // 布隆过滤器
+	stale        StaleOptions                          // 逻辑过期
+	tags         []string                              // 写入缓存关联的标签
 }
 
 // BeginRequest .
@@ -73,12 +113,23 @@ func (cache *CacheImpl) BeginRequest(worker dt.Worker) {
 	cache.expiration = 5 * time.Minute
 	cache.singleFlight = true
 	cache.asyncWrite = false
+	cache.localCache = true
+	cache.codec = JSONCodec
+	cache.version = ""
+	cache.negativeExp = 0
+	cache.bloom = nil
+	cache.stale = StaleOptions{}
+	cache.tags = nil
 	cache.client = cache.Redis()
+	if cache.client != nil {
+		subscribeInvalidation(cache.client)
+	}
 	cache.Infra.BeginRequest(worker)
 }
 
 // Get .
 func (cache *CacheImpl) Get(key string, expiration ...time.Duration) (cacheBytes []byte, err error) {
+	cache.recordAccess(key)
 	// 读取一级缓存
 	cacheBytes, err = cache.getStore(key)
 	if err != nil {
@@ -87,9 +138,30 @@ func (cache *CacheImpl) Get(key string, expiration ...time.Duration) (cacheBytes
 
 	if cacheBytes != nil {
 		cache.Worker().Logger().Infof("fetched memstore, key=%v", key)
+		cache.recordHit(tierStore, 1)
 		return
 	}
 
+	var expire time.Duration
+	if len(expiration) != 0 {
+		expire = expiration[0]
+	} else {
+		expire = cache.expiration
+	}
+
+	// 读取进程内缓存
+	tier := cache.local()
+	if tier != nil {
+		if cacheBytes, ok := tier.get(key); ok {
+			cache.recordHit(tierLocal, 1)
+			if isNegative(cacheBytes) {
+				return nil, nil
+			}
+			cache.setStore(key, cacheBytes)
+			return cacheBytes, nil
+		}
+	}
+
 	// 读取二级缓存
 	cacheBytes, err = cache.getRedis(key)
 	if err != redis.Nil && err != nil {
@@ -97,31 +169,83 @@ func (cache *CacheImpl) Get(key string, expiration ...time.Duration) (cacheBytes
 	}
 	if err != redis.Nil {
 		cache.Worker().Logger().Infof("fetched redis cache, key=%v", key)
+		cache.recordHit(tierRedis, 1)
+		if value, expireAt, delta, wrapped := unwrapEntry(cacheBytes); wrapped {
+			return cache.serveWrapped(key, value, expireAt, delta, expire, tier)
+		}
+		if tier != nil {
+			tier.set(key, cacheBytes, cache.expirationOf(cacheBytes, expire))
+		}
+		if isNegative(cacheBytes) {
+			return nil, nil
+		}
 		// 刷新一级缓存
 		cache.setStore(key, cacheBytes)
 		return
 	}
 
+	cache.recordMiss(1)
+	return cache.load(key, expire, tier)
+}
+
+// reload 跳过已读取的缓存值 读取数据源并覆盖缓存
+func (cache *CacheImpl) reload(key string, expiration ...time.Duration) ([]byte, error) {
+	expire := cache.expiration
+	if len(expiration) != 0 {
+		expire = expiration[0]
+	}
+	return cache.load(key, expire, cache.local())
+}
+
+// load 未命中缓存时读取数据源并反写缓存
+func (cache *CacheImpl) load(key string, expire time.Duration, tier *localTier) (cacheBytes []byte, err error) {
+	// 布隆过滤器判定不存在
+	if cache.bloom != nil && !cache.bloom.mightContain(cache.Worker().Context(), key) {
+		return nil, nil
+	}
+
 	// 未命中缓存 读取DB
+	start := time.Now()
 	cacheBytes, err = cache.getCall(key)
+	cache.recordLoad(start, err)
 	if err != nil {
 		return
 	}
 
 	// 反写缓存
+	writeBytes := cacheBytes
+	if cacheBytes == nil {
+		if cache.negativeExp <= 0 {
+			return
+		}
+		writeBytes, expire = negativeValue, cache.negativeExp
+	}
 	cache.setStore(key, cacheBytes)
+	if tier != nil {
+		tier.set(key, writeBytes, expire)
+	}
+	if cacheBytes != nil && cache.stale.enabled() {
+		writeBytes = wrapEntry(cacheBytes, time.Now().Add(expire), time.Since(start))
+		expire += cache.stale.window()
+	}
+	err = cache.setRedis(key, writeBytes, expire)
+	return
+}
+
+// setRedis 写入二级缓存
+func (cache *CacheImpl) setRedis(key string, cacheBytes []byte, expire time.Duration) (err error) {
 	if cache.client == nil {
 		return
 	}
-	var expire time.Duration
-	if len(expiration) != 0 {
-		expire = expiration[0]
-	} else {
-		expire = cache.expiration
+	client, tags, prefix := cache.client, cache.tags, cache.prefixLabel()
+	set := func(ctx context.Context) error {
+		if err := client.Set(ctx, key, cacheBytes, expire).Err(); err != nil {
+			return err
+		}
+		return tagKeys(ctx, client, tags, []string{key}, expire)
 	}
 	if !cache.asyncWrite {
-		err = cache.client.Set(cache.Worker().Context(), key, cacheBytes, expire).Err()
-		return
+		return set(cache.Worker().Context())
 	}
 	go func() {
 		var err error
@@ -131,9 +255,10 @@ func (cache *CacheImpl) Get(key string, expiration ...time.Duration) (cacheBytes
 			}
 			if err != nil {
 				dt.Logger().Errorf("Failed to set cache, key:%s, err:%v", key, err)
+				recordAsyncWriteFailure(prefix)
 			}
 		}()
-		err = cache.client.Set(cache.Worker().Context(), key, cacheBytes, expire).Err()
+		err = set(cache.Worker().Context())
 	}()
 
 	return
@@ -144,12 +269,19 @@ func (cache *CacheImpl) Delete(key string, async ...bool) error {
 	if !cache.Worker().IsDeferRecycle() {
 		cache.Worker().Store().Remove(key)
 	}
+	evictLocal(key)
 	client := cache.client
 	if client == nil {
 		return nil
 	}
+	del := func(ctx context.Context) error {
+		if err := client.Del(ctx, key).Err(); err != nil {
+			return err
+		}
+		return client.Publish(ctx, InvalidateChannel, key).Err()
+	}
 	if len(async) == 0 {
-		return client.Del(cache.Worker().Context(), key).Err()
+		return del(cache.Worker().Context())
 	}
 	go func() {
 		var err error
@@ -161,7 +293,7 @@ func (cache *CacheImpl) Delete(key string, async ...bool) error {
 				dt.Logger().Errorf("Failed to delete cache, key:%s, err:%v", key, err)
 			}
 		}()
-		err = client.Del(cache.Worker().Context(), key).Err()
+		err = del(cache.Worker().Context())
 	}()
 	return nil
 }
@@ -169,6 +301,16 @@ func (cache *CacheImpl) Delete(key string, async ...bool) error {
 // SetSource 设置数据源
 func (cache *CacheImpl) SetSource(call func() ([]byte, error)) Cache {
 	cache.call = call
+	cache.source = nil
+	return cache
+}
+
+// SetWorkerSource 设置读取 Worker 的数据源 同步加载传入当前 Worker
+func (cache *CacheImpl) SetWorkerSource(source func(worker dt.Worker) ([]byte, error)) Cache {
+	cache.source = source
+	cache.call = func() ([]byte, error) {
+		return source(cache.Worker())
+	}
 	return cache
 }
 
@@ -202,6 +344,62 @@ func (cache *CacheImpl) CloseSecondCache() Cache {
 	return cache
 }
 
+// SetLocalCache 设置是否使用进程内缓存
+func (cache *CacheImpl) SetLocalCache(open bool) Cache {
+	cache.localCache = open
+	return cache
+}
+
+// SetCodec 设置 Get[T] 使用的编解码器
+func (cache *CacheImpl) SetCodec(codec Codec) Cache {
+	cache.codec = codec
+	return cache
+}
+
+// SetVersion 设置 Get[T] 缓存值的版本
+func (cache *CacheImpl) SetVersion(version string) Cache {
+	cache.version = version
+	return cache
+}
+
+// Codec .
+func (cache *CacheImpl) Codec() Codec {
+	if _, ok := cache.codec.(serializerCodec); ok {
+		return NewCodec(SerializerCodec.Name(), cache.Marshal, cache.Unmarshal)
+	}
+	return cache.codec
+}
+
+// Version .
+func (cache *CacheImpl) Version() string {
+	return cache.version
+}
+
+// SetNegativeExpiration 设置负缓存时间 默认关闭
+// 未识别空值标记的旧版本 pod 会将其当作数据读取，所有 pod 升级后再开启
+func (cache *CacheImpl) SetNegativeExpiration(expiration time.Duration) Cache {
+	cache.negativeExp = expiration
+	return cache
+}
+
+// SetBloomFilter 设置布隆过滤器
+func (cache *CacheImpl) SetBloomFilter(filter *BloomFilter) Cache {
+	cache.bloom = filter
+	return cache
+}
+
+func isNegative(cacheBytes []byte) bool {
+	return bytes.Equal(cacheBytes, negativeValue)
+}
+
+// local 返回当前前缀的进程内缓存分区，未开启时返回 nil
+func (cache *CacheImpl) local() *localTier {
+	if !cache.localCache {
+		return nil
+	}
+	return localTierOf(cache.prefix)
+}
+
 func (cache *CacheImpl) getStore(key string) ([]byte, error) {
 	if cache.Worker().IsDeferRecycle() {
 		return nil, nil
@@ -255,30 +453,17 @@ func (cache *CacheImpl) getCall(key string) (cacheBytes []byte, err error) {
 	}
 
 	if cache.singleFlight {
-		var err error
-		sonCtx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-		res := sfGroup.DoChan("call:"+key, func() (interface{}, error) {
-			resultBytes, e := cache.call()
-			if e != nil {
-				return nil, e
+		loaded, err := cache.loadMany([]string{key}, func([]string) (map[string][]byte, error) {
+			resultBytes, err := cache.call()
+			if err != nil {
+				return nil, err
 			}
-			return resultBytes, e
+			return map[string][]byte{key: resultBytes}, nil
 		})
-		select {
-		case r := <-res:
-			if r.Err != nil {
-				return nil, r.Err
-			}
-			cacheData := r.Val
-			if cacheData == nil {
-				return nil, nil
-			}
-			return cacheData.([]byte), err
-		case <-sonCtx.Done():
-			sfGroup.Forget("call:" + key)
-			return nil, errors.New("getCall timeout")
+		if err != nil {
+			return nil, err
 		}
+		return loaded[key], nil
 	}
 	cacheBytes, err = cache.call()
 	if err != nil {
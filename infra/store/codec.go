@@ -0,0 +1,157 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Codec 缓存值的编解码器
+type Codec interface {
+	// Name 写入缓存值头部，读取时编解码器不一致视为未命中
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec 默认编解码器
+	JSONCodec Codec = funcCodec{name: "json", marshal: json.Marshal, unmarshal: json.Unmarshal}
+	// GobCodec 二进制编解码器，体积与解码开销均小于 JSON，仅导出字段参与编码
+	GobCodec Codec = gobCodec{}
+	// SerializerCodec 使用 Application.InstallSerializer 安装的序列化函数
+	SerializerCodec Codec = serializerCodec{}
+)
+
+// NewCodec 使用自定义函数创建编解码器
+func NewCodec(name string, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return funcCodec{name: name, marshal: marshal, unmarshal: unmarshal}
+}
+
+type funcCodec struct {
+	name      string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (c funcCodec) Name() string                               { return c.name }
+func (c funcCodec) Marshal(v interface{}) ([]byte, error)      { return c.marshal(v) }
+func (c funcCodec) Unmarshal(data []byte, v interface{}) error { return c.unmarshal(data, v) }
+
+// serializerCodec 由 CacheImpl.Codec 绑定到当前应用的序列化函数
+type serializerCodec struct{}
+
+func (serializerCodec) Name() string { return "serializer" }
+
+func (serializerCodec) Marshal(v interface{}) ([]byte, error) {
+	return nil, errors.New("SerializerCodec is not bound to an application")
+}
+
+func (serializerCodec) Unmarshal(data []byte, v interface{}) error {
+	return errors.New("SerializerCodec is not bound to an application")
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 缓存值格式: magic codec名 0x00 版本(8字节十六进制) 0x00 数据
+const valueMagic = 0xD7
+
+var typeVersions sync.Map
+
+// encodeValue 编码并写入头部
+func encodeValue(codec Codec, version string, v interface{}) ([]byte, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(data)+len(codec.Name())+len(version)+3)
+	buf = append(buf, valueMagic)
+	buf = append(buf, codec.Name()...)
+	buf = append(buf, 0)
+	buf = append(buf, version...)
+	buf = append(buf, 0)
+	return append(buf, data...), nil
+}
+
+// decodeValue 校验头部并解码，头部不一致返回 stale=true
+func decodeValue(codec Codec, version string, data []byte, v interface{}) (stale bool, err error) {
+	if len(data) == 0 || data[0] != valueMagic {
+		return true, nil
+	}
+	parts := bytes.SplitN(data[1:], []byte{0}, 3)
+	if len(parts) != 3 || string(parts[0]) != codec.Name() || string(parts[1]) != version {
+		return true, nil
+	}
+	if err = codec.Unmarshal(parts[2], v); err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// typeVersion 由类型结构计算的版本，字段名、类型或 tag 变化时版本随之变化
+func typeVersion(t reflect.Type) string {
+	if v, ok := typeVersions.Load(t); ok {
+		return v.(string)
+	}
+	var sb strings.Builder
+	describeType(&sb, t, make(map[reflect.Type]bool))
+	h := fnv.New32a()
+	h.Write([]byte(sb.String()))
+	version := fmt.Sprintf("%08x", h.Sum32())
+	typeVersions.Store(t, version)
+	return version
+}
+
+func describeType(sb *strings.Builder, t reflect.Type, visited map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		sb.WriteString(t.Kind().String())
+		sb.WriteByte('[')
+		describeType(sb, t.Elem(), visited)
+		sb.WriteByte(']')
+	case reflect.Map:
+		sb.WriteString("map[")
+		describeType(sb, t.Key(), visited)
+		sb.WriteByte(']')
+		describeType(sb, t.Elem(), visited)
+	case reflect.Struct:
+		sb.WriteString(t.String())
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		sb.WriteByte('{')
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			sb.WriteString(f.Name)
+			sb.WriteByte(' ')
+			describeType(sb, f.Type, visited)
+			sb.WriteByte(' ')
+			sb.WriteString(string(f.Tag))
+			sb.WriteByte(';')
+		}
+		sb.WriteByte('}')
+	default:
+		sb.WriteString(t.String())
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+/*
+	进程内缓存 (L1)
+	位于请求级缓存与 redis 之间，按缓存前缀分区，每个分区是带 TTL 的有界 LRU
+	Cache.Delete 通过 redis pub/sub 广播，所有 pod 的进程内缓存同时失效
+
+	Created by Dustin.zhu on 2023/08/30.
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/infra/rate/sentinel/core/hotspot/cache"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// InvalidateChannel 缓存失效广播的 redis 频道
+const InvalidateChannel = "dt:cache:invalidate"
+
+// LocalCacheConfig 进程内缓存分区配置
+type LocalCacheConfig struct {
+	MaxEntries int           `yaml:"max_entries"` // 最大条目数 默认 10000
+	MaxBytes   int64         `yaml:"max_bytes"`   // 最大占用字节数 为0时不限制
+	TTL        time.Duration `yaml:"ttl"`         // 有效期 不超过 redis 缓存有效期 默认 1 分钟
+}
+
+var (
+	localMu    sync.RWMutex
+	localTiers = make(map[string]*localTier)
+	// subscribed 已订阅失效广播的 redis 客户端
+	subscribed sync.Map
+)
+
+// InstallLocalCache 为前缀 prefix 开启进程内缓存，prefix 为空时作为未单独配置前缀的默认分区
+func InstallLocalCache(prefix string, conf LocalCacheConfig) {
+	if conf.MaxEntries <= 0 {
+		conf.MaxEntries = 10000
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = time.Minute
+	}
+	tier := &localTier{conf: conf}
+	tier.lru, _ = cache.NewLRU(conf.MaxEntries, func(key, value interface{}) {
+		tier.bytes -= int64(len(value.(*localEntry).value))
+	})
+
+	localMu.Lock()
+	defer localMu.Unlock()
+	localTiers[prefix] = tier
+}
+
+// localTierOf 返回前缀对应的分区，未配置时返回默认分区
+func localTierOf(prefix string) *localTier {
+	localMu.RLock()
+	defer localMu.RUnlock()
+	if tier, ok := localTiers[prefix]; ok {
+		return tier
+	}
+	return localTiers[""]
+}
+
+// evictLocal 从所有分区删除 key
+func evictLocal(key string) {
+	localMu.RLock()
+	defer localMu.RUnlock()
+	for _, tier := range localTiers {
+		tier.remove(key)
+	}
+}
+
+// subscribeInvalidation 每个 redis 客户端只订阅一次失效广播
+func subscribeInvalidation(client redis.Cmdable) {
+	subscriber, ok := client.(interface {
+		Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+	})
+	if !ok {
+		return
+	}
+	if _, loaded := subscribed.LoadOrStore(client, true); loaded {
+		return
+	}
+	go func() {
+		pubsub := subscriber.Subscribe(context.Background(), InvalidateChannel)
+		for msg := range pubsub.Channel() {
+			evictLocal(msg.Payload)
+		}
+		dt.Logger().Warnf("cache invalidation subscription closed")
+		subscribed.Delete(client)
+	}()
+}
+
+// localEntry .
+type localEntry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// localTier 线程安全的有界 LRU 分区
+type localTier struct {
+	conf  LocalCacheConfig
+	mu    sync.Mutex
+	lru   *cache.LRU
+	bytes int64
+}
+
+func (t *localTier) get(key string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(*localEntry)
+	if time.Now().After(entry.expireAt) {
+		t.lru.Remove(key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (t *localTier) set(key string, value []byte, expiration time.Duration) {
+	if t.conf.MaxBytes > 0 && int64(len(value)) > t.conf.MaxBytes {
+		return
+	}
+	ttl := t.conf.TTL
+	if expiration > 0 && expiration < ttl {
+		ttl = expiration
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lru.Remove(key)
+	t.lru.Add(key, &localEntry{value: value, expireAt: time.Now().Add(ttl)})
+	t.bytes += int64(len(value))
+	for t.conf.MaxBytes > 0 && t.bytes > t.conf.MaxBytes {
+		if _, _, ok := t.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (t *localTier) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lru.Remove(key)
+}
@@ -0,0 +1,230 @@
+package store
+
+/*
+	缓存指标
+	按缓存前缀统计命中、未命中、数据源加载耗时与错误、防击穿合并次数与异步写失败次数
+	指标通过 sentinel 的 prometheus exporter 导出，HotKeysHandler 返回访问最多的 key
+	热点 key 使用 Space-Saving 算法统计，容量满时新 key 替换计数最小的 key 并继承其计数，访问频繁的 key 不会被冷 key 挤出
+
+	Created by Dustin.zhu on 2023/09/15.
+*/
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	metric_exporter "DT-Go/infra/rate/sentinel/exporter/metric"
+)
+
+const (
+	// hotKeyCapacity 统计访问次数的 key 数量上限
+	hotKeyCapacity = 1024
+
+	tierStore = "store"
+	tierLocal = "local"
+	tierRedis = "redis"
+)
+
+var (
+	hitCounter = metric_exporter.NewCounter(
+		"cache_hits_total",
+		"Total cache hits",
+		[]string{"prefix", "tier"})
+	missCounter = metric_exporter.NewCounter(
+		"cache_misses_total",
+		"Total cache misses",
+		[]string{"prefix"})
+	loadHistogram = metric_exporter.NewHistogram(
+		"cache_load_seconds",
+		"Cache source load latency",
+		[]float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		[]string{"prefix"})
+	loadErrorCounter = metric_exporter.NewCounter(
+		"cache_load_errors_total",
+		"Total cache source load errors",
+		[]string{"prefix"})
+	coalescedCounter = metric_exporter.NewCounter(
+		"cache_singleflight_shared_total",
+		"Total cache loads shared by singleflight",
+		[]string{"prefix"})
+	asyncWriteFailCounter = metric_exporter.NewCounter(
+		"cache_async_write_failures_total",
+		"Total asynchronous cache write failures",
+		[]string{"prefix"})
+
+	hotKeys = newSpaceSaving(hotKeyCapacity)
+)
+
+func init() {
+	metric_exporter.Register(hitCounter)
+	metric_exporter.Register(missCounter)
+	metric_exporter.Register(loadHistogram)
+	metric_exporter.Register(loadErrorCounter)
+	metric_exporter.Register(coalescedCounter)
+	metric_exporter.Register(asyncWriteFailCounter)
+}
+
+// hotKey 热点统计的 key
+type hotKey struct {
+	prefix string
+	key    string
+}
+
+// HotKey 热点 key 的访问次数，Count 为估计值，最多比实际次数多 Error
+type HotKey struct {
+	Prefix string `json:"prefix"`
+	Key    string `json:"key"`
+	Count  int64  `json:"count"`
+	Error  int64  `json:"error"`
+}
+
+// hotKeyCounter Space-Saving 的计数项
+type hotKeyCounter struct {
+	key   hotKey
+	count int64
+	err   int64
+	index int
+}
+
+// spaceSaving 按计数排列的小顶堆，堆顶为计数最小的 key
+type spaceSaving struct {
+	mu       sync.Mutex
+	capacity int
+	counters map[hotKey]*hotKeyCounter
+	heap     hotKeyHeap
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, counters: make(map[hotKey]*hotKeyCounter, capacity)}
+}
+
+// add 已统计的 key 计数加一，容量满时替换计数最小的 key
+func (s *spaceSaving) add(key hotKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[key]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		c := &hotKeyCounter{key: key, count: 1}
+		s.counters[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	c := s.heap[0]
+	delete(s.counters, c.key)
+	c.key, c.err = key, c.count
+	c.count++
+	s.counters[key] = c
+	heap.Fix(&s.heap, 0)
+}
+
+func (s *spaceSaving) snapshot(prefix string) []HotKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]HotKey, 0, len(s.heap))
+	for _, c := range s.heap {
+		if prefix != "" && c.key.prefix != prefix {
+			continue
+		}
+		result = append(result, HotKey{Prefix: c.key.prefix, Key: c.key.key, Count: c.count, Error: c.err})
+	}
+	return result
+}
+
+type hotKeyHeap []*hotKeyCounter
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	c := x.(*hotKeyCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// HotKeys 返回访问次数最多的 limit 个 key，prefix 不为空时只返回该前缀
+func HotKeys(prefix string, limit int) []HotKey {
+	result := hotKeys.snapshot(prefix)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Count > result[j].Count
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// HotKeysHandler 调试接口 ?prefix=user&limit=20
+//
+//	app.Get("/debug/cache/hotkeys", iris.FromStd(store.HotKeysHandler()))
+func HotKeysHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(HotKeys(r.URL.Query().Get("prefix"), limit))
+	})
+}
+
+// prefixLabel .
+func (cache *CacheImpl) prefixLabel() string {
+	if cache.prefix == "" {
+		return "default"
+	}
+	return cache.prefix
+}
+
+// recordAccess 统计 key 的访问次数
+func (cache *CacheImpl) recordAccess(key string) {
+	hotKeys.add(hotKey{prefix: cache.prefixLabel(), key: key})
+}
+
+func (cache *CacheImpl) recordHit(tier string, n int) {
+	if n > 0 {
+		hitCounter.Add(float64(n), cache.prefixLabel(), tier)
+	}
+}
+
+func (cache *CacheImpl) recordMiss(n int) {
+	if n > 0 {
+		missCounter.Add(float64(n), cache.prefixLabel())
+	}
+}
+
+func (cache *CacheImpl) recordLoad(start time.Time, err error) {
+	loadHistogram.Observe(time.Since(start).Seconds(), cache.prefixLabel())
+	if err != nil {
+		loadErrorCounter.Add(1, cache.prefixLabel())
+	}
+}
+
+func (cache *CacheImpl) recordCoalesced(n int) {
+	if n > 0 {
+		coalescedCounter.Add(float64(n), cache.prefixLabel())
+	}
+}
+
+func recordAsyncWriteFailure(prefix string) {
+	asyncWriteFailCounter.Add(1, prefix)
+}
@@ -0,0 +1,182 @@
+package store
+
+/*
+	逻辑过期与缓存雪崩保护
+	开启后写入 redis 的值携带逻辑过期时间与加载耗时，物理有效期 = 逻辑有效期 + 旧值可用窗口
+	1.逻辑过期前按 XFetch 算法概率性提前刷新
+	2.逻辑过期后 StaleWhileRevalidate 窗口内返回旧值，并在 redis 锁保护下后台刷新，同一时刻只有一个 pod 加载
+	3.超出窗口后同步加载，StaleIfError 窗口内数据源出错时返回旧值
+	4.后台刷新在独立的 Worker 上执行，需要 SetWorkerSource 设置数据源；SetSource 的数据源绑定请求的 Worker，不提前刷新，逻辑过期后同步加载
+
+	Created by Dustin.zhu on 2023/09/08.
+*/
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// refreshLockPrefix 后台刷新锁 key 前缀
+	refreshLockPrefix = "dt:cache:refresh:"
+	// refreshLockTTL 后台刷新锁有效期
+	refreshLockTTL = 10 * time.Second
+)
+
+var (
+	// entryMagic 携带逻辑过期时间的缓存值头部
+	entryMagic = []byte("\xd8dts")
+
+	// refreshUnlockScript KEYS[1] 刷新锁 ARGV[1] 持有者，锁已过期被其它 pod 获取时不删除
+	refreshUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+// StaleOptions 逻辑过期配置，均为0时关闭
+type StaleOptions struct {
+	StaleWhileRevalidate time.Duration // 逻辑过期后返回旧值并后台刷新的窗口
+	StaleIfError         time.Duration // 逻辑过期后数据源出错时返回旧值的窗口
+	EarlyRefreshBeta     float64       // XFetch 提前刷新系数 通常为1 越大越早刷新 为0时关闭
+}
+
+func (o StaleOptions) enabled() bool {
+	return o.StaleWhileRevalidate > 0 || o.StaleIfError > 0 || o.EarlyRefreshBeta > 0
+}
+
+// window 旧值可用窗口
+func (o StaleOptions) window() time.Duration {
+	if o.StaleWhileRevalidate > o.StaleIfError {
+		return o.StaleWhileRevalidate
+	}
+	return o.StaleIfError
+}
+
+// wrapEntry magic 逻辑过期时间(unix 毫秒) 加载耗时(毫秒) 数据
+func wrapEntry(value []byte, expireAt time.Time, delta time.Duration) []byte {
+	buf := make([]byte, len(entryMagic)+16, len(entryMagic)+16+len(value))
+	copy(buf, entryMagic)
+	binary.BigEndian.PutUint64(buf[len(entryMagic):], uint64(expireAt.UnixMilli()))
+	binary.BigEndian.PutUint64(buf[len(entryMagic)+8:], uint64(delta.Milliseconds()))
+	return append(buf, value...)
+}
+
+// unwrapEntry 非逻辑过期格式的值返回 ok=false
+func unwrapEntry(raw []byte) (value []byte, expireAt time.Time, delta time.Duration, ok bool) {
+	if len(raw) < len(entryMagic)+16 || !bytes.HasPrefix(raw, entryMagic) {
+		return raw, time.Time{}, 0, false
+	}
+	expireAt = time.UnixMilli(int64(binary.BigEndian.Uint64(raw[len(entryMagic):])))
+	delta = time.Duration(binary.BigEndian.Uint64(raw[len(entryMagic)+8:])) * time.Millisecond
+	return raw[len(entryMagic)+16:], expireAt, delta, true
+}
+
+// shouldEarlyRefresh XFetch: now - delta * beta * ln(rand) >= expiry
+func shouldEarlyRefresh(expireAt time.Time, delta time.Duration, beta float64) bool {
+	if beta <= 0 || delta <= 0 {
+		return false
+	}
+	gap := time.Duration(-float64(delta) * beta * math.Log(rand.Float64()))
+	return !time.Now().Add(gap).Before(expireAt)
+}
+
+// SetStale 设置逻辑过期
+func (cache *CacheImpl) SetStale(opts StaleOptions) Cache {
+	cache.stale = opts
+	return cache
+}
+
+// serveWrapped 处理携带逻辑过期时间的 redis 值
+func (cache *CacheImpl) serveWrapped(key string, value []byte, expireAt time.Time, delta, expire time.Duration, tier *localTier) ([]byte, error) {
+	age := time.Since(expireAt)
+	switch {
+	case age < 0:
+		if shouldEarlyRefresh(expireAt, delta, cache.stale.EarlyRefreshBeta) {
+			cache.refreshAsync(key, expire, tier)
+		}
+	case age <= cache.stale.StaleWhileRevalidate && cache.source != nil:
+		cache.refreshAsync(key, expire, tier)
+	default:
+		fresh, err := cache.load(key, expire, tier)
+		if err == nil {
+			return fresh, nil
+		}
+		if age > cache.stale.StaleIfError {
+			return nil, err
+		}
+		cache.Worker().Logger().Warnf("serve stale cache on source error, key=%v, err=%v", key, err)
+	}
+	if tier != nil && age < 0 {
+		tier.set(key, value, -age)
+	}
+	cache.setStore(key, value)
+	return value, nil
+}
+
+// refreshAsync 在 redis 锁保护下后台刷新，未抢到锁说明其它 pod 正在刷新
+// 请求结束后 Worker 的 Context 随之取消、服务对象随之回收，因此刷新使用调用方的 Bus 构造独立的 Worker，
+// 并附加刷新锁有效期的超时，调用方的 Worker 保持不变
+func (cache *CacheImpl) refreshAsync(key string, expire time.Duration, tier *localTier) {
+	client, source, opts, tags, prefix := cache.client, cache.source, cache.stale, cache.tags, cache.prefixLabel()
+	if client == nil || source == nil {
+		return
+	}
+	private, header := cache.Worker().IsPrivate(), cache.Worker().Bus().Header.Clone()
+	go func() {
+		var err error
+		defer func() {
+			if perr := recover(); perr != nil {
+				err = fmt.Errorf(fmt.Sprint(perr))
+			}
+			if err != nil {
+				dt.Logger().Errorf("Failed to refresh cache, key:%s, err:%v", key, err)
+				recordAsyncWriteFailure(prefix)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), refreshLockTTL)
+		defer cancel()
+		owner := newRefreshOwner()
+		locked, err := client.SetNX(ctx, refreshLockPrefix+key, owner, refreshLockTTL).Result()
+		if err != nil || !locked {
+			return
+		}
+		defer refreshUnlockScript.Run(context.Background(), client, []string{refreshLockPrefix + key}, owner)
+
+		worker := dt.NewWorker(private, header)
+		worker.WithContext(ctx)
+		start := time.Now()
+		value, err := source(worker)
+		if err != nil || value == nil {
+			return
+		}
+		delta := time.Since(start)
+		if err = client.Set(ctx, key, wrapEntry(value, time.Now().Add(expire), delta), expire+opts.window()).Err(); err != nil {
+			return
+		}
+		if err = tagKeys(ctx, client, tags, []string{key}, expire+opts.window()); err != nil {
+			return
+		}
+		if tier != nil {
+			tier.set(key, value, expire)
+		}
+	}()
+}
+
+func newRefreshOwner() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,570 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+	metric_exporter "DT-Go/infra/rate/sentinel/exporter/metric"
+	"DT-Go/internal"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/kataras/iris/v12/core/memstore"
+	"github.com/stretchr/testify/assert"
+)
+
+// testWorker 请求级的 Worker，只提供缓存用到的方法
+type testWorker struct {
+	dt.Worker
+	ctx   context.Context
+	store memstore.Store
+	bus   dt.Bus
+}
+
+func newTestWorker() *testWorker {
+	return &testWorker{ctx: context.Background(), bus: dt.Bus{Header: http.Header{}}}
+}
+
+func (w *testWorker) Context() context.Context        { return w.ctx }
+func (w *testWorker) WithContext(ctx context.Context) { w.ctx = ctx }
+func (w *testWorker) Store() *memstore.Store          { return &w.store }
+func (w *testWorker) Bus() *dt.Bus                    { return &w.bus }
+func (w *testWorker) IsDeferRecycle() bool            { return false }
+func (w *testWorker) IsPrivate() bool                 { return false }
+func (w *testWorker) Logger() internal.Logger         { return dt.Logger() }
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	return mr, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+// newCache 每次返回新的 Worker，请求级缓存为空
+func newCache(client redis.Cmdable, prefix string) *CacheImpl {
+	cache := &CacheImpl{}
+	cache.BeginRequest(newTestWorker())
+	cache.client = client
+	cache.SetPrefix(prefix)
+	return cache
+}
+
+// source 返回计数的数据源
+func source(calls *int32, value string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		atomic.AddInt32(calls, 1)
+		if value == "" {
+			return nil, nil
+		}
+		return []byte(value), nil
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	mr, client := newRedis(t)
+	var calls int32
+
+	cache := newCache(client, "get")
+	value, err := cache.SetSource(source(&calls, "v1")).Get("get:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(value))
+	assert.Equal(t, int32(1), calls)
+	assert.True(t, mr.TTL("get:1") <= 5*time.Minute)
+
+	// 同一请求读取一级缓存，其它请求读取 redis
+	mr.Del("get:1")
+	value, _ = cache.Get("get:1")
+	assert.Equal(t, "v1", string(value))
+	mr.Set("get:1", "v2")
+	value, _ = newCache(client, "get").SetSource(source(&calls, "v1")).Get("get:1")
+	assert.Equal(t, "v2", string(value))
+	assert.Equal(t, int32(1), calls)
+
+	// 负缓存默认关闭
+	value, err = newCache(client, "get").SetSource(source(&calls, "")).Get("get:2")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	assert.False(t, mr.Exists("get:2"))
+
+	cache = newCache(client, "get").SetNegativeExpiration(time.Second).SetSource(source(&calls, "")).(*CacheImpl)
+	value, err = cache.Get("get:2")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	raw, _ := mr.Get("get:2")
+	assert.Equal(t, string(negativeValue), raw)
+	assert.Equal(t, time.Second, mr.TTL("get:2"))
+	value, _ = newCache(client, "get").SetSource(source(&calls, "v3")).Get("get:2")
+	assert.Nil(t, value)
+	assert.Equal(t, int32(3), calls)
+
+	assert.Nil(t, newCache(client, "get").Delete("get:1"))
+	assert.False(t, mr.Exists("get:1"))
+}
+
+func TestLocalCache(t *testing.T) {
+	mr, client := newRedis(t)
+	InstallLocalCache("local", LocalCacheConfig{MaxEntries: 2, TTL: time.Minute})
+	subscribeInvalidation(client)
+	var calls int32
+
+	for _, key := range []string{"local:1", "local:2"} {
+		_, err := newCache(client, "local").SetSource(source(&calls, key)).Get(key)
+		assert.Nil(t, err)
+	}
+	// redis 被删除后仍命中进程内缓存
+	mr.Del("local:1")
+	mr.Del("local:2")
+	value, _ := newCache(client, "local").SetSource(source(&calls, "reloaded")).Get("local:1")
+	assert.Equal(t, "local:1", string(value))
+	assert.Equal(t, int32(2), calls)
+
+	// 超出条目数淘汰最久未使用的 local:2
+	newCache(client, "local").SetSource(source(&calls, "local:3")).Get("local:3")
+	_, ok := localTierOf("local").get("local:2")
+	assert.False(t, ok)
+	_, ok = localTierOf("local").get("local:1")
+	assert.True(t, ok)
+
+	// 关闭进程内缓存时直接读取 redis
+	value, _ = newCache(client, "local").SetLocalCache(false).SetSource(source(&calls, "reloaded")).Get("local:1")
+	assert.Equal(t, "reloaded", string(value))
+
+	// 其它 pod 的 Delete 通过广播使本 pod 的进程内缓存失效
+	assert.Eventually(t, func() bool {
+		client.Publish(context.Background(), InvalidateChannel, "local:3")
+		_, ok := localTierOf("local").get("local:3")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// 负缓存的进程内有效期不超过负缓存时间
+	cache := newCache(client, "local").SetNegativeExpiration(20 * time.Millisecond).SetSource(source(&calls, "")).(*CacheImpl)
+	value, _ = cache.Get("local:4")
+	assert.Nil(t, value)
+	_, ok = localTierOf("local").get("local:4")
+	assert.True(t, ok)
+	time.Sleep(30 * time.Millisecond)
+	_, ok = localTierOf("local").get("local:4")
+	assert.False(t, ok)
+}
+
+func TestLocalCacheMaxBytes(t *testing.T) {
+	InstallLocalCache("bytes", LocalCacheConfig{MaxEntries: 10, MaxBytes: 8})
+	tier := localTierOf("bytes")
+	tier.set("a", []byte("1234"), 0)
+	tier.set("b", []byte("1234"), 0)
+	tier.set("c", []byte("1234"), 0)
+	_, ok := tier.get("a")
+	assert.False(t, ok)
+	_, ok = tier.get("c")
+	assert.True(t, ok)
+	tier.set("d", []byte("123456789"), 0)
+	_, ok = tier.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), tier.bytes)
+}
+
+type user struct {
+	ID   int
+	Name string
+}
+
+// userV2 与 user 同名字段但结构不同，结构版本不同
+type userV2 struct {
+	ID    int
+	Name  string
+	Email string
+}
+
+func TestTypedGet(t *testing.T) {
+	mr, client := newRedis(t)
+	var calls int32
+	load := func() (*user, error) {
+		atomic.AddInt32(&calls, 1)
+		return &user{ID: 42, Name: "dt"}, nil
+	}
+
+	for _, codec := range []Codec{JSONCodec, GobCodec, SerializerCodec, NewCodec("custom", JSONCodec.Marshal, JSONCodec.Unmarshal)} {
+		key := "typed:" + codec.Name()
+		for i := 0; i < 2; i++ {
+			result, err := Get(newCache(client, "typed").SetCodec(codec), key, load)
+			assert.Nil(t, err, codec.Name())
+			assert.Equal(t, &user{ID: 42, Name: "dt"}, result, codec.Name())
+		}
+		raw, _ := mr.Get(key)
+		assert.True(t, strings.HasPrefix(raw, "\xd7"+codec.Name()+"\x00"), codec.Name())
+	}
+	assert.Equal(t, int32(4), calls)
+
+	// 编解码器、版本或结构不一致时调用 loader 并以当前版本覆盖，之后的读取直接命中
+	for i := 0; i < 2; i++ {
+		result, err := Get(newCache(client, "typed").SetCodec(GobCodec), "typed:json", load)
+		assert.Nil(t, err)
+		assert.Equal(t, 42, result.ID)
+	}
+	raw, _ := mr.Get("typed:json")
+	assert.True(t, strings.HasPrefix(raw, "\xd7gob\x00"))
+	for i := 0; i < 2; i++ {
+		result, err := Get(newCache(client, "typed").SetVersion("v2"), "typed:json", load)
+		assert.Nil(t, err)
+		assert.Equal(t, 42, result.ID)
+	}
+	raw, _ = mr.Get("typed:json")
+	assert.True(t, strings.HasPrefix(raw, "\xd7json\x00v2"))
+	for i := 0; i < 2; i++ {
+		v2, err := Get(newCache(client, "typed"), "typed:json", func() (userV2, error) {
+			atomic.AddInt32(&calls, 1)
+			return userV2{ID: 42, Email: "dt@example.com"}, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "dt@example.com", v2.Email)
+	}
+	assert.Equal(t, int32(7), calls)
+	assert.NotEqual(t, typeVersion(reflectTypeOf[user]()), typeVersion(reflectTypeOf[userV2]()))
+
+	// ErrNotFound
+	_, err := Get(newCache(client, "typed"), "typed:missing", func() (*user, error) {
+		return nil, fmt.Errorf("find user: %w", ErrNotFound)
+	})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestBloomFilter(t *testing.T) {
+	_, client := newRedis(t)
+	for _, filter := range []*BloomFilter{NewBloomFilter(nil, "local", 1000, 0.001), NewBloomFilter(client, "redis", 1000, 0.001)} {
+		ctx := context.Background()
+		k1, k2, k3 := filter.key+":1", filter.key+":2", filter.key+":3"
+		assert.Nil(t, filter.Add(ctx, k1, k2))
+		for _, key := range []string{k1, k2} {
+			ok, err := filter.MightContain(ctx, key)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		}
+		ok, _ := filter.MightContain(ctx, k3)
+		assert.False(t, ok)
+
+		// 过滤器判定不存在的 key 不读取数据源
+		var calls int32
+		value, err := newCache(client, "bloom").SetBloomFilter(filter).SetSource(source(&calls, "v")).Get(k3)
+		assert.Nil(t, err)
+		assert.Nil(t, value)
+		value, _ = newCache(client, "bloom").SetBloomFilter(filter).SetSource(source(&calls, "v")).Get(k1)
+		assert.Equal(t, "v", string(value))
+		assert.Equal(t, int32(1), calls)
+
+		assert.Nil(t, filter.Reset(ctx))
+		ok, _ = filter.MightContain(ctx, k1)
+		assert.False(t, ok)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	mr, client := newRedis(t)
+	mr.Set("many:1", "cached")
+	var loaded [][]string
+	loader := func(missing []string) (map[string][]byte, error) {
+		loaded = append(loaded, missing)
+		result := make(map[string][]byte)
+		for _, key := range missing {
+			if key != "many:3" {
+				result[key] = []byte("loaded:" + key)
+			}
+		}
+		return result, nil
+	}
+
+	cache := newCache(client, "many").SetNegativeExpiration(time.Minute).(*CacheImpl)
+	result, err := cache.GetMany([]string{"many:1", "many:2", "many:3"}, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"many:1": []byte("cached"), "many:2": []byte("loaded:many:2")}, result)
+	assert.Len(t, loaded, 1)
+	assert.ElementsMatch(t, []string{"many:2", "many:3"}, loaded[0])
+	raw, _ := mr.Get("many:3")
+	assert.Equal(t, string(negativeValue), raw)
+	assert.Equal(t, time.Minute, mr.TTL("many:3"))
+	assert.Equal(t, 5*time.Minute, mr.TTL("many:2"))
+
+	// 全部命中缓存不调用 loader
+	result, err = newCache(client, "many").GetMany([]string{"many:1", "many:2", "many:3"}, loader)
+	assert.Nil(t, err)
+	assert.Len(t, result, 2)
+	assert.Len(t, loaded, 1)
+}
+
+func TestGetManySharesFlightWithGet(t *testing.T) {
+	_, client := newRedis(t)
+	release := make(chan struct{})
+	var batchCalls, calls int32
+	loader := func(missing []string) (map[string][]byte, error) {
+		atomic.AddInt32(&batchCalls, 1)
+		<-release
+		return map[string][]byte{"flight:1": []byte("batch")}, nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result, err := newCache(client, "flight").GetMany([]string{"flight:1"}, loader)
+		assert.Nil(t, err)
+		assert.Equal(t, "batch", string(result["flight:1"]))
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&batchCalls) == 1 }, time.Second, time.Millisecond)
+
+	// GetMany 加载中的 key，Get 等待其结果而不再次读取数据源
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		value, err := newCache(client, "flight").SetSource(source(&calls, "single")).Get("flight:1")
+		assert.Nil(t, err)
+		assert.Equal(t, "batch", string(value))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestLoadTimeout(t *testing.T) {
+	_, client := newRedis(t)
+	defer func(timeout time.Duration) { loadTimeout = timeout }(loadTimeout)
+	loadTimeout = 20 * time.Millisecond
+
+	// 加载方的数据源经由 Worker 读取时随之超时，结束后恢复 Worker 原有的 Context 并释放该 key
+	cache := newCache(client, "timeout")
+	_, err := cache.SetSource(func() ([]byte, error) {
+		<-cache.Worker().Context().Done()
+		return nil, cache.Worker().Context().Err()
+	}).Get("timeout:1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Nil(t, cache.Worker().Context().Err())
+
+	var calls int32
+	value, err := newCache(client, "timeout").SetSource(source(&calls, "v")).Get("timeout:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v", string(value))
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	mr, client := newRedis(t)
+	opts := StaleOptions{StaleWhileRevalidate: time.Minute, StaleIfError: time.Hour}
+
+	// 逻辑过期后返回旧值，后台刷新使用独立的 Worker，请求结束(Context 取消)后仍能完成，调用方的 Worker 保持不变
+	mr.Set("stale:1", string(wrapEntry([]byte("old"), time.Now().Add(-time.Second), time.Millisecond)))
+	header := http.Header{}
+	header.Set("x-request-id", "trace-1")
+	worker := dt.NewWorker(false, header)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker.WithContext(ctx)
+	cache := &CacheImpl{}
+	cache.BeginRequest(worker)
+	cache.client = client
+	refreshed := make(chan dt.Worker, 1)
+	cache.SetStale(opts).SetWorkerSource(func(refresher dt.Worker) ([]byte, error) {
+		time.Sleep(10 * time.Millisecond)
+		assert.Nil(t, refresher.Context().Err())
+		refreshed <- refresher
+		return []byte("new"), nil
+	})
+	value, err := cache.Get("stale:1")
+	cancel()
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(value))
+	refresher := <-refreshed
+	assert.NotSame(t, worker, refresher)
+	assert.Equal(t, "trace-1", refresher.Bus().Get("x-request-id"))
+	assert.False(t, worker.IsDeferRecycle())
+	assert.Equal(t, ctx, worker.Context())
+	assert.Eventually(t, func() bool {
+		raw, _ := mr.Get("stale:1")
+		value, _, _, wrapped := unwrapEntry([]byte(raw))
+		return wrapped && string(value) == "new" && !mr.Exists(refreshLockPrefix+"stale:1")
+	}, time.Second, 5*time.Millisecond)
+
+	// 其它 pod 持有刷新锁时不刷新，也不删除其它 pod 的锁
+	mr.Set("stale:2", string(wrapEntry([]byte("old"), time.Now().Add(-time.Second), time.Millisecond)))
+	mr.Set(refreshLockPrefix+"stale:2", "other-pod")
+	var calls int32
+	value, _ = newCache(client, "stale").SetStale(opts).SetWorkerSource(func(dt.Worker) ([]byte, error) {
+		return source(&calls, "new")()
+	}).Get("stale:2")
+	assert.Equal(t, "old", string(value))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+	owner, _ := mr.Get(refreshLockPrefix + "stale:2")
+	assert.Equal(t, "other-pod", owner)
+
+	// SetSource 的数据源绑定请求的 Worker，逻辑过期后同步加载
+	value, err = newCache(client, "stale").SetStale(opts).SetSource(source(&calls, "sync")).Get("stale:2")
+	assert.Nil(t, err)
+	assert.Equal(t, "sync", string(value))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 超出旧值窗口后同步加载，数据源出错时在 StaleIfError 窗口内返回旧值
+	mr.Set("stale:3", string(wrapEntry([]byte("old"), time.Now().Add(-2*time.Minute), time.Millisecond)))
+	value, err = newCache(client, "stale").SetStale(opts).SetSource(func() ([]byte, error) {
+		return nil, errors.New("db down")
+	}).Get("stale:3")
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(value))
+	value, err = newCache(client, "stale").SetStale(opts).SetSource(source(&calls, "fresh")).Get("stale:3")
+	assert.Nil(t, err)
+	assert.Equal(t, "fresh", string(value))
+
+	// 写入的值携带逻辑过期时间，物理有效期包含旧值窗口
+	newCache(client, "stale").SetStale(opts).SetSource(source(&calls, "v")).Get("stale:4")
+	raw, _ := mr.Get("stale:4")
+	_, expireAt, _, wrapped := unwrapEntry([]byte(raw))
+	assert.True(t, wrapped)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expireAt, time.Second)
+	assert.Equal(t, 5*time.Minute+time.Hour, mr.TTL("stale:4"))
+}
+
+func TestXFetch(t *testing.T) {
+	assert.False(t, shouldEarlyRefresh(time.Now().Add(time.Hour), time.Millisecond, 1))
+	assert.False(t, shouldEarlyRefresh(time.Now(), time.Second, 0))
+	assert.True(t, shouldEarlyRefresh(time.Now(), time.Second, 1))
+
+	// 越接近逻辑过期时间越可能提前刷新
+	count := func(remaining time.Duration) (n int) {
+		for i := 0; i < 1000; i++ {
+			if shouldEarlyRefresh(time.Now().Add(remaining), 100*time.Millisecond, 1) {
+				n++
+			}
+		}
+		return
+	}
+	assert.Greater(t, count(10*time.Millisecond), count(300*time.Millisecond))
+}
+
+type userRenamed struct {
+	prototypes map[string]interface{}
+	identity   interface{}
+	tags       []string
+}
+
+func (e *userRenamed) Topic() string                          { return "user.renamed" }
+func (e *userRenamed) SetPrototypes(m map[string]interface{}) { e.prototypes = m }
+func (e *userRenamed) GetPrototypes() map[string]interface{}  { return e.prototypes }
+func (e *userRenamed) Marshal() []byte                        { return []byte(`{}`) }
+func (e *userRenamed) Identity() interface{}                  { return e.identity }
+func (e *userRenamed) SetIdentity(identity interface{})       { e.identity = identity }
+func (e *userRenamed) CacheTags() []string                    { return e.tags }
+
+func TestInvalidateTag(t *testing.T) {
+	mr, client := newRedis(t)
+	InstallLocalCache("tag", LocalCacheConfig{})
+	var calls int32
+	for _, key := range []string{"tag:1", "tag:2"} {
+		_, err := newCache(client, "tag").Tags("user:42").SetSource(source(&calls, key)).Get(key)
+		assert.Nil(t, err)
+	}
+	newCache(client, "tag").Tags("user:7").SetSource(source(&calls, "tag:3")).Get("tag:3")
+	members, _ := mr.ZMembers(tagRedisPrefix + "user:42")
+	assert.ElementsMatch(t, []string{"tag:1", "tag:2"}, members)
+	assert.True(t, mr.TTL(tagRedisPrefix+"user:42") > 0)
+
+	assert.Nil(t, newCache(client, "tag").InvalidateTag("user:42"))
+	assert.False(t, mr.Exists("tag:1"))
+	assert.False(t, mr.Exists("tag:2"))
+	assert.False(t, mr.Exists(tagRedisPrefix+"user:42"))
+	assert.True(t, mr.Exists("tag:3"))
+	_, ok := localTierOf("tag").get("tag:1")
+	assert.False(t, ok)
+
+	// 已过期的成员不删除
+	mr.ZAdd(tagRedisPrefix+"user:7", float64(time.Now().Add(-time.Minute).UnixMilli()), "tag:expired")
+	mr.Set("tag:expired", "reused")
+	InvalidateEventTags(dt.NewWorker(false, nil), client, []dt.DomainEvent{&userRenamed{tags: []string{"user:7"}}}, nil)
+	assert.False(t, mr.Exists("tag:3"))
+	assert.True(t, mr.Exists("tag:expired"))
+
+	// 成员删除失败时保留标签，重试仍能失效
+	newCache(client, "tag").Tags("user:9").SetSource(source(&calls, "tag:4")).Get("tag:4")
+	failing := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	failing.AddHook(failPipelineHook{})
+	_, err := invalidateTags(context.Background(), failing, "user:9")
+	assert.NotNil(t, err)
+	assert.True(t, mr.Exists("tag:4"))
+	members, _ = mr.ZMembers(tagRedisPrefix + "user:9")
+	assert.Equal(t, []string{"tag:4"}, members)
+	assert.Nil(t, newCache(client, "tag").InvalidateTag("user:9"))
+	assert.False(t, mr.Exists("tag:4"))
+	assert.False(t, mr.Exists(tagRedisPrefix+"user:9"))
+}
+
+// failPipelineHook pipeline 执行失败
+type failPipelineHook struct{}
+
+func (failPipelineHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (failPipelineHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (failPipelineHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, errors.New("connection reset")
+}
+
+func (failPipelineHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestMetrics(t *testing.T) {
+	mr, client := newRedis(t)
+	// 指标与热点 key 为进程级统计，使用独立的前缀
+	prefix := fmt.Sprintf("metrics-%d", time.Now().UnixNano())
+	var calls int32
+	mr.Set("metrics:1", "v")
+	for i := 0; i < 3; i++ {
+		newCache(client, prefix).SetSource(source(&calls, "v")).Get("metrics:1")
+	}
+	newCache(client, prefix).SetSource(source(&calls, "v")).Get("metrics:2")
+
+	hot := HotKeys(prefix, 1)
+	assert.Equal(t, []HotKey{{Prefix: prefix, Key: "metrics:1", Count: 3}}, hot)
+
+	rec := httptest.NewRecorder()
+	HotKeysHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/cache/hotkeys?limit=5&prefix="+prefix, nil))
+	assert.Contains(t, rec.Body.String(), `"key":"metrics:2","count":1`)
+
+	metrics := scrape(t)
+	assert.Regexp(t, `cache_hits_total\{[^}]*prefix="`+prefix+`",tier="redis"\} 3\n`, metrics)
+	assert.Regexp(t, `cache_misses_total\{[^}]*prefix="`+prefix+`"\} 1\n`, metrics)
+	assert.Regexp(t, `cache_load_seconds_count\{[^}]*prefix="`+prefix+`"\} 1\n`, metrics)
+}
+
+func TestSpaceSaving(t *testing.T) {
+	hot := newSpaceSaving(2)
+	for i := 0; i < 5; i++ {
+		hot.add(hotKey{prefix: "p", key: "hot"})
+	}
+	// 冷 key 只替换计数最小的位置，不挤出访问频繁的 key
+	for i := 0; i < 3; i++ {
+		hot.add(hotKey{prefix: "p", key: fmt.Sprintf("cold:%d", i)})
+	}
+	result := hot.snapshot("p")
+	sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	assert.Len(t, result, 2)
+	assert.Equal(t, HotKey{Prefix: "p", Key: "hot", Count: 5}, result[0])
+	assert.Equal(t, HotKey{Prefix: "p", Key: "cold:2", Count: 3, Error: 2}, result[1])
+	assert.Empty(t, hot.snapshot("other"))
+}
+
+func reflectTypeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// scrape 读取 prometheus 导出的指标
+func scrape(t *testing.T) string {
+	rec := httptest.NewRecorder()
+	metric_exporter.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
@@ -0,0 +1,164 @@
+package store
+
+/*
+	标签失效
+	写缓存时通过 Cache.Tags 关联标签，标签以 redis 有序集合保存成员 key，分值为成员的过期时间
+	InvalidateTag 读取标签的成员，逐个删除成员 key(兼容集群模式)并广播进程内缓存失效，删除成功后才从标签中移除成员，失败时可重试
+	领域事件提交后失效标签：在 domainevent.RegisterCommitHook 的钩子中调用 InvalidateEventTags
+	写入标签时清理已过期的成员，标签自身在最晚过期的成员过期时删除
+
+	Created by Dustin.zhu on 2023/09/12.
+*/
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// tagRedisPrefix 标签 key 前缀
+const tagRedisPrefix = "dt:cache:tag:"
+
+var (
+	// tagScript KEYS[1] 标签 ARGV[1] 当前时间 ARGV[2] 成员过期时间 ARGV[3:] 成员
+	tagScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for i = 3, #ARGV do
+	local score = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if not score or tonumber(score) < tonumber(ARGV[2]) then
+		redis.call('ZADD', KEYS[1], ARGV[2], ARGV[i])
+	end
+end
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if last[2] then
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+end
+return 1`)
+
+	// untagScript KEYS[1] 标签 ARGV[1] 当前时间 ARGV[2:] 成员与读取时的分值
+	// 只移除分值未变的成员，删除期间重新写入的成员保留在标签中
+	untagScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for i = 2, #ARGV, 2 do
+	local score = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if score and tonumber(score) <= tonumber(ARGV[i + 1]) then
+		redis.call('ZREM', KEYS[1], ARGV[i])
+	end
+end
+return 1`)
+)
+
+// CacheTagged 领域事件实现该接口声明提交后需要失效的缓存标签
+type CacheTagged interface {
+	CacheTags() []string
+}
+
+// Tags 设置本次写入缓存关联的标签
+func (cache *CacheImpl) Tags(tags ...string) Cache {
+	cache.tags = tags
+	return cache
+}
+
+// InvalidateTag 删除标签下的全部缓存
+func (cache *CacheImpl) InvalidateTag(tags ...string) error {
+	if cache.client == nil {
+		return nil
+	}
+	keys, err := invalidateTags(cache.Worker().Context(), cache.client, tags...)
+	if !cache.Worker().IsDeferRecycle() {
+		for _, key := range keys {
+			cache.Worker().Store().Remove(key)
+		}
+	}
+	return err
+}
+
+// invalidateTags 先删除标签的成员，成功后再从标签中移除，集群模式下 pipeline 按 slot 分发
+func invalidateTags(ctx context.Context, client redis.Cmdable, tags ...string) (keys []string, err error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for _, tag := range tags {
+		var members []redis.Z
+		if members, err = client.ZRangeByScoreWithScores(ctx, tagRedisPrefix+tag, &redis.ZRangeBy{Min: now, Max: "+inf"}).Result(); err != nil {
+			return
+		}
+		if len(members) == 0 {
+			continue
+		}
+		pipe := client.Pipeline()
+		deleted := make([]string, 0, len(members))
+		args := make([]interface{}, 0, 2*len(members)+1)
+		args = append(args, now)
+		for _, z := range members {
+			member := z.Member.(string)
+			evictLocal(member)
+			pipe.Del(ctx, member)
+			pipe.Publish(ctx, InvalidateChannel, member)
+			deleted = append(deleted, member)
+			args = append(args, member, strconv.FormatFloat(z.Score, 'f', -1, 64))
+		}
+		if _, err = pipe.Exec(ctx); err != nil {
+			return
+		}
+		keys = append(keys, deleted...)
+		if err = untagScript.Run(ctx, client, []string{tagRedisPrefix + tag}, args...).Err(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// tagKeys 将写入的 key 关联到标签
+func tagKeys(ctx context.Context, client redis.Cmdable, tags []string, keys []string, expire time.Duration) error {
+	if len(tags) == 0 || len(keys) == 0 {
+		return nil
+	}
+	now := time.Now()
+	args := make([]interface{}, 0, len(keys)+2)
+	args = append(args, now.UnixMilli(), now.Add(expire).UnixMilli())
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	for _, tag := range tags {
+		if err := tagScript.Run(ctx, client, []string{tagRedisPrefix + tag}, args...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateEventTags 失效领域事件声明的标签，tagsOf 为 nil 时使用事件实现的 CacheTagged
+// 在领域事件的事务提交钩子中调用
+//
+//	domainevent.RegisterCommitHook(func(tx *domainevent.EventTransaction, events []dt.DomainEvent) {
+//		store.InvalidateEventTags(tx.Worker(), tx.Redis(), events, nil)
+//	})
+func InvalidateEventTags(worker dt.Worker, client redis.Cmdable, events []dt.DomainEvent, tagsOf func(event dt.DomainEvent) []string) {
+	if client == nil {
+		return
+	}
+	var tags []string
+	for _, event := range events {
+		if tagsOf != nil {
+			tags = append(tags, tagsOf(event)...)
+		} else if tagged, ok := event.(CacheTagged); ok {
+			tags = append(tags, tagged.CacheTags()...)
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+	keys, err := invalidateTags(worker.Context(), client, tags...)
+	if err != nil {
+		worker.Logger().Errorf("Failed to invalidate cache tags, tags:%v, err:%v", tags, err)
+		return
+	}
+	if !worker.IsDeferRecycle() {
+		for _, key := range keys {
+			worker.Store().Remove(key)
+		}
+	}
+}
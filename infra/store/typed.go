@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrNotFound loader 返回该错误表示数据不存在，开启负缓存时不存在的结果按负缓存时间缓存，Get 返回零值与该错误
+var ErrNotFound = errors.New("cache: not found")
+
+// Get 读取类型化缓存，未命中时调用 loader 并以 cache 的编解码器写回
+// 缓存值头部记录编解码器、SetVersion 版本与 T 的结构版本，任一不一致时视为未命中，
+// 经由单飞调用 loader 并以当前版本覆盖缓存值，之后的读取直接命中
+//
+//	user, err := store.Get(cache.SetPrefix("user"), "user:42", func() (*User, error) {
+//		return repo.Find(42)
+//	})
+func Get[T any](cache Cache, key string, loader func() (T, error), expiration ...time.Duration) (result T, err error) {
+	codec := cache.Codec()
+	version := cache.Version() + typeVersion(reflect.TypeOf(&result).Elem())
+	cache.SetSource(func() ([]byte, error) {
+		value, err := loader()
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encodeValue(codec, version, value)
+	})
+
+	data, err := cache.Get(key, expiration...)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		return result, ErrNotFound
+	}
+	stale, err := decodeValue(codec, version, data, &result)
+	if !stale {
+		return
+	}
+	// 其它版本写入的缓存值 视为未命中
+	var zero T
+	impl, ok := cache.(*CacheImpl)
+	if !ok {
+		if result, err = loader(); errors.Is(err, ErrNotFound) {
+			return zero, ErrNotFound
+		}
+		return
+	}
+	if data, err = impl.reload(key, expiration...); err != nil {
+		return zero, err
+	}
+	if data == nil {
+		return zero, ErrNotFound
+	}
+	if _, err = decodeValue(codec, version, data, &result); err != nil {
+		return zero, err
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package taskqueue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+任务队列入队组件
+	1.多例组件，入队时保存当前请求 Bus 的 header(含 user_id、client_id 等身份)，不保存 bearer_token、Authorization、Cookie 等凭证
+	2.payload 为 []byte 时原样保存，其它类型使用安装的序列化器
+
+	id, err := repo.TaskQueue.Enqueue("email:welcome", WelcomeEmail{UserID: 1}, taskqueue.Priority(8), taskqueue.MaxRetry(3))
+
+Created by Dustin.zhu on 2023/09/27.
+*/
+
+func init() {
+	dt.Prepare(func(initiator dt.Initiator) {
+		initiator.BindInfra(false, initiator.IsPrivate(), func() *TaskQueueImpl {
+			return &TaskQueueImpl{}
+		})
+	})
+}
+
+// credentialHeaders 入队时不保存的凭证 header
+var credentialHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "bearer_token",
+}
+
+// StripHeaders 追加入队时不保存的凭证 header，如自定义的 token header，在 dt.Prepare 阶段调用
+func StripHeaders(names ...string) {
+	credentialHeaders = append(credentialHeaders, names...)
+}
+
+// TaskQueue .
+type TaskQueue interface {
+	// Enqueue 入队，返回任务ID
+	Enqueue(taskType string, payload interface{}, opts ...EnqueueOption) (id string, err error)
+}
+
+var _ TaskQueue = (*TaskQueueImpl)(nil)
+
+// TaskQueueImpl .
+type TaskQueueImpl struct {
+	dt.Infra
+	client redis.Cmdable
+	header http.Header
+}
+
+// BeginRequest .
+func (q *TaskQueueImpl) BeginRequest(worker dt.Worker) {
+	q.client = q.Redis()
+	q.header = propagated(worker.Bus().Header)
+	q.Infra.BeginRequest(worker)
+}
+
+// Enqueue .
+func (q *TaskQueueImpl) Enqueue(taskType string, payload interface{}, opts ...EnqueueOption) (string, error) {
+	data, err := q.encode(payload)
+	if err != nil {
+		return "", err
+	}
+	return enqueue(context.Background(), q.client, q.header, taskType, data, opts...)
+}
+
+// propagated 去除 credentialHeaders，任务保存在 redis 中，不能包含凭证
+func propagated(header http.Header) http.Header {
+	result := header.Clone()
+	if result == nil {
+		result = make(http.Header)
+	}
+	for _, name := range credentialHeaders {
+		result.Del(name)
+		// 直接写入 map 的 key 未规范化
+		delete(result, name)
+	}
+	return result
+}
+
+func (q *TaskQueueImpl) encode(payload interface{}) ([]byte, error) {
+	if data, ok := payload.([]byte); ok {
+		return data, nil
+	}
+	return q.Marshal(payload)
+}
+
+// enqueue .
+func enqueue(ctx context.Context, client redis.Cmdable, header http.Header, taskType string, payload []byte, opts ...EnqueueOption) (string, error) {
+	o := newEnqueueOptions(opts)
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	var deadline, processAt int64
+	if !o.deadline.IsZero() {
+		deadline = o.deadline.UnixMilli()
+	}
+	if o.processAt.After(time.Now()) {
+		processAt = o.processAt.UnixMilli()
+	}
+
+	id := newTaskID()
+	ok, err := enqueueScript.Run(ctx, client,
+		[]string{taskKey(o.queue, id), pendingKey(o.queue), scheduledKey(o.queue), uniqueKey(o.queue, taskType, payload)},
+		id, taskType, payload, headers, o.priority, o.maxRetry, deadline, o.timeout.Milliseconds(),
+		o.uniqueTTL.Milliseconds(), pendingScore(o.priority, time.Now()), processAt).Bool()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrDuplicateTask
+	}
+	return id, nil
+}
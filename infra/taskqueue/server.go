@@ -0,0 +1,398 @@
+package taskqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dt "DT-Go"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+任务队列消费组件
+	1.单例组件，Handle 注册任务类型的处理函数，SetQueue 设置消费的队列与并发数
+	2.每个队列一个有界的协程池，每个任务使用独立的 Worker，Bus 由入队时的 header 重建
+	3.失败的任务按指数退避重试，返回 ErrSkipRetry、重试耗尽或超过截止时间时进入 dead
+	4.服务关闭时停止取任务，等待执行中的任务结束，超时后取消其 ctx
+
+	taskqueue.Handle("email:welcome", func(worker dt.Worker, payload WelcomeEmail) error {
+		return sendWelcome(worker.Context(), payload.UserID)
+	})
+	taskqueue.GetServer().SetQueue("email", 20)
+
+Created by Dustin.zhu on 2023/09/27.
+*/
+
+const (
+	// DefaultConcurrency 队列的默认并发数
+	DefaultConcurrency = 10
+	// DefaultLease 执行中任务的租约有效期，执行期间每 1/3 有效期续期一次
+	DefaultLease = 60 * time.Second
+	// DefaultStopTimeout 服务关闭时等待执行中任务的时间
+	DefaultStopTimeout = 30 * time.Second
+	// MaxRetryDelay 默认退避的最大重试间隔
+	MaxRetryDelay = 10 * time.Minute
+
+	minPollInterval  = 50 * time.Millisecond
+	maxPollInterval  = time.Second
+	maintainInterval = time.Second
+)
+
+var server *ServerImpl
+
+func init() {
+	server = newServer()
+	dt.Prepare(func(initiator dt.Initiator) {
+		server.private = initiator.IsPrivate()
+		// 单例
+		initiator.BindInfra(true, initiator.IsPrivate(), server)
+	})
+}
+
+// GetServer .
+func GetServer() *ServerImpl {
+	return server
+}
+
+// HandlerFunc 处理函数
+type HandlerFunc func(worker dt.Worker, payload []byte) error
+
+// Handle 注册任务类型的处理函数，payload 按入队时的类型反序列化，反序列化失败时不重试
+func Handle[T any](taskType string, handler func(worker dt.Worker, payload T) error) {
+	server.HandleFunc(taskType, func(worker dt.Worker, data []byte) error {
+		var payload T
+		if raw, ok := any(&payload).(*[]byte); ok {
+			*raw = data
+		} else if err := server.Unmarshal(data, &payload); err != nil {
+			return fmt.Errorf("%w: %v", ErrSkipRetry, err)
+		}
+		return handler(worker, payload)
+	})
+}
+
+// Server .
+type Server interface {
+	// HandleFunc 注册任务类型的处理函数
+	HandleFunc(taskType string, handler HandlerFunc)
+	// SetQueue 消费队列 name，并发数为 concurrency，默认仅消费 DefaultQueue
+	SetQueue(name string, concurrency int) Server
+	// SetRetryDelay 第 retried 次重试前的等待时间，默认指数退避
+	SetRetryDelay(f func(retried int, err error) time.Duration) Server
+	// Stats 队列统计
+	Stats(queue string) (Stats, error)
+	// Stop 停止取任务，等待执行中的任务至多 timeout 后取消其 ctx
+	Stop(timeout time.Duration)
+}
+
+var _ Server = (*ServerImpl)(nil)
+
+// ServerImpl .
+type ServerImpl struct {
+	dt.Infra
+	client     redis.Cmdable
+	private    bool
+	lease      time.Duration
+	retryDelay func(retried int, err error) time.Duration
+	mu         sync.RWMutex
+	handlers   map[string]HandlerFunc
+	queues     map[string]*queueState
+	started    bool
+	ctx        context.Context // 结束时停止取任务
+	cancel     context.CancelFunc
+	runCtx     context.Context // 执行中任务的 ctx，停止超时后取消
+	abort      context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+type queueState struct {
+	concurrency int
+	busy        int64
+}
+
+func newServer() *ServerImpl {
+	s := &ServerImpl{
+		lease:      DefaultLease,
+		retryDelay: defaultRetryDelay,
+		handlers:   make(map[string]HandlerFunc),
+		queues:     map[string]*queueState{DefaultQueue: {concurrency: DefaultConcurrency}},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.runCtx, s.abort = context.WithCancel(context.Background())
+	return s
+}
+
+// Booting .
+func (s *ServerImpl) Booting(singleBoot dt.SingleBoot) {
+	if s.client == nil {
+		s.client = s.Redis()
+	}
+	s.start()
+	singleBoot.RegisterShutdown(func() {
+		s.Stop(DefaultStopTimeout)
+	})
+}
+
+// HandleFunc .
+func (s *ServerImpl) HandleFunc(taskType string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[taskType] = handler
+}
+
+// SetQueue .
+func (s *ServerImpl) SetQueue(name string, concurrency int) Server {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[name]; ok {
+		if !s.started {
+			q.concurrency = concurrency
+		}
+		return s
+	}
+	q := &queueState{concurrency: concurrency}
+	s.queues[name] = q
+	if s.started {
+		s.startQueue(name, q)
+	}
+	return s
+}
+
+// SetRetryDelay .
+func (s *ServerImpl) SetRetryDelay(f func(retried int, err error) time.Duration) Server {
+	s.retryDelay = f
+	return s
+}
+
+// Stop .
+func (s *ServerImpl) Stop(timeout time.Duration) {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		dt.Logger().Warnf("taskqueue: tasks are still running after %v, cancel them", timeout)
+		s.abort()
+	}
+}
+
+func (s *ServerImpl) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for name, q := range s.queues {
+		s.startQueue(name, q)
+	}
+}
+
+// startQueue 启动队列的协程池与维护协程
+func (s *ServerImpl) startQueue(name string, q *queueState) {
+	s.wg.Add(q.concurrency + 1)
+	for i := 0; i < q.concurrency; i++ {
+		go s.work(name, q)
+	}
+	go s.maintain(name)
+}
+
+func (s *ServerImpl) work(queue string, q *queueState) {
+	defer s.wg.Done()
+	idle := minPollInterval
+	for s.ctx.Err() == nil {
+		task, header, err := s.dequeue(queue)
+		if err != nil && err != redis.Nil {
+			dt.Logger().Errorf("taskqueue: dequeue %s failed, err: %v", queue, err)
+		}
+		if task == nil {
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(idle):
+			}
+			if idle *= 2; idle > maxPollInterval {
+				idle = maxPollInterval
+			}
+			continue
+		}
+		idle = minPollInterval
+		atomic.AddInt64(&q.busy, 1)
+		s.process(task, header)
+		atomic.AddInt64(&q.busy, -1)
+	}
+}
+
+// maintain 定时转移到期的延迟任务、回收租约到期的任务并更新指标
+func (s *ServerImpl) maintain(queue string) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reclaimed, err := maintainScript.Run(context.Background(), s.client,
+			[]string{pendingKey(queue), scheduledKey(queue), activeKey(queue), deadKey(queue)},
+			time.Now().UnixMilli(), keyPrefix(queue)+"t:", DeadRetention.Milliseconds(), priorityWeight, MaxPriority).Int()
+		if err != nil {
+			dt.Logger().Errorf("taskqueue: maintain %s failed, err: %v", queue, err)
+			continue
+		}
+		if reclaimed > 0 {
+			dt.Logger().Warnf("taskqueue: %d tasks of %s reclaimed after lease expired", reclaimed, queue)
+		}
+		s.updateSizeGauge(queue)
+	}
+}
+
+func (s *ServerImpl) dequeue(queue string) (*Task, http.Header, error) {
+	id, err := dequeueScript.Run(context.Background(), s.client, []string{pendingKey(queue), activeKey(queue)},
+		time.Now().Add(s.lease).UnixMilli()).Text()
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := s.client.HGetAll(context.Background(), taskKey(queue, id)).Result()
+	if err != nil || len(fields) == 0 {
+		s.client.ZRem(context.Background(), activeKey(queue), id)
+		return nil, nil, err
+	}
+
+	task := &Task{ID: id, Queue: queue, Type: fields["type"], Payload: []byte(fields["payload"])}
+	task.Priority, _ = strconv.Atoi(fields["priority"])
+	task.Retried, _ = strconv.Atoi(fields["retried"])
+	task.MaxRetry, _ = strconv.Atoi(fields["max_retry"])
+	if ms, _ := strconv.ParseInt(fields["deadline"], 10, 64); ms > 0 {
+		task.Deadline = time.UnixMilli(ms)
+	}
+	if ms, _ := strconv.ParseInt(fields["timeout"], 10, 64); ms > 0 {
+		task.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	var header http.Header
+	json.Unmarshal([]byte(fields["headers"]), &header)
+	return task, header, nil
+}
+
+// process 执行任务并按结果完成、重试或进入 dead
+func (s *ServerImpl) process(task *Task, header http.Header) {
+	if !task.Deadline.IsZero() && time.Now().After(task.Deadline) {
+		s.kill(task, ErrDeadlineExceeded)
+		return
+	}
+	s.mu.RLock()
+	handler, ok := s.handlers[task.Type]
+	s.mu.RUnlock()
+	if !ok {
+		// 滚动发布期间其它实例可能已注册处理函数
+		s.fail(task, fmt.Errorf("taskqueue: no handler for %s", task.Type))
+		return
+	}
+
+	keepCtx, stopKeep := context.WithCancel(context.Background())
+	go s.keepLease(keepCtx, task)
+	start := time.Now()
+	err := s.execute(task, header, handler)
+	stopKeep()
+	durationHistogram.Observe(time.Since(start).Seconds(), task.Queue, task.Type)
+
+	if err == nil {
+		if _, err := doneScript.Run(context.Background(), s.client,
+			[]string{activeKey(task.Queue), taskKey(task.Queue, task.ID), statsKey(task.Queue)}, task.ID).Result(); err != nil {
+			dt.Logger().Errorf("taskqueue: complete task %s failed, err: %v", task.ID, err)
+		}
+		processedCounter.Add(1, task.Queue, task.Type, resultSuccess)
+		return
+	}
+	s.fail(task, err)
+}
+
+func (s *ServerImpl) execute(task *Task, header http.Header, handler HandlerFunc) (err error) {
+	ctx, cancel := s.runCtx, context.CancelFunc(func() {})
+	if task.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, task.Timeout)
+	}
+	defer cancel()
+	if !task.Deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, task.Deadline)
+		defer cancelDeadline()
+	}
+	worker := dt.NewWorker(s.private, header)
+	worker.WithContext(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(worker, task.Payload)
+}
+
+// fail 重试或进入 dead
+func (s *ServerImpl) fail(task *Task, err error) {
+	dt.Logger().Warnf("taskqueue: task %s(%s) failed, retried: %d, err: %v", task.Type, task.ID, task.Retried, err)
+	if errors.Is(err, ErrSkipRetry) || task.Retried >= task.MaxRetry {
+		s.kill(task, err)
+		return
+	}
+	retryAt := time.Now().Add(s.retryDelay(task.Retried+1, err))
+	if !task.Deadline.IsZero() && retryAt.After(task.Deadline) {
+		s.kill(task, err)
+		return
+	}
+	if _, err := retryScript.Run(context.Background(), s.client,
+		[]string{activeKey(task.Queue), taskKey(task.Queue, task.ID), scheduledKey(task.Queue), statsKey(task.Queue)},
+		task.ID, retryAt.UnixMilli(), err.Error()).Result(); err != nil {
+		dt.Logger().Errorf("taskqueue: retry task %s failed, err: %v", task.ID, err)
+	}
+	processedCounter.Add(1, task.Queue, task.Type, resultRetry)
+}
+
+func (s *ServerImpl) kill(task *Task, err error) {
+	if _, err := killScript.Run(context.Background(), s.client,
+		[]string{activeKey(task.Queue), taskKey(task.Queue, task.ID), deadKey(task.Queue), statsKey(task.Queue)},
+		task.ID, time.Now().UnixMilli(), DeadRetention.Milliseconds(), err.Error()).Result(); err != nil {
+		dt.Logger().Errorf("taskqueue: kill task %s failed, err: %v", task.ID, err)
+	}
+	processedCounter.Add(1, task.Queue, task.Type, resultDead)
+}
+
+func (s *ServerImpl) keepLease(ctx context.Context, task *Task) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			extendScript.Run(ctx, s.client, []string{activeKey(task.Queue)}, task.ID, time.Now().Add(s.lease).UnixMilli())
+		}
+	}
+}
+
+// defaultRetryDelay 指数退避，附加至多 20% 的随机抖动
+func defaultRetryDelay(retried int, err error) time.Duration {
+	delay := MaxRetryDelay
+	if retried < 20 {
+		if d := time.Duration(1<<uint(retried)) * time.Second; d < delay {
+			delay = d
+		}
+	}
+	return delay + time.Duration(mrand.Int63n(int64(delay)/5+1))
+}
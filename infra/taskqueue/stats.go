@@ -0,0 +1,133 @@
+package taskqueue
+
+/*
+	任务队列统计
+	Stats 返回各状态的任务数与累计处理数，StatsHandler 以 json 返回本实例消费的全部队列
+	指标通过 sentinel 的 prometheus exporter 导出
+
+	Created by Dustin.zhu on 2023/09/27.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	metric_exporter "DT-Go/infra/rate/sentinel/exporter/metric"
+)
+
+const (
+	resultSuccess = "success"
+	resultRetry   = "retry"
+	resultDead    = "dead"
+)
+
+var (
+	processedCounter = metric_exporter.NewCounter(
+		"task_processed_total",
+		"Total processed tasks",
+		[]string{"queue", "type", "result"})
+	durationHistogram = metric_exporter.NewHistogram(
+		"task_duration_seconds",
+		"Task handler latency",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 30, 120},
+		[]string{"queue", "type"})
+	sizeGauge = metric_exporter.NewGauge(
+		"task_queue_size",
+		"Tasks in queue by state",
+		[]string{"queue", "state"})
+)
+
+func init() {
+	metric_exporter.Register(processedCounter)
+	metric_exporter.Register(durationHistogram)
+	metric_exporter.Register(sizeGauge)
+}
+
+// Stats 队列统计
+type Stats struct {
+	Queue       string `json:"queue"`
+	Pending     int64  `json:"pending"`
+	Scheduled   int64  `json:"scheduled"` // 延迟与等待重试
+	Active      int64  `json:"active"`
+	Dead        int64  `json:"dead"`
+	Processed   int64  `json:"processed"` // 累计成功数
+	Failed      int64  `json:"failed"`    // 累计失败数，含重试
+	Retried     int64  `json:"retried"`   // 累计重试数
+	Busy        int64  `json:"busy"`      // 本实例执行中的任务数
+	Concurrency int    `json:"concurrency"`
+}
+
+// Stats .
+func (s *ServerImpl) Stats(queue string) (Stats, error) {
+	stats := Stats{Queue: queue}
+	ctx := context.Background()
+	pipe := s.client.Pipeline()
+	pending := pipe.ZCard(ctx, pendingKey(queue))
+	scheduled := pipe.ZCard(ctx, scheduledKey(queue))
+	active := pipe.ZCard(ctx, activeKey(queue))
+	dead := pipe.ZCard(ctx, deadKey(queue))
+	counters := pipe.HGetAll(ctx, statsKey(queue))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return stats, err
+	}
+	stats.Pending, stats.Scheduled, stats.Active, stats.Dead = pending.Val(), scheduled.Val(), active.Val(), dead.Val()
+	stats.Processed, _ = strconv.ParseInt(counters.Val()["processed"], 10, 64)
+	stats.Failed, _ = strconv.ParseInt(counters.Val()["failed"], 10, 64)
+	stats.Retried, _ = strconv.ParseInt(counters.Val()["retried"], 10, 64)
+
+	s.mu.RLock()
+	if q, ok := s.queues[queue]; ok {
+		stats.Busy = atomic.LoadInt64(&q.busy)
+		stats.Concurrency = q.concurrency
+	}
+	s.mu.RUnlock()
+	return stats, nil
+}
+
+// StatsHandler 返回本实例消费的队列统计，?queue= 指定队列
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queues := []string{r.URL.Query().Get("queue")}
+		if queues[0] == "" {
+			queues = server.queueNames()
+		}
+		result := make([]Stats, 0, len(queues))
+		for _, queue := range queues {
+			stats, err := server.Stats(queue)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result = append(result, stats)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+func (s *ServerImpl) queueNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.queues))
+	for name := range s.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateSizeGauge .
+func (s *ServerImpl) updateSizeGauge(queue string) {
+	stats, err := s.Stats(queue)
+	if err != nil {
+		return
+	}
+	sizeGauge.Set(float64(stats.Pending), queue, "pending")
+	sizeGauge.Set(float64(stats.Scheduled), queue, "scheduled")
+	sizeGauge.Set(float64(stats.Active), queue, "active")
+	sizeGauge.Set(float64(stats.Dead), queue, "dead")
+}
@@ -0,0 +1,305 @@
+package taskqueue
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/**
+redis 任务队列
+	1.任务以 hash 保存，按队列分为 pending(按优先级与入队时间排序)、scheduled(延迟与重试)、active(执行中)与 dead(重试耗尽)
+	2.同一队列的 key 使用相同的 hash tag，集群模式下位于同一 slot
+	3.执行中的任务持有租约并续期，进程崩溃后租约到期的任务重新入队并计入重试次数
+	4.入队时保存 Bus 的 header，执行时以此重建 Worker 的 Bus
+
+Created by Dustin.zhu on 2023/09/27.
+*/
+
+const (
+	// DefaultQueue 默认队列
+	DefaultQueue = "default"
+	// DefaultPriority 默认优先级，取值 0-9，越大越先执行
+	DefaultPriority = 5
+	// MaxPriority 最大优先级
+	MaxPriority = 9
+	// DefaultMaxRetry 默认最大重试次数
+	DefaultMaxRetry = 5
+	// DefaultTimeout 单次执行的默认超时
+	DefaultTimeout = 30 * time.Minute
+	// DeadRetention 重试耗尽的任务保留时间
+	DeadRetention = 7 * 24 * time.Hour
+
+	// priorityWeight 优先级在 pending 分值中的权重，大于毫秒时间戳
+	priorityWeight = 1e13
+)
+
+var (
+	// ErrDuplicateTask 唯一任务已在队列中
+	ErrDuplicateTask = errors.New("taskqueue: duplicate task")
+	// ErrSkipRetry 处理函数返回包装了该错误的错误时不再重试
+	ErrSkipRetry = errors.New("taskqueue: skip retry")
+	// ErrDeadlineExceeded 任务超过截止时间未执行
+	ErrDeadlineExceeded = errors.New("taskqueue: task deadline exceeded")
+)
+
+// Task 任务
+type Task struct {
+	ID       string
+	Queue    string
+	Type     string
+	Payload  []byte
+	Priority int
+	Retried  int       // 已重试次数
+	MaxRetry int       // 最大重试次数
+	Deadline time.Time // 截止时间，零值表示不限
+	Timeout  time.Duration
+}
+
+// EnqueueOption .
+type EnqueueOption func(*enqueueOptions)
+
+type enqueueOptions struct {
+	queue     string
+	priority  int
+	maxRetry  int
+	processAt time.Time
+	deadline  time.Time
+	timeout   time.Duration
+	uniqueTTL time.Duration
+}
+
+func newEnqueueOptions(opts []EnqueueOption) *enqueueOptions {
+	o := &enqueueOptions{queue: DefaultQueue, priority: DefaultPriority, maxRetry: DefaultMaxRetry, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.priority < 0 {
+		o.priority = 0
+	}
+	if o.priority > MaxPriority {
+		o.priority = MaxPriority
+	}
+	return o
+}
+
+// Queue 入队的队列，默认 DefaultQueue
+func Queue(name string) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.queue = name
+	}
+}
+
+// Priority 优先级 0-9，默认 DefaultPriority
+func Priority(priority int) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.priority = priority
+	}
+}
+
+// MaxRetry 最大重试次数，默认 DefaultMaxRetry
+func MaxRetry(n int) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.maxRetry = n
+	}
+}
+
+// ProcessIn 延迟 d 后执行
+func ProcessIn(d time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.processAt = time.Now().Add(d)
+	}
+}
+
+// ProcessAt 在 t 时执行
+func ProcessAt(t time.Time) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.processAt = t
+	}
+}
+
+// Deadline 截止时间，超过后不再执行与重试，执行中的任务取消 Worker.Context()
+func Deadline(t time.Time) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.deadline = t
+	}
+}
+
+// Timeout 单次执行超时，默认 DefaultTimeout
+func Timeout(d time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.timeout = d
+	}
+}
+
+// Unique 相同类型与内容的任务在 ttl 内或完成前只入队一次，重复入队返回 ErrDuplicateTask
+func Unique(ttl time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.uniqueTTL = ttl
+	}
+}
+
+var (
+	// enqueueScript KEYS[1] 任务 KEYS[2] pending KEYS[3] scheduled KEYS[4] 唯一锁
+	// ARGV[1] ID ARGV[2] 类型 ARGV[3] 内容 ARGV[4] header ARGV[5] 优先级 ARGV[6] 最大重试次数
+	// ARGV[7] 截止时间 ARGV[8] 超时 ARGV[9] 唯一锁有效期 ARGV[10] pending 分值 ARGV[11] 执行时间 (毫秒)
+	enqueueScript = redis.NewScript(`
+local unique = ''
+if tonumber(ARGV[9]) > 0 then
+	if not redis.call('SET', KEYS[4], ARGV[1], 'NX', 'PX', ARGV[9]) then
+		return 0
+	end
+	unique = KEYS[4]
+end
+redis.call('HSET', KEYS[1], 'type', ARGV[2], 'payload', ARGV[3], 'headers', ARGV[4], 'priority', ARGV[5],
+	'retried', 0, 'max_retry', ARGV[6], 'deadline', ARGV[7], 'timeout', ARGV[8], 'unique', unique)
+if tonumber(ARGV[11]) > 0 then
+	redis.call('ZADD', KEYS[3], ARGV[11], ARGV[1])
+else
+	redis.call('ZADD', KEYS[2], ARGV[10], ARGV[1])
+end
+return 1`)
+
+	// dequeueScript KEYS[1] pending KEYS[2] active ARGV[1] 租约到期时间(毫秒)
+	dequeueScript = redis.NewScript(`
+local ids = redis.call('ZRANGE', KEYS[1], 0, 0)
+if #ids == 0 then
+	return false
+end
+redis.call('ZREM', KEYS[1], ids[1])
+redis.call('ZADD', KEYS[2], ARGV[1], ids[1])
+return ids[1]`)
+
+	// extendScript KEYS[1] active ARGV[1] ID ARGV[2] 租约到期时间(毫秒)
+	extendScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return redis.call('ZADD', KEYS[1], 'XX', 'CH', ARGV[2], ARGV[1])
+end
+return 0`)
+
+	// doneScript KEYS[1] active KEYS[2] 任务 KEYS[3] 统计 ARGV[1] ID
+	doneScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local unique = redis.call('HGET', KEYS[2], 'unique')
+if unique and unique ~= '' and redis.call('GET', unique) == ARGV[1] then
+	redis.call('DEL', unique)
+end
+redis.call('DEL', KEYS[2])
+redis.call('HINCRBY', KEYS[3], 'processed', 1)
+return 1`)
+
+	// retryScript KEYS[1] active KEYS[2] 任务 KEYS[3] scheduled KEYS[4] 统计 ARGV[1] ID ARGV[2] 重试时间(毫秒) ARGV[3] 错误
+	retryScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HINCRBY', KEYS[2], 'retried', 1)
+redis.call('HSET', KEYS[2], 'error', ARGV[3])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('HINCRBY', KEYS[4], 'failed', 1)
+redis.call('HINCRBY', KEYS[4], 'retried', 1)
+return 1`)
+
+	// killScript KEYS[1] active KEYS[2] 任务 KEYS[3] dead KEYS[4] 统计 ARGV[1] ID ARGV[2] 当前时间 ARGV[3] 保留时间(毫秒) ARGV[4] 错误
+	killScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local unique = redis.call('HGET', KEYS[2], 'unique')
+if unique and unique ~= '' and redis.call('GET', unique) == ARGV[1] then
+	redis.call('DEL', unique)
+end
+redis.call('HSET', KEYS[2], 'error', ARGV[4])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+redis.call('HINCRBY', KEYS[4], 'failed', 1)
+redis.call('HINCRBY', KEYS[4], 'dead', 1)
+return 1`)
+
+	// maintainScript KEYS[1] pending KEYS[2] scheduled KEYS[3] active KEYS[4] dead
+	// ARGV[1] 当前时间(毫秒) ARGV[2] 任务 key 前缀 ARGV[3] 保留时间(毫秒) ARGV[4] 优先级权重 ARGV[5] 最大优先级
+	// 1.到期的 scheduled 任务进入 pending
+	// 2.租约到期的 active 任务计入重试后重新进入 pending，重试耗尽时进入 dead
+	// 3.清理超过保留时间的 dead 任务
+	maintainScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local function requeue(id)
+	local priority = tonumber(redis.call('HGET', ARGV[2] .. id, 'priority'))
+	if not priority then
+		return
+	end
+	redis.call('ZADD', KEYS[1], (tonumber(ARGV[5]) - priority) * tonumber(ARGV[4]) + now, id)
+end
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now, 'LIMIT', 0, 1000)) do
+	redis.call('ZREM', KEYS[2], id)
+	requeue(id)
+end
+local reclaimed = 0
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now, 'LIMIT', 0, 1000)) do
+	redis.call('ZREM', KEYS[3], id)
+	if redis.call('EXISTS', ARGV[2] .. id) == 1 then
+		local retried = redis.call('HINCRBY', ARGV[2] .. id, 'retried', 1)
+		if retried > tonumber(redis.call('HGET', ARGV[2] .. id, 'max_retry')) then
+			redis.call('HSET', ARGV[2] .. id, 'error', 'lease expired')
+			redis.call('PEXPIRE', ARGV[2] .. id, ARGV[3])
+			redis.call('ZADD', KEYS[4], now, id)
+		else
+			requeue(id)
+		end
+		reclaimed = reclaimed + 1
+	end
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[4], '-inf', now - tonumber(ARGV[3]))
+return reclaimed`)
+)
+
+// pendingScore 优先级高的在前，同优先级按入队时间先后
+func pendingScore(priority int, at time.Time) float64 {
+	return float64(MaxPriority-priority)*priorityWeight + float64(at.UnixMilli())
+}
+
+func keyPrefix(queue string) string {
+	return "dt:task:{" + queue + "}:"
+}
+
+func taskKey(queue, id string) string {
+	return keyPrefix(queue) + "t:" + id
+}
+
+func pendingKey(queue string) string {
+	return keyPrefix(queue) + "pending"
+}
+
+func scheduledKey(queue string) string {
+	return keyPrefix(queue) + "scheduled"
+}
+
+func activeKey(queue string) string {
+	return keyPrefix(queue) + "active"
+}
+
+func deadKey(queue string) string {
+	return keyPrefix(queue) + "dead"
+}
+
+func statsKey(queue string) string {
+	return keyPrefix(queue) + "stats"
+}
+
+// uniqueKey 按类型与内容去重
+func uniqueKey(queue, taskType string, payload []byte) string {
+	sum := sha1.Sum(append([]byte(taskType+"\x00"), payload...))
+	return keyPrefix(queue) + "unique:" + hex.EncodeToString(sum[:])
+}
+
+func newTaskID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,217 @@
+package taskqueue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type welcomeEmail struct {
+	UserID int `json:"user_id"`
+}
+
+func newTestQueue(t *testing.T) (*miniredis.Miniredis, redis.Cmdable, *TaskQueueImpl) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	header := http.Header{}
+	header.Set("x-user-id", "42")
+	return mr, client, &TaskQueueImpl{client: client, header: header}
+}
+
+func newTestServer(client redis.Cmdable) *ServerImpl {
+	server = newServer()
+	server.client = client
+	server.SetRetryDelay(func(int, error) time.Duration { return 0 })
+	return server
+}
+
+func TestPriorityAndBus(t *testing.T) {
+	_, client, queue := newTestQueue(t)
+	s := newTestServer(client)
+	s.SetQueue(DefaultQueue, 1)
+
+	var (
+		mu    sync.Mutex
+		order []int
+		users []string
+		done  = make(chan struct{}, 3)
+	)
+	Handle("email:welcome", func(worker dt.Worker, payload welcomeEmail) error {
+		mu.Lock()
+		order = append(order, payload.UserID)
+		users = append(users, worker.Bus().Get("x-user-id"))
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	})
+	_, err := queue.Enqueue("email:welcome", welcomeEmail{UserID: 1}, Priority(1))
+	require.NoError(t, err)
+	_, err = queue.Enqueue("email:welcome", welcomeEmail{UserID: 2})
+	require.NoError(t, err)
+	_, err = queue.Enqueue("email:welcome", welcomeEmail{UserID: 3}, Priority(9))
+	require.NoError(t, err)
+
+	s.start()
+	defer s.Stop(time.Second)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{3, 2, 1}, order)
+	assert.Equal(t, []string{"42", "42", "42"}, users)
+}
+
+func TestRetryUntilDead(t *testing.T) {
+	_, client, queue := newTestQueue(t)
+	s := newTestServer(client)
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	s.HandleFunc("reindex", func(worker dt.Worker, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return errors.New("search unavailable")
+	})
+	_, err := queue.Enqueue("reindex", []byte("catalog"), MaxRetry(2))
+	require.NoError(t, err)
+	_, err = queue.Enqueue("reindex", []byte("skip"), MaxRetry(2), Queue("low"))
+	require.NoError(t, err)
+
+	s.start()
+	defer s.Stop(time.Second)
+	require.Eventually(t, func() bool {
+		stats, _ := s.Stats(DefaultQueue)
+		return stats.Dead == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	stats, err := s.Stats(DefaultQueue)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Failed)
+	assert.Equal(t, int64(2), stats.Retried)
+	assert.Zero(t, stats.Pending+stats.Scheduled+stats.Active)
+	mu.Lock()
+	assert.Equal(t, 3, calls)
+	mu.Unlock()
+
+	// 未消费的队列保持不变
+	low, err := s.Stats("low")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), low.Pending)
+}
+
+func TestSkipRetryAndDeadline(t *testing.T) {
+	_, client, queue := newTestQueue(t)
+	s := newTestServer(client)
+	called := make(chan string, 2)
+	Handle("thumbnail", func(worker dt.Worker, payload string) error {
+		called <- payload
+		return ErrSkipRetry
+	})
+	_, err := queue.Enqueue("thumbnail", "expired", Deadline(time.Now().Add(-time.Second)))
+	require.NoError(t, err)
+	_, err = queue.Enqueue("thumbnail", "broken")
+	require.NoError(t, err)
+
+	s.start()
+	defer s.Stop(time.Second)
+	assert.Equal(t, "broken", <-called)
+	require.Eventually(t, func() bool {
+		stats, _ := s.Stats(DefaultQueue)
+		return stats.Dead == 2
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Empty(t, called, "expired task is not handled")
+}
+
+func TestUniqueTask(t *testing.T) {
+	_, client, queue := newTestQueue(t)
+	s := newTestServer(client)
+	s.HandleFunc("report", func(worker dt.Worker, payload []byte) error {
+		return nil
+	})
+
+	_, err := queue.Enqueue("report", []byte("2023-09"), Unique(time.Hour))
+	require.NoError(t, err)
+	_, err = queue.Enqueue("report", []byte("2023-09"), Unique(time.Hour))
+	assert.Equal(t, ErrDuplicateTask, err)
+	_, err = queue.Enqueue("report", []byte("2023-10"), Unique(time.Hour))
+	require.NoError(t, err)
+
+	s.start()
+	defer s.Stop(time.Second)
+	require.Eventually(t, func() bool {
+		stats, _ := s.Stats(DefaultQueue)
+		return stats.Processed == 2
+	}, time.Second, 20*time.Millisecond)
+	_, err = queue.Enqueue("report", []byte("2023-09"), Unique(time.Hour))
+	assert.NoError(t, err, "unique lock is released after completion")
+}
+
+func TestReclaimExpiredLease(t *testing.T) {
+	_, client, queue := newTestQueue(t)
+	id, err := queue.Enqueue("email:welcome", welcomeEmail{UserID: 1}, MaxRetry(1))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	reclaim := func() int {
+		// 模拟取出任务后进程崩溃
+		_, err := dequeueScript.Run(ctx, client, []string{pendingKey(DefaultQueue), activeKey(DefaultQueue)}, time.Now().Add(-time.Second).UnixMilli()).Result()
+		require.NoError(t, err)
+		n, err := maintainScript.Run(ctx, client,
+			[]string{pendingKey(DefaultQueue), scheduledKey(DefaultQueue), activeKey(DefaultQueue), deadKey(DefaultQueue)},
+			time.Now().UnixMilli(), keyPrefix(DefaultQueue)+"t:", DeadRetention.Milliseconds(), priorityWeight, MaxPriority).Int()
+		require.NoError(t, err)
+		return n
+	}
+
+	assert.Equal(t, 1, reclaim())
+	assert.Equal(t, int64(1), client.ZCard(ctx, pendingKey(DefaultQueue)).Val())
+	assert.Equal(t, "1", client.HGet(ctx, taskKey(DefaultQueue, id), "retried").Val())
+
+	assert.Equal(t, 1, reclaim())
+	assert.Equal(t, int64(0), client.ZCard(ctx, pendingKey(DefaultQueue)).Val())
+	assert.Equal(t, int64(1), client.ZCard(ctx, deadKey(DefaultQueue)).Val())
+}
+
+func TestEnqueueStripsCredentials(t *testing.T) {
+	mr, client, _ := newTestQueue(t)
+	header := http.Header{}
+	header.Set("x-request-id", "trace-1")
+	header.Set("x-language", "en-US")
+	header.Set("bearer_token", "secret-token")
+	header.Set("Authorization", "Bearer secret-token")
+	header.Set("Cookie", "session=secret-token")
+	header.Set("x-custom-token", "secret-token")
+	header.Set("user_id", "user-1")
+	header.Set("client_id", "client-1")
+	header.Set("account_type", "user")
+	StripHeaders("x-custom-token")
+	defer func() { credentialHeaders = credentialHeaders[:len(credentialHeaders)-1] }()
+
+	queue := &TaskQueueImpl{}
+	queue.BeginRequest(dt.NewWorker(false, header))
+	queue.client = client
+	id, err := queue.Enqueue("email:welcome", welcomeEmail{UserID: 1})
+	require.NoError(t, err)
+
+	stored := mr.HGet(taskKey(DefaultQueue, id), "headers")
+	assert.Contains(t, stored, "trace-1")
+	assert.Contains(t, stored, "en-US")
+	assert.Contains(t, stored, "user-1")
+	assert.Contains(t, stored, "client-1")
+	assert.Contains(t, stored, `"Account_type":["user"]`)
+	assert.NotContains(t, stored, "secret-token")
+}
@@ -2,6 +2,12 @@ package transaction
 
 /**
 数据库事务组件
+	1.Execute 与 ExecuteTx 使用 Required 传播行为，可以在已有事务中嵌套调用
+	2.ExecuteWith 指定传播行为：Required 加入外层事务，RequiresNew 使用新连接开启独立事务，Nested 使用 SAVEPOINT
+	3.Worker 的 store 中保存事务栈，local_transaction_db 始终为最内层的事务，Repository.FetchDB 取到的即为该事务
+	4.加入外层事务的调用失败时外层事务只能回滚，提交时返回 ErrRollbackOnly
+	5.SetRetry 后开启新事务的调用在死锁、锁等待超时等可重试错误时按退避重新执行整个函数，加入外层事务的调用由外层重试
+	6.默认使用默认数据源，WithDataSource 返回使用命名数据源的事务，不同数据源的事务互相独立，FetchDB 需指定相同的数据源
 
 Created by Dustin.zhu on 2022/11/1.
 */
@@ -10,9 +16,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math/rand"
+	"time"
 
 	dt "DT-Go"
 
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
 )
 
@@ -26,67 +35,300 @@ func init() {
 	})
 }
 
+// Propagation 事务传播行为
+type Propagation int
+
+const (
+	// Required 加入外层事务，没有外层事务时开启新事务
+	Required Propagation = iota
+	// RequiresNew 挂起外层事务，使用新的连接开启独立事务
+	RequiresNew
+	// Nested 在外层事务中创建 SAVEPOINT，失败时回滚到 SAVEPOINT 而不影响外层事务，没有外层事务时同 Required
+	Nested
+)
+
+const workerStoreTxStackKey = "local_transaction_stack"
+
+// ErrRollbackOnly 加入的事务执行失败，外层事务已回滚
+var ErrRollbackOnly = errors.New("transaction has been marked as rollback-only")
+
 var _ Transaction = (*SqlDBImpl)(nil)
 
 // Transaction .
 type Transaction interface {
 	Execute(fun func() error) (err error)
 	ExecuteTx(fun func() error, opts *sql.TxOptions) (err error)
+	// ExecuteWith 按传播行为执行，opts 仅在开启新事务时生效
+	ExecuteWith(propagation Propagation, fun func() error, opts *sql.TxOptions) (err error)
+	// InTransaction 当前 Worker 是否有进行中的事务
+	InTransaction() bool
+	// SetRetry 设置可重试错误的重试策略，默认不重试
+	SetRetry(policy RetryPolicy) Transaction
+	// OnRetry 每次重试前调用，attempt 为失败的执行次数
+	OnRetry(f func(attempt int, err error)) Transaction
+	// WithDataSource 返回使用命名数据源的事务
+	WithDataSource(name string) Transaction
 }
 
+// RetryPolicy 事务重试策略
+type RetryPolicy struct {
+	MaxAttempts int                  // 最大执行次数，含首次执行
+	Backoff     time.Duration        // 首次重试前的等待时间，之后每次翻倍
+	MaxBackoff  time.Duration        // 等待时间上限，默认 1 秒
+	Retryable   func(err error) bool // 是否可重试，默认 IsRetryable
+}
+
+// DefaultRetryPolicy 死锁与锁等待超时时最多执行3次
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 20 * time.Millisecond, MaxBackoff: time.Second}
+
 // SqlDBImpl .
 type SqlDBImpl struct {
 	dt.Infra
-	db *gorm.DB
+	datasource string
+	retry      RetryPolicy
+	retryHooks []func(attempt int, err error)
 }
 
 // BeginRequest .
 func (t *SqlDBImpl) BeginRequest(worker dt.Worker) {
-	t.db = nil
+	t.datasource = ""
+	t.retry = RetryPolicy{}
+	t.retryHooks = nil
 	t.Infra.BeginRequest(worker)
 }
 
+// frame 事务栈的一层，SAVEPOINT 与外层共用同一个事务
+type frame struct {
+	db           *gorm.DB
+	rollbackOnly bool
+}
+
 // Execute .
 func (t *SqlDBImpl) Execute(fun func() error) (err error) {
-	return t.execute(fun, nil)
+	return t.ExecuteWith(Required, fun, nil)
 }
 
 // ExecuteTx .
 func (t *SqlDBImpl) ExecuteTx(fun func() error, opts *sql.TxOptions) (err error) {
-	return t.execute(fun, opts)
+	return t.ExecuteWith(Required, fun, opts)
 }
 
-// execute .
-func (t *SqlDBImpl) execute(fun func() error, opts *sql.TxOptions) (err error) {
-	if t.db != nil {
-		panic("unknown error")
+// ExecuteWith .
+func (t *SqlDBImpl) ExecuteWith(propagation Propagation, fun func() error, opts *sql.TxOptions) (err error) {
+	stack := t.stack()
+	if len(stack) == 0 || propagation == RequiresNew {
+		return t.begin(fun, opts)
 	}
+	outer := stack[len(stack)-1]
+	if propagation == Nested {
+		return t.savepoint(outer, len(stack), fun)
+	}
+	if err = call(fun); err != nil {
+		outer.rollbackOnly = true
+	}
+	return
+}
 
-	db := t.SourceDB().(*gorm.DB)
-	t.db = db.Begin(opts)
+// InTransaction .
+func (t *SqlDBImpl) InTransaction() bool {
+	return len(t.stack()) > 0
+}
 
-	t.Worker().Store().Set("local_transaction_db", t.db)
+// SetRetry .
+func (t *SqlDBImpl) SetRetry(policy RetryPolicy) Transaction {
+	t.retry = policy
+	return t
+}
 
-	defer func() {
-		if perr := recover(); perr != nil {
-			t.db.Rollback()
-			t.db = nil
-			err = errors.New(fmt.Sprint(perr))
-			t.Worker().Store().Remove("local_transaction_db")
+// OnRetry .
+func (t *SqlDBImpl) OnRetry(f func(attempt int, err error)) Transaction {
+	t.retryHooks = append(t.retryHooks, f)
+	return t
+}
+
+// WithDataSource .
+func (t *SqlDBImpl) WithDataSource(name string) Transaction {
+	tx := *t
+	tx.datasource = name
+	return &tx
+}
+
+// DataSource 事务使用的数据源名称
+func (t *SqlDBImpl) DataSource() string {
+	if t.datasource == "" {
+		return dt.DefaultDataSource
+	}
+	return t.datasource
+}
+
+// begin 开启新事务，可重试的错误按退避重新执行
+func (t *SqlDBImpl) begin(fun func() error, opts *sql.TxOptions) (err error) {
+	retryable := t.retry.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	for attempt := 1; ; attempt++ {
+		err = t.beginOnce(fun, opts)
+		if err == nil || attempt >= t.retry.MaxAttempts || !retryable(err) {
 			return
 		}
-		deferDb := t.db
-		t.Worker().Store().Remove("local_transaction_db")
-		t.db = nil
-		if err != nil {
-			e2 := deferDb.Rollback()
-			if e2 != nil {
-				err = errors.New(err.Error() + "," + e2.Error.Error())
-			}
+		t.Worker().Logger().Warnf("transaction: attempt %d failed, retrying, err: %v", attempt, err)
+		for _, hook := range t.retryHooks {
+			hook(attempt, err)
+		}
+		if !t.sleep(t.retry.backoff(attempt)) {
 			return
 		}
-		deferDb.Commit()
-	}()
-	err = fun()
+	}
+}
+
+// sleep 等待期间请求结束时返回 false
+func (t *SqlDBImpl) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ctx := t.Worker().Context()
+	if ctx == nil {
+		<-timer.C
+		return true
+	}
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// beginOnce .
+func (t *SqlDBImpl) beginOnce(fun func() error, opts *sql.TxOptions) (err error) {
+	source, ok := t.SourceDB(t.datasource).(*gorm.DB)
+	if !ok {
+		return fmt.Errorf("DB %s not found, please install", t.DataSource())
+	}
+	// 请求取消时事务随之回滚
+	if worker := t.Worker(); worker != nil && worker.Context() != nil {
+		source = source.WithContext(worker.Context())
+	}
+	db := source.Begin(opts)
+	if db.Error != nil {
+		return db.Error
+	}
+	f := &frame{db: db}
+	t.push(f)
+	err = call(fun)
+	t.pop()
+
+	if err == nil && !f.rollbackOnly {
+		return db.Commit().Error
+	}
+	if err == nil {
+		err = ErrRollbackOnly
+	}
+	if e2 := db.Rollback().Error; e2 != nil {
+		err = fmt.Errorf("%w,%v", err, e2)
+	}
+	return
+}
+
+// savepoint 在外层事务中执行，失败时回滚到 SAVEPOINT
+func (t *SqlDBImpl) savepoint(outer *frame, depth int, fun func() error) (err error) {
+	name := fmt.Sprintf("dt_sp_%d", depth)
+	if err = outer.db.SavePoint(name).Error; err != nil {
+		return
+	}
+	f := &frame{db: outer.db}
+	t.push(f)
+	err = call(fun)
+	t.pop()
+
+	if err == nil && !f.rollbackOnly {
+		return
+	}
+	if err == nil {
+		err = ErrRollbackOnly
+	}
+	if e2 := outer.db.RollbackTo(name).Error; e2 != nil {
+		// 无法回滚到 SAVEPOINT 时外层事务只能整体回滚
+		outer.rollbackOnly = true
+		err = fmt.Errorf("%w,%v", err, e2)
+	}
 	return
 }
+
+// stackKey 每个数据源使用独立的事务栈
+func (t *SqlDBImpl) stackKey() string {
+	if t.DataSource() == dt.DefaultDataSource {
+		return workerStoreTxStackKey
+	}
+	return workerStoreTxStackKey + "@" + t.datasource
+}
+
+func (t *SqlDBImpl) stack() []*frame {
+	if stack, ok := t.Worker().Store().Get(t.stackKey()).([]*frame); ok {
+		return stack
+	}
+	return nil
+}
+
+func (t *SqlDBImpl) push(f *frame) {
+	store := t.Worker().Store()
+	store.Set(t.stackKey(), append(t.stack(), f))
+	store.Set(dt.TransactionKey(t.datasource), f.db)
+}
+
+// pop 恢复外层事务
+func (t *SqlDBImpl) pop() {
+	store := t.Worker().Store()
+	stack := t.stack()
+	if len(stack) <= 1 {
+		store.Remove(t.stackKey())
+		store.Remove(dt.TransactionKey(t.datasource))
+		return
+	}
+	stack = stack[:len(stack)-1]
+	store.Set(t.stackKey(), stack)
+	store.Set(dt.TransactionKey(t.datasource), stack[len(stack)-1].db)
+}
+
+// call panic 时转换为 error
+func call(fun func() error) (err error) {
+	defer func() {
+		if perr := recover(); perr != nil {
+			err = errors.New(fmt.Sprint(perr))
+		}
+	}()
+	return fun()
+}
+
+// backoff 第 attempt 次失败后的等待时间，附加至多 50% 的随机抖动
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Second
+	}
+	d := p.Backoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// IsRetryable MySQL 死锁(1213)、锁等待超时(1205)与 SQLSTATE 40001/40P01 的序列化失败可重试
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		state := stateErr.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+	return false
+}
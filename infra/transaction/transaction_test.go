@@ -0,0 +1,281 @@
+package transaction
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type account struct {
+	ID      int `gorm:"primaryKey;autoIncrement:false"`
+	Balance int
+}
+
+var (
+	setupOnce  sync.Once
+	accountsDB *gorm.DB
+	ledgerDB   *gorm.DB
+)
+
+// openSQLite 共享缓存的 sqlite 内存数据库
+func openSQLite(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&account{}))
+	return db
+}
+
+// setup 默认数据源 accounts 与命名数据源 ledger，每个测试开始前清空
+func setup(t *testing.T) {
+	setupOnce.Do(func() {
+		accountsDB, ledgerDB = openSQLite(t, "accounts"), openSQLite(t, "ledger")
+		u := dt.NewUnitTest(false)
+		u.InstallDB(func() interface{} { return accountsDB })
+		u.InstallDB(func() interface{} { return ledgerDB }, "ledger")
+		u.Run()
+	})
+	assert.Nil(t, accountsDB.Where("1 = 1").Delete(&account{}).Error)
+	assert.Nil(t, ledgerDB.Where("1 = 1").Delete(&account{}).Error)
+}
+
+// newTransaction 事务与资源库共用同一个 Worker
+func newTransaction() (*SqlDBImpl, *dt.Repository) {
+	worker := dt.NewWorker(false, nil)
+	tx := &SqlDBImpl{}
+	tx.BeginRequest(worker)
+	repo := &dt.Repository{}
+	repo.BeginRequest(worker)
+	return tx, repo
+}
+
+func fetchDB(t *testing.T, repo *dt.Repository, datasource ...string) *gorm.DB {
+	var db *gorm.DB
+	assert.Nil(t, repo.FetchDB(&db, datasource...))
+	return db
+}
+
+func insert(t *testing.T, repo *dt.Repository, id int, datasource ...string) {
+	assert.Nil(t, fetchDB(t, repo, datasource...).Create(&account{ID: id}).Error)
+}
+
+func ids(t *testing.T, db *gorm.DB) []int {
+	var result []int
+	assert.Nil(t, db.Model(&account{}).Order("id").Pluck("id", &result).Error)
+	return result
+}
+
+func TestRequiredRollbackOnly(t *testing.T) {
+	setup(t)
+	tx, repo := newTransaction()
+
+	err := tx.Execute(func() error {
+		insert(t, repo, 1)
+		// 加入外层事务，失败后外层只能回滚
+		innerErr := tx.Execute(func() error {
+			assert.True(t, tx.InTransaction())
+			insert(t, repo, 2)
+			return errors.New("inner failed")
+		})
+		assert.EqualError(t, innerErr, "inner failed")
+		return nil
+	})
+	assert.Equal(t, ErrRollbackOnly, err)
+	assert.False(t, tx.InTransaction())
+	assert.Empty(t, ids(t, accountsDB))
+
+	// panic 转换为 error 并回滚
+	err = tx.Execute(func() error {
+		insert(t, repo, 3)
+		panic("boom")
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Empty(t, ids(t, accountsDB))
+}
+
+func TestRequiresNew(t *testing.T) {
+	setup(t)
+	tx, repo := newTransaction()
+
+	err := tx.Execute(func() error {
+		outer := fetchDB(t, repo)
+		innerErr := tx.ExecuteWith(RequiresNew, func() error {
+			// 独立事务使用新的连接
+			inner := fetchDB(t, repo)
+			assert.NotEqual(t, outer.Statement.ConnPool, inner.Statement.ConnPool)
+			insert(t, repo, 1)
+			return nil
+		}, nil)
+		assert.Nil(t, innerErr)
+		insert(t, repo, 2)
+		return errors.New("outer failed")
+	})
+	assert.EqualError(t, err, "outer failed")
+	// 外层回滚不影响已提交的独立事务
+	assert.Equal(t, []int{1}, ids(t, accountsDB))
+}
+
+func TestNestedSavepoint(t *testing.T) {
+	setup(t)
+	tx, repo := newTransaction()
+
+	err := tx.Execute(func() error {
+		insert(t, repo, 1)
+		nestedErr := tx.ExecuteWith(Nested, func() error {
+			insert(t, repo, 2)
+			return errors.New("nested failed")
+		}, nil)
+		assert.EqualError(t, nestedErr, "nested failed")
+		assert.Nil(t, tx.ExecuteWith(Nested, func() error {
+			insert(t, repo, 3)
+			return nil
+		}, nil))
+		insert(t, repo, 4)
+		return nil
+	})
+	assert.Nil(t, err)
+	// 回滚到 SAVEPOINT，外层事务照常提交
+	assert.Equal(t, []int{1, 3, 4}, ids(t, accountsDB))
+}
+
+func TestFetchDBInnermost(t *testing.T) {
+	setup(t)
+	tx, repo := newTransaction()
+	source := fetchDB(t, repo).Statement.ConnPool
+
+	assert.Nil(t, tx.Execute(func() error {
+		outer := fetchDB(t, repo).Statement.ConnPool
+		assert.NotEqual(t, source, outer)
+		assert.Nil(t, tx.ExecuteWith(RequiresNew, func() error {
+			inner := fetchDB(t, repo).Statement.ConnPool
+			assert.NotEqual(t, outer, inner)
+			assert.Nil(t, tx.ExecuteWith(Nested, func() error {
+				// SAVEPOINT 与外层共用同一个事务
+				assert.Equal(t, inner, fetchDB(t, repo).Statement.ConnPool)
+				return nil
+			}, nil))
+			assert.Equal(t, inner, fetchDB(t, repo).Statement.ConnPool)
+			return nil
+		}, nil))
+		// 出栈后恢复外层事务
+		assert.Equal(t, outer, fetchDB(t, repo).Statement.ConnPool)
+		return nil
+	}))
+	assert.Equal(t, source, fetchDB(t, repo).Statement.ConnPool)
+	assert.Nil(t, repo.Worker().Store().Get(dt.TransactionKey(dt.DefaultDataSource)))
+}
+
+func TestDataSourceIsolation(t *testing.T) {
+	setup(t)
+	accounts, repo := newTransaction()
+	ledger := accounts.WithDataSource("ledger")
+	ledgerSource := fetchDB(t, repo, "ledger").Statement.ConnPool
+
+	err := accounts.Execute(func() error {
+		// 其它数据源不受当前事务影响
+		assert.False(t, ledger.InTransaction())
+		assert.Equal(t, ledgerSource, fetchDB(t, repo, "ledger").Statement.ConnPool)
+		insert(t, repo, 1)
+		insert(t, repo, 1, "ledger")
+
+		assert.Nil(t, ledger.Execute(func() error {
+			assert.NotEqual(t, ledgerSource, fetchDB(t, repo, "ledger").Statement.ConnPool)
+			assert.NotEqual(t, fetchDB(t, repo).Statement.ConnPool, fetchDB(t, repo, "ledger").Statement.ConnPool)
+			insert(t, repo, 2, "ledger")
+			return nil
+		}))
+		// ledger 事务结束后默认数据源的事务仍在进行
+		assert.True(t, accounts.InTransaction())
+		assert.False(t, ledger.InTransaction())
+		assert.Equal(t, ledgerSource, fetchDB(t, repo, "ledger").Statement.ConnPool)
+		return errors.New("accounts failed")
+	})
+	assert.EqualError(t, err, "accounts failed")
+	assert.Empty(t, ids(t, accountsDB))
+	assert.Equal(t, []int{1, 2}, ids(t, ledgerDB))
+
+	// 未安装的数据源
+	var db *gorm.DB
+	assert.EqualError(t, repo.FetchDB(&db, "missing"), "DB missing not found, please install")
+	called := false
+	err = accounts.WithDataSource("missing").Execute(func() error {
+		called = true
+		return nil
+	})
+	assert.EqualError(t, err, "DB missing not found, please install")
+	assert.False(t, called)
+}
+
+var errDeadlock = &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+
+func TestRetry(t *testing.T) {
+	setup(t)
+	tx, repo := newTransaction()
+	var retries []int
+	tx.SetRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}).OnRetry(func(attempt int, err error) {
+		assert.Equal(t, errDeadlock, err)
+		retries = append(retries, attempt)
+	})
+
+	// 首次执行死锁，回滚后重新执行整个函数
+	attempts := 0
+	assert.Nil(t, tx.Execute(func() error {
+		attempts++
+		insert(t, repo, attempts)
+		if attempts == 1 {
+			return errDeadlock
+		}
+		return nil
+	}))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []int{1}, retries)
+	assert.Equal(t, []int{2}, ids(t, accountsDB))
+
+	// 超过最大次数后返回最后一次的错误
+	retries, attempts = nil, 0
+	assert.Equal(t, errDeadlock, tx.Execute(func() error {
+		attempts++
+		return errDeadlock
+	}))
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []int{1, 2}, retries)
+
+	// 不可重试的错误只执行一次
+	retries, attempts = nil, 0
+	assert.EqualError(t, tx.Execute(func() error {
+		attempts++
+		return errors.New("insufficient balance")
+	}), "insufficient balance")
+	assert.Equal(t, 1, attempts)
+	assert.Empty(t, retries)
+
+	// 加入外层事务的调用不重试，外层提交时只能回滚
+	inner := 0
+	assert.Equal(t, ErrRollbackOnly, tx.Execute(func() error {
+		assert.Equal(t, errDeadlock, tx.Execute(func() error {
+			inner++
+			return errDeadlock
+		}))
+		return nil
+	}))
+	assert.Equal(t, 1, inner)
+	assert.Empty(t, retries)
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(errDeadlock))
+	assert.True(t, IsRetryable(&mysql.MySQLError{Number: 1205}))
+	assert.False(t, IsRetryable(&mysql.MySQLError{Number: 1062}))
+	assert.False(t, IsRetryable(ErrRollbackOnly))
+
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.True(t, policy.backoff(1) >= 100*time.Millisecond && policy.backoff(1) <= 150*time.Millisecond)
+	assert.True(t, policy.backoff(5) >= 300*time.Millisecond && policy.backoff(5) <= 450*time.Millisecond)
+}
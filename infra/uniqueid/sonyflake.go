@@ -8,6 +8,7 @@ Created by Dustin.zhu on 2023/04/20.
 
 import (
 	"errors"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -42,6 +43,7 @@ type Sonyflaker interface {
 	SetPodIP(ip string)
 	NextID() (int, error)
 	ShortID() int
+	ShardID(shard, shards int) int
 }
 
 type SonyflakerImpl struct {
@@ -92,3 +94,25 @@ func (sfi *SonyflakerImpl) NextID() (int, error) {
 func (sfi *SonyflakerImpl) ShortID() int {
 	return int(idgen.NextId())
 }
+
+// MaxShards ShardID 支持的最大分片数
+// ShortID 为基准时间起的毫秒数左移 16 位(WorkerId 11 位 + Seq 5 位)，乘以 64 后剩余 41 位毫秒数，约 69 年后溢出
+const MaxShards = 64
+
+// ShardID 在 ShortID 中编码分片序号，ID 对 shards 取模即为 shard，shards 不超过 MaxShards
+// 编码后超出 js 的安全整数范围，返回给前端时需使用字符串
+func (sfi *SonyflakerImpl) ShardID(shard, shards int) int {
+	if shards <= 0 || shards > MaxShards || shard < 0 || shard >= shards {
+		panic("uniqueid: invalid shard")
+	}
+	id := sfi.ShortID()
+	if id > (math.MaxInt64-shard)/shards {
+		panic("uniqueid: shard id overflows int64")
+	}
+	return id*shards + shard
+}
+
+// ShardOf 返回 ShardID 编码的分片序号
+func ShardOf(id, shards int) int {
+	return id % shards
+}
@@ -52,6 +52,9 @@ type Application struct {
 	Database struct {
 		db      interface{}
 		Install func() (db interface{})
+		// named datasources, installed by InstallDB with a name
+		named    map[string]interface{}
+		installs map[string]func() interface{}
 	}
 
 	// DBTable contains an installation funtion to initialize database tables
@@ -394,9 +397,16 @@ func (app *Application) RegisterShutdown(f func()) {
 	app.comPool.registerShutdown(f)
 }
 
-// InstallDB .
-func (app *Application) InstallDB(f func() interface{}) {
-	app.Database.Install = f
+// InstallDB name 为空或 DefaultDataSource 时安装默认数据源，否则安装命名数据源
+func (app *Application) InstallDB(f func() interface{}, name ...string) {
+	if len(name) == 0 || name[0] == "" || name[0] == DefaultDataSource {
+		app.Database.Install = f
+		return
+	}
+	if app.Database.installs == nil {
+		app.Database.installs = make(map[string]func() interface{})
+	}
+	app.Database.installs[name[0]] = f
 }
 
 // InstallDBTable
@@ -413,6 +423,12 @@ func (app *Application) installDB() {
 	if app.Database.Install != nil {
 		app.Database.db = app.Database.Install()
 	}
+	for name, install := range app.Database.installs {
+		if app.Database.named == nil {
+			app.Database.named = make(map[string]interface{})
+		}
+		app.Database.named[name] = install()
+	}
 
 	if app.Cache.Install != nil {
 		// redis连接不上，避免堵塞服务启动
@@ -502,6 +518,14 @@ func (app *Application) Db() *gorm.DB {
 	return app.Database.db.(*gorm.DB)
 }
 
+// dataSource returns the database connection object of the named datasource, nil if not installed
+func (app *Application) dataSource(name string) interface{} {
+	if name == "" || name == DefaultDataSource {
+		return app.Database.db
+	}
+	return app.Database.named[name]
+}
+
 // Redis return an instance of redis client
 func (app *Application) Redis() redis.Cmdable {
 	return app.Cache.client
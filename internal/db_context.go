@@ -0,0 +1,76 @@
+package internal
+
+import (
+	stdContext "context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const workerStoreQueryTimeoutKey = "local_query_timeout"
+
+type workerCtxKey struct{}
+
+type queryTimeoutCtxKey struct{}
+
+// WithWorker .
+func WithWorker(ctx stdContext.Context, worker Worker) stdContext.Context {
+	return stdContext.WithValue(ctx, workerCtxKey{}, worker)
+}
+
+// WorkerFrom 返回 ctx 携带的 Worker，未经 FetchDB 获取的连接返回 nil
+func WorkerFrom(ctx stdContext.Context) Worker {
+	if ctx == nil {
+		return nil
+	}
+	worker, _ := ctx.Value(workerCtxKey{}).(Worker)
+	return worker
+}
+
+// WithQueryTimeout 设置 ctx 上每条 SQL 的超时时间，由数据库的超时插件生效
+func WithQueryTimeout(ctx stdContext.Context, timeout time.Duration) stdContext.Context {
+	return stdContext.WithValue(ctx, queryTimeoutCtxKey{}, timeout)
+}
+
+// QueryTimeoutFrom .
+func QueryTimeoutFrom(ctx stdContext.Context) (time.Duration, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	timeout, ok := ctx.Value(queryTimeoutCtxKey{}).(time.Duration)
+	return timeout, ok
+}
+
+// SetQueryTimeout 设置 Worker 内 FetchDB 获取的连接的 SQL 超时时间，用于路由级别的默认超时
+func SetQueryTimeout(worker Worker, timeout time.Duration) {
+	worker.Store().Set(workerStoreQueryTimeoutKey, timeout)
+}
+
+// queryTimeout 依次取 Repository、Worker 设置的超时时间
+func queryTimeout(worker Worker, timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	timeout, _ = worker.Store().Get(workerStoreQueryTimeoutKey).(time.Duration)
+	return timeout
+}
+
+// bindContext 为 db 绑定 Worker 的 Context，携带 Worker、超时时间及读写路由状态
+func bindContext(worker Worker, db interface{}, timeout time.Duration) interface{} {
+	gdb, ok := db.(*gorm.DB)
+	if !ok || worker == nil {
+		return db
+	}
+	ctx := worker.Context()
+	if ctx == nil {
+		ctx = stdContext.Background()
+	}
+	ctx = WithWorker(ctx, worker)
+	if timeout = queryTimeout(worker, timeout); timeout > 0 {
+		ctx = WithQueryTimeout(ctx, timeout)
+	}
+	if _, ok := gdb.Config.Plugins[ReplicaPluginName]; ok {
+		ctx = WithDBRouting(ctx, dbRouting(worker))
+	}
+	return gdb.WithContext(ctx)
+}
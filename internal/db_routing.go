@@ -0,0 +1,53 @@
+package internal
+
+import (
+	stdContext "context"
+	"sync/atomic"
+)
+
+// ReplicaPluginName 读写分离插件的名称，安装该插件后 FetchDB 返回的连接携带 Worker 的路由状态
+const ReplicaPluginName = "dt:replica"
+
+const workerStoreRoutingKey = "local_db_routing"
+
+type routingCtxKey struct{}
+
+// DBRouting Worker 的读写路由状态，写入或开启事务后该 Worker 后续的读取均使用主库
+type DBRouting struct {
+	primary int32
+}
+
+// UsePrimary 后续的读取使用主库
+func (r *DBRouting) UsePrimary() {
+	atomic.StoreInt32(&r.primary, 1)
+}
+
+// Primary .
+func (r *DBRouting) Primary() bool {
+	return atomic.LoadInt32(&r.primary) == 1
+}
+
+// WithDBRouting .
+func WithDBRouting(ctx stdContext.Context, routing *DBRouting) stdContext.Context {
+	return stdContext.WithValue(ctx, routingCtxKey{}, routing)
+}
+
+// DBRoutingFrom 返回 ctx 携带的路由状态，未经 FetchDB 获取的连接返回 nil
+func DBRoutingFrom(ctx stdContext.Context) *DBRouting {
+	if ctx == nil {
+		return nil
+	}
+	routing, _ := ctx.Value(routingCtxKey{}).(*DBRouting)
+	return routing
+}
+
+// dbRouting 返回 Worker 的路由状态
+func dbRouting(worker Worker) *DBRouting {
+	store := worker.Store()
+	if routing, ok := store.Get(workerStoreRoutingKey).(*DBRouting); ok {
+		return routing
+	}
+	routing := &DBRouting{}
+	store.Set(workerStoreRoutingKey, routing)
+	return routing
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 
@@ -24,9 +25,9 @@ func (infra *Infra) BeginRequest(rt Worker) {
 	infra.worker = rt
 }
 
-// SourceDB .
-func (infra *Infra) SourceDB() (db interface{}) {
-	return infra.app().Database.db
+// SourceDB name 为数据源名称，默认为 DefaultDataSource
+func (infra *Infra) SourceDB(name ...string) (db interface{}) {
+	return infra.app().dataSource(dataSourceName(name))
 }
 
 // Redis .
@@ -34,6 +35,16 @@ func (infra *Infra) Redis() redis.Cmdable {
 	return infra.app().Cache.client
 }
 
+// Marshal serializes v with the serializer installed by InstallSerializer.
+func (infra *Infra) Marshal(v interface{}) ([]byte, error) {
+	return infra.app().marshal(v)
+}
+
+// Unmarshal deserializes data with the serializer installed by InstallSerializer.
+func (infra *Infra) Unmarshal(data []byte, v interface{}) error {
+	return infra.app().unmarshal(data, v)
+}
+
 // Other .
 func (infra *Infra) Other(obj interface{}) {
 	infra.app().other.get(obj)
@@ -68,6 +79,40 @@ func (infra *Infra) NewOAuth2Request(url string, transferBus ...bool) dhttp.Requ
 	return req
 }
 
+// NewOAuth2RequestFor uses the client credentials registered for the downstream audience and scopes.
+// transferBus : Whether to pass the context, turned on by default.
+func (infra *Infra) NewOAuth2RequestFor(url, audience string, scopes []string, transferBus ...bool) dhttp.Request {
+	req := dhttp.NewOauth2RequestFor(url, audience, scopes...)
+	if len(transferBus) > 0 && !transferBus[0] {
+		return req
+	}
+	if infra.worker == nil {
+		//The singleton object does not have a Worker component
+		return req
+	}
+	req.SetHeader(infra.worker.Bus().Header)
+	return req
+}
+
+// NewOnBehalfOfRequest exchanges the caller's bearer_token in the Bus for a downstream token (RFC 8693).
+// transferBus : Whether to pass the context, turned on by default.
+func (infra *Infra) NewOnBehalfOfRequest(url, audience string, scopes []string, transferBus ...bool) dhttp.Request {
+	subjectToken, ctx := "", context.Background()
+	if infra.worker != nil {
+		subjectToken, ctx = infra.worker.Bus().Get("bearer_token"), infra.worker.Context()
+	}
+	req := dhttp.NewOnBehalfOfRequest(ctx, url, subjectToken, audience, scopes...)
+	if len(transferBus) > 0 && !transferBus[0] {
+		return req
+	}
+	if infra.worker == nil {
+		//The singleton object does not have a Worker component
+		return req
+	}
+	req.SetHeader(infra.worker.Bus().Header)
+	return req
+}
+
 // NewH2CRequest transferBus : Whether to pass the context, turned on by default. Typically used for tracking internal services.
 func (infra *Infra) NewH2CRequest(url string, transferBus ...bool) dhttp.Request {
 	req := dhttp.NewH2CRequest(url)
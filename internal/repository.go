@@ -1,7 +1,7 @@
 package internal
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"reflect"
 	"time"
@@ -13,7 +13,8 @@ import (
 )
 
 type Repository struct {
-	worker Worker
+	worker       Worker
+	queryTimeout time.Duration
 }
 
 // BeginRequest .
@@ -21,37 +22,73 @@ func (repo *Repository) BeginRequest(rt Worker) {
 	repo.worker = rt
 }
 
-// FetchDB .
-func (repo *Repository) FetchDB(db interface{}) error {
-	resultDB := repo.app().Database.db
+// DefaultDataSource 默认数据源的名称
+const DefaultDataSource = "default"
 
-	transactionData := repo.worker.Store().Get("local_transaction_db")
+// TransactionKey 数据源的事务连接在 Worker store 中的 key
+func TransactionKey(name string) string {
+	if name == "" || name == DefaultDataSource {
+		return "local_transaction_db"
+	}
+	return "local_transaction_db@" + name
+}
+
+// FetchDB 事务中返回事务连接，否则返回连接池；配置了只读副本时事务外的读取路由到副本
+// 返回的连接绑定 Worker 的 Context，请求取消或超时后 SQL 随之中断
+// name 为数据源名称，默认为 DefaultDataSource
+func (repo *Repository) FetchDB(db interface{}, name ...string) error {
+	dsName := dataSourceName(name)
+	resultDB := bindContext(repo.worker, repo.app().dataSource(dsName), repo.queryTimeout)
+
+	transactionData := repo.worker.Store().Get(TransactionKey(dsName))
 	if transactionData != nil {
-		resultDB = transactionData
+		resultDB = bindContext(repo.worker, transactionData, repo.queryTimeout)
+		// 事务提交后的读取仍使用主库
+		dbRouting(repo.worker).UsePrimary()
 	}
 	if resultDB == nil {
-		return errors.New("DB not found, please install")
+		return fmt.Errorf("DB %s not found, please install", dsName)
 	}
 	// db必须为指针类型
 	if !fetchValue(db, resultDB) {
-		return errors.New("DB not found, please install")
+		return fmt.Errorf("DB %s not found, please install", dsName)
 	}
 	// db = resultDB
 	return nil
 }
 
 // FetchSourceDB .
-func (repo *Repository) FetchSourceDB(db interface{}) error {
-	resultDB := repo.app().Database.db
+func (repo *Repository) FetchSourceDB(db interface{}, name ...string) error {
+	dsName := dataSourceName(name)
+	resultDB := repo.app().dataSource(dsName)
 	if resultDB == nil {
-		return errors.New("DB not found, please install")
+		return fmt.Errorf("DB %s not found, please install", dsName)
 	}
 	if !fetchValue(db, resultDB) {
-		return errors.New("DB not found, please install")
+		return fmt.Errorf("DB %s not found, please install", dsName)
 	}
 	return nil
 }
 
+// dataSourceName .
+func dataSourceName(name []string) string {
+	if len(name) == 0 || name[0] == "" {
+		return DefaultDataSource
+	}
+	return name[0]
+}
+
+// UsePrimary 当前 Worker 后续的读取使用主库，写入后会自动切换
+func (repo *Repository) UsePrimary() {
+	dbRouting(repo.worker).UsePrimary()
+}
+
+// SetQueryTimeout 设置该 Repository 经 FetchDB 获取的连接每条 SQL 的超时时间，优先于路由设置的超时时间
+// 通常在 Repository 的 BeginRequest 中调用
+func (repo *Repository) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
 // Redis .
 func (repo *Repository) Redis() redis.Cmdable {
 	return repo.app().Cache.client
@@ -99,6 +136,40 @@ func (repo *Repository) NewOAuth2Request(url string, transferBus ...bool) dhttp.
 	return req
 }
 
+// NewOAuth2RequestFor uses the client credentials registered for the downstream audience and scopes.
+// transferBus : Whether to pass the context, turned on by default.
+func (repo *Repository) NewOAuth2RequestFor(url, audience string, scopes []string, transferBus ...bool) dhttp.Request {
+	req := dhttp.NewOauth2RequestFor(url, audience, scopes...)
+	if len(transferBus) > 0 && !transferBus[0] {
+		return req
+	}
+	if repo.worker == nil {
+		//The singleton object does not have a Worker component
+		return req
+	}
+	req.SetHeader(repo.worker.Bus().Header)
+	return req
+}
+
+// NewOnBehalfOfRequest exchanges the caller's bearer_token in the Bus for a downstream token (RFC 8693).
+// transferBus : Whether to pass the context, turned on by default.
+func (repo *Repository) NewOnBehalfOfRequest(url, audience string, scopes []string, transferBus ...bool) dhttp.Request {
+	subjectToken, ctx := "", context.Background()
+	if repo.worker != nil {
+		subjectToken, ctx = repo.worker.Bus().Get("bearer_token"), repo.worker.Context()
+	}
+	req := dhttp.NewOnBehalfOfRequest(ctx, url, subjectToken, audience, scopes...)
+	if len(transferBus) > 0 && !transferBus[0] {
+		return req
+	}
+	if repo.worker == nil {
+		//The singleton object does not have a Worker component
+		return req
+	}
+	req.SetHeader(repo.worker.Bus().Header)
+	return req
+}
+
 // // NewThriftClient .
 // func (repo *Repository) NewThriftClient(config *dthrift.ThriftPoolConfig) *dthrift.ThriftPoolAgent {
 // 	return dthrift.NewThriftPoolAgent(config)
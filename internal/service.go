@@ -16,12 +16,7 @@ func callService(private bool, fun interface{}, worker ...Worker) {
 		app = publicApp
 	}
 	if len(worker) == 0 {
-		worker = make([]Worker, 1)
-		ctx := context.NewContext(app.IrisApp)
-		ctx.BeginRequest(nil, new(http.Request))
-		rt := newWorker(ctx, private)
-		ctx.Values().Set(WorkerKey, rt)
-		worker[0] = rt
+		worker = []Worker{NewWorker(private, nil)}
 	}
 	serviceObj, err := parseCallServiceFunc(fun)
 	if err != nil {
@@ -35,3 +30,19 @@ func callService(private bool, fun interface{}, worker ...Worker) {
 	}
 	app.pool.free(newService)
 }
+
+// NewWorker creates a Worker outside of an http request, such as scheduled jobs and task consumers.
+// header is used to rebuild the Bus, nil means an empty Bus.
+func NewWorker(private bool, header http.Header) Worker {
+	app := publicApp
+	if private {
+		app = privateApp
+	}
+	req := new(http.Request)
+	req.Header = header
+	ctx := context.NewContext(app.IrisApp)
+	ctx.BeginRequest(nil, req)
+	rt := newWorker(ctx, private)
+	ctx.Values().Set(WorkerKey, rt)
+	return rt
+}
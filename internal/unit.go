@@ -30,7 +30,7 @@ type UnitTest interface {
 	GetService(service interface{})
 	GetRepository(repository interface{})
 	GetFactory(factory interface{})
-	InstallDB(f func() (db interface{}))
+	InstallDB(f func() (db interface{}), name ...string)
 	InstallDBTable(f func() (tables map[string]interface{}))
 	InstallRedis(f func() (client redis.Cmdable))
 	SetRedisMock(mock redismock.ClientMock)
@@ -107,8 +107,8 @@ func (u *UnitTestImpl) GetFactory(factory interface{}) {
 }
 
 // InstallDB .
-func (u *UnitTestImpl) InstallDB(f func() (db interface{})) {
-	u.App().InstallDB(f)
+func (u *UnitTestImpl) InstallDB(f func() (db interface{}), name ...string) {
+	u.App().InstallDB(f, name...)
 }
 
 // InstallDBTable .
@@ -129,10 +129,13 @@ func (u *UnitTestImpl) Run() {
 	u.rt = u.newRuntime()
 	logLevel := "debug"
 	u.App().IrisApp.Logger().SetLevel(logLevel)
-	u.App().InstallDB(func() interface{} {
-		db, _, _ := sqlmock.New()
-		return db
-	})
+	// 未安装数据源时使用 sqlmock
+	if u.App().Database.Install == nil {
+		u.App().InstallDB(func() interface{} {
+			db, _, _ := sqlmock.New()
+			return db
+		})
+	}
 	u.App().InstallRedis(func() (client redis.Cmdable) {
 		// 创建一个redis mock
 		redisClient, mock := redismock.NewClientMock()
@@ -0,0 +1,59 @@
+// 声明式鉴权中间件，需在认证中间件之后使用
+package middleware
+
+import (
+	dt "DT-Go"
+	"DT-Go/errors"
+	"DT-Go/infra/authz"
+	"DT-Go/internal"
+
+	"github.com/kataras/iris/v12/context"
+)
+
+// RequirePermissions 要求调用方具备全部权限，可在 BindController/CreateParty 时挂载
+func RequirePermissions(permissions ...string) context.Handler {
+	return RequirePolicy(authz.Require(permissions...))
+}
+
+// RequirePolicy 要求调用方满足策略，可在 BindController/CreateParty 时挂载
+func RequirePolicy(policy authz.Policy) context.Handler {
+	return func(ctx dt.Context) {
+		if authorize(ctx, policy) {
+			ctx.Next()
+		}
+	}
+}
+
+// NewAuthorization 按控制器方法注解(authz.Annotate)的策略鉴权，未注解的路由直接放行
+func NewAuthorization() context.Handler {
+	return func(ctx dt.Context) {
+		route := ctx.GetCurrentRoute()
+		if route == nil {
+			ctx.Next()
+			return
+		}
+		policy, ok := authz.AnnotatedPolicy(route.MainHandlerName())
+		if !ok {
+			ctx.Next()
+			return
+		}
+		if authorize(ctx, policy) {
+			ctx.Next()
+		}
+	}
+}
+
+// authorize 执行策略决策，拒绝时写入错误响应
+func authorize(ctx dt.Context, policy authz.Policy) bool {
+	worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
+	decision, err := authz.Decide(policy, authz.RequestAttributes(worker))
+	if err != nil {
+		errorResponse(errors.New(worker.Bus().Get("language"), errors.InternalErr, "authorization failed.", map[string]string{"reason": err.Error()}), ctx)
+		return false
+	}
+	if !decision.Allowed {
+		errorResponse(authz.Forbidden(worker, decision), ctx)
+		return false
+	}
+	return true
+}
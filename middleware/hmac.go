@@ -0,0 +1,210 @@
+// 服务间 HMAC 请求签名校验中间件
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/config"
+	"DT-Go/errors"
+	"DT-Go/infra/dhttp"
+	"DT-Go/internal"
+	"DT-Go/utils"
+
+	"github.com/kataras/iris/v12/context"
+)
+
+const (
+	// hmacNonceRedisPrefix 防重放 nonce 的 redis key 前缀
+	hmacNonceRedisPrefix = "dt:hmac:nonce:"
+	// defaultHMACClockSkew 默认允许的时钟偏差
+	defaultHMACClockSkew = 5 * time.Minute
+	// maxHMACNonces 进程内最多记录的 nonce 数
+	maxHMACNonces = 100000
+)
+
+// errNonceCacheFull 进程内 nonce 记录已满且均未过期，拒绝请求而不是丢弃记录放行重放
+var errNonceCacheFull = fmt.Errorf("nonce cache is full")
+
+// HMACKeyring 签名密钥配置 hmac.yaml
+//
+//	signing_key_id: svc-a-2023
+//	keys:
+//	  - key_id: svc-a-2023
+//	    secret: 8d1f...
+//	    client_id: svc-a
+type HMACKeyring struct {
+	SigningKeyID string    `yaml:"signing_key_id"` // 本服务发起请求时使用的密钥
+	Keys         []HMACKey `yaml:"keys"`
+}
+
+// HMACKey 签名密钥，client_id 为该密钥对应的调用方身份
+type HMACKey struct {
+	KeyID    string `yaml:"key_id"`
+	Secret   string `yaml:"secret"`
+	ClientID string `yaml:"client_id"`
+}
+
+// LoadHMACKeyring 从配置目录读取签名密钥配置文件
+func LoadHMACKeyring(file string) (*HMACKeyring, error) {
+	var keyring HMACKeyring
+	if err := config.Configure(&keyring, file); err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+// Key 按 key id 查找密钥
+func (k *HMACKeyring) Key(keyID string) (HMACKey, bool) {
+	for _, key := range k.Keys {
+		if key.KeyID == keyID {
+			return key, true
+		}
+	}
+	return HMACKey{}, false
+}
+
+// Signer 使用 signing_key_id 签名的 dhttp 中间件 dhttp.InstallMiddleware(keyring.Signer())
+func (k *HMACKeyring) Signer() dhttp.Handler {
+	key, ok := k.Key(k.SigningKeyID)
+	if !ok {
+		dt.Logger().Fatalf("HMACKeyring: signing key not found, %s", k.SigningKeyID)
+	}
+	return dhttp.NewHMACSigner(key.KeyID, []byte(key.Secret))
+}
+
+// HMACConfig 签名校验中间件配置
+type HMACConfig struct {
+	Keyring *HMACKeyring
+	// ClockSkew 允许的时钟偏差，默认 5 分钟
+	ClockSkew time.Duration
+	// RedisNonce 使用 redis 记录已使用的 nonce，多 pod 部署时应开启，否则仅在进程内防重放
+	RedisNonce bool
+}
+
+// NewHMACAuthentication 服务间 HMAC 签名校验中间件
+// 校验通过后将密钥对应的 client_id 写入总线，与应用账户 token 认证后的身份一致
+func NewHMACAuthentication(conf HMACConfig) context.Handler {
+	if conf.ClockSkew <= 0 {
+		conf.ClockSkew = defaultHMACClockSkew
+	}
+	nonces := newNonceCache(2*conf.ClockSkew, conf.RedisNonce, maxHMACNonces)
+	return func(ctx dt.Context) {
+		language := utils.ParseXLanguage(ctx.GetHeader("x-language"))
+		unauthorized := func(reason string) {
+			errorResponse(errors.New(language, errors.UnauthorizedErr, reason, nil), ctx)
+		}
+
+		key, ok := conf.Keyring.Key(ctx.GetHeader(dhttp.HeaderKeyID))
+		if !ok {
+			unauthorized("unknown signing key.")
+			return
+		}
+		timestamp := ctx.GetHeader(dhttp.HeaderTimestamp)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			unauthorized("invalid signature timestamp.")
+			return
+		}
+		if skew := time.Since(time.Unix(unix, 0)); skew > conf.ClockSkew || skew < -conf.ClockSkew {
+			unauthorized("signature expired.")
+			return
+		}
+		nonce := ctx.GetHeader(dhttp.HeaderNonce)
+		if nonce == "" {
+			unauthorized("missing signature nonce.")
+			return
+		}
+
+		body, err := ioutil.ReadAll(ctx.Request().Body)
+		if err != nil {
+			errorResponse(errors.New(language, errors.BadRequestErr, "read body failed.", nil), ctx)
+			return
+		}
+		ctx.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+		digest := dhttp.ContentSHA256(body)
+		if ctx.GetHeader(dhttp.HeaderContentSHA256) != digest {
+			unauthorized("content digest mismatch.")
+			return
+		}
+		expected := dhttp.HMACSignature([]byte(key.Secret), ctx.Method(), ctx.Request().URL, digest, timestamp, nonce)
+		if !hmac.Equal([]byte(expected), []byte(ctx.GetHeader(dhttp.HeaderSignature))) {
+			unauthorized("invalid signature.")
+			return
+		}
+
+		worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
+		fresh, err := nonces.use(worker, key.KeyID+":"+nonce)
+		if err != nil {
+			errorResponse(errors.New(language, errors.InternalErr, "nonce check failed.", map[string]string{"reason": err.Error()}), ctx)
+			return
+		}
+		if !fresh {
+			unauthorized("replayed request.")
+			return
+		}
+
+		worker.Bus().Add("user_id", key.ClientID)
+		worker.Bus().Add("client_id", key.ClientID)
+		worker.Bus().Add("language", language)
+		worker.Bus().Add("account_type", "")
+		ctx.Next()
+	}
+}
+
+// nonceCache 已使用的 nonce，保留时长覆盖整个时钟偏差窗口
+type nonceCache struct {
+	ttl        time.Duration
+	redisNonce bool
+	limit      int
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	order      []string // 按写入顺序排列, ttl 固定因此也按过期时间排列
+}
+
+func newNonceCache(ttl time.Duration, redisNonce bool, limit int) *nonceCache {
+	return &nonceCache{ttl: ttl, redisNonce: redisNonce, limit: limit, seen: make(map[string]time.Time)}
+}
+
+// use 首次使用返回 true
+func (c *nonceCache) use(worker internal.Worker, key string) (bool, error) {
+	if c.redisNonce {
+		client := internal.NewPublicApplication().Redis()
+		if worker.IsPrivate() {
+			client = internal.NewPrivateApplication().Redis()
+		}
+		return client.SetNX(worker.Context(), hmacNonceRedisPrefix+key, 1, c.ttl).Result()
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(now)
+	if _, ok := c.seen[key]; ok {
+		return false, nil
+	}
+	if len(c.seen) >= c.limit {
+		return false, errNonceCacheFull
+	}
+	c.seen[key] = now.Add(c.ttl)
+	c.order = append(c.order, key)
+	return true, nil
+}
+
+// evict 从最早写入的 nonce 开始淘汰已过期的记录
+func (c *nonceCache) evict(now time.Time) {
+	n := 0
+	for ; n < len(c.order); n++ {
+		if now.Before(c.seen[c.order[n]]) {
+			break
+		}
+		delete(c.seen, c.order[n])
+	}
+	c.order = c.order[n:]
+}
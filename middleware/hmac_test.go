@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"DT-Go/infra/dhttp"
+	"DT-Go/internal"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+const testHMACSecret = "secret-a"
+
+// testWorker 只提供中间件写入身份信息的总线
+type testWorker struct {
+	internal.Worker
+	bus *internal.Bus
+}
+
+func (w *testWorker) Bus() *internal.Bus { return w.bus }
+
+// newHMACApp 校验通过后返回总线中的 client_id 与请求体
+func newHMACApp(t *testing.T) *iris.Application {
+	app := iris.New()
+	app.Use(func(ctx iris.Context) {
+		ctx.Values().Set(internal.WorkerKey, &testWorker{bus: &internal.Bus{Header: ctx.Request().Header.Clone()}})
+		ctx.Next()
+	})
+	app.Use(NewHMACAuthentication(HMACConfig{
+		Keyring: &HMACKeyring{Keys: []HMACKey{{KeyID: "a-2023", Secret: testHMACSecret, ClientID: "svc-a"}}},
+	}))
+	app.Post("/orders", func(ctx iris.Context) {
+		body, _ := ctx.GetBody()
+		worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
+		ctx.WriteString(worker.Bus().Get("client_id") + ":" + string(body))
+	})
+	assert.Nil(t, app.Build())
+	return app
+}
+
+func signedRequest(t *testing.T, keyID, secret, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/orders?page=1", strings.NewReader(body))
+	assert.Nil(t, dhttp.SignHMAC(req, nil, keyID, []byte(secret)))
+	return req
+}
+
+func serve(app *iris.Application, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHMACAuthentication(t *testing.T) {
+	app := newHMACApp(t)
+
+	// 签名与校验一致，请求体可继续读取
+	rec := serve(app, signedRequest(t, "a-2023", testHMACSecret, `{"id":1}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `svc-a:{"id":1}`, rec.Body.String())
+
+	resign := func(req *http.Request, timestamp int64) {
+		req.Header.Set(dhttp.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(dhttp.HeaderSignature, dhttp.HMACSignature([]byte(testHMACSecret), req.Method, req.URL,
+			req.Header.Get(dhttp.HeaderContentSHA256), req.Header.Get(dhttp.HeaderTimestamp), req.Header.Get(dhttp.HeaderNonce)))
+	}
+	cases := map[string]struct {
+		req   func() *http.Request
+		cause string
+	}{
+		"unknown key": {func() *http.Request {
+			return signedRequest(t, "b-2023", testHMACSecret, "{}")
+		}, "unknown signing key."},
+		"wrong secret": {func() *http.Request {
+			return signedRequest(t, "a-2023", "secret-b", "{}")
+		}, "invalid signature."},
+		"body tampered": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, `{"amount":1}`)
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount":100}`)).Body
+			return req
+		}, "content digest mismatch."},
+		"digest forged": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, `{"amount":1}`)
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount":100}`)).Body
+			req.Header.Set(dhttp.HeaderContentSHA256, dhttp.ContentSHA256([]byte(`{"amount":100}`)))
+			return req
+		}, "invalid signature."},
+		"query tampered": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+			req.URL.RawQuery = "page=2"
+			return req
+		}, "invalid signature."},
+		"clock skew past": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+			resign(req, time.Now().Add(-6*time.Minute).Unix())
+			return req
+		}, "signature expired."},
+		"clock skew future": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+			resign(req, time.Now().Add(6*time.Minute).Unix())
+			return req
+		}, "signature expired."},
+		"missing nonce": {func() *http.Request {
+			req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+			req.Header.Del(dhttp.HeaderNonce)
+			return req
+		}, "missing signature nonce."},
+	}
+	for name, c := range cases {
+		rec := serve(app, c.req())
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), c.cause, name)
+	}
+
+	// 偏差窗口内的时间戳可以通过
+	req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+	resign(req, time.Now().Add(-4*time.Minute).Unix())
+	assert.Equal(t, http.StatusOK, serve(app, req).Code)
+}
+
+func TestHMACReplay(t *testing.T) {
+	app := newHMACApp(t)
+	req := signedRequest(t, "a-2023", testHMACSecret, "{}")
+	replay := req.Clone(req.Context())
+	replay.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")).Body
+
+	assert.Equal(t, http.StatusOK, serve(app, req).Code)
+	rec := serve(app, replay)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "replayed request.")
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache(50*time.Millisecond, false, 2)
+	fresh, err := c.use(nil, "a")
+	assert.True(t, fresh)
+	assert.Nil(t, err)
+	fresh, _ = c.use(nil, "a")
+	assert.False(t, fresh)
+	fresh, _ = c.use(nil, "b")
+	assert.True(t, fresh)
+
+	// 已满且均未过期时拒绝，不淘汰未过期的记录
+	fresh, err = c.use(nil, "c")
+	assert.False(t, fresh)
+	assert.Equal(t, errNonceCacheFull, err)
+	fresh, _ = c.use(nil, "a")
+	assert.False(t, fresh)
+
+	// 过期后按写入顺序淘汰
+	time.Sleep(60 * time.Millisecond)
+	fresh, err = c.use(nil, "c")
+	assert.True(t, fresh)
+	assert.Nil(t, err)
+	assert.Len(t, c.seen, 1)
+	assert.Equal(t, []string{"c"}, c.order)
+}
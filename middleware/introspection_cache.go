@@ -0,0 +1,181 @@
+// token 内省结果缓存
+// 进程内缓存 + 可选的 redis 缓存，缓存 key 为 token 的 sha256，有效期不超过 token 的 exp
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/internal"
+
+	redis "github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// ScopeKey 认证中间件将 token 的 scope 写入 ctx.Values() 的 key
+	ScopeKey = "token_scope"
+	// introspectionRedisPrefix redis 缓存 key 前缀
+	introspectionRedisPrefix = "dt:introspection:"
+	// maxIntrospectionEntries 进程内最多缓存的 token 数
+	maxIntrospectionEntries = 100000
+	// introspectionRedisTimeout 读写 redis 缓存的超时时间
+	introspectionRedisTimeout = time.Second
+)
+
+// introspectionEntry .
+type introspectionEntry struct {
+	result   Introspection
+	expireAt time.Time
+}
+
+// introspectionCache .
+type introspectionCache struct {
+	ttl        time.Duration
+	redisCache bool
+	group      singleflight.Group
+	mu         sync.RWMutex
+	entries    map[string]introspectionEntry
+
+	fetch  func(token string) (Introspection, error)  // 调用 hydra 内省
+	client func(worker internal.Worker) redis.Cmdable // 返回 redis 缓存的客户端
+}
+
+func newIntrospectionCache(ttl time.Duration, redisCache bool) *introspectionCache {
+	c := &introspectionCache{
+		ttl:        ttl,
+		redisCache: redisCache,
+		entries:    make(map[string]introspectionEntry),
+		fetch: func(token string) (Introspection, error) {
+			return introspection(token, nil)
+		},
+	}
+	c.client = c.redis
+	return c
+}
+
+// introspect 依次读取进程内缓存、redis 缓存，未命中时调用 hydra 内省
+func (c *introspectionCache) introspect(worker internal.Worker, token string) (Introspection, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	if c.ttl > 0 {
+		c.mu.RLock()
+		entry, ok := c.entries[key]
+		c.mu.RUnlock()
+		if ok && time.Now().Before(entry.expireAt) {
+			return entry.result, nil
+		}
+	}
+
+	client := c.client(worker)
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		if client != nil {
+			ctx, cancel := redisContext()
+			data, err := client.Get(ctx, introspectionRedisPrefix+key).Bytes()
+			cancel()
+			if err == nil {
+				var result Introspection
+				if err = json.Unmarshal(data, &result); err == nil {
+					c.setLocal(key, result)
+					return result, nil
+				}
+			}
+		}
+
+		result, err := c.fetch(token)
+		if err != nil {
+			return result, err
+		}
+		if !result.Active {
+			return result, nil
+		}
+		c.setLocal(key, result)
+		if client != nil {
+			if expire := c.expiration(result); expire > 0 {
+				data, _ := json.Marshal(result)
+				ctx, cancel := redisContext()
+				defer cancel()
+				if err := client.Set(ctx, introspectionRedisPrefix+key, data, expire).Err(); err != nil {
+					dt.Logger().Warnf("cache introspection failed, err: %v", err)
+				}
+			}
+		}
+		return result, nil
+	})
+	if err != nil {
+		return Introspection{}, err
+	}
+	return v.(Introspection), nil
+}
+
+// redisContext 合并的请求共用结果, 不使用首个请求的 Context, 避免其取消导致全部等待者失败
+func redisContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), introspectionRedisTimeout)
+}
+
+// expiration 缓存时长 min(ttl, exp-now)
+func (c *introspectionCache) expiration(result Introspection) time.Duration {
+	expire := c.ttl
+	if result.ExpiresAt > 0 {
+		if untilExp := time.Until(time.Unix(result.ExpiresAt, 0)); untilExp < expire {
+			expire = untilExp
+		}
+	}
+	return expire
+}
+
+func (c *introspectionCache) setLocal(key string, result Introspection) {
+	expire := c.expiration(result)
+	if expire <= 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxIntrospectionEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expireAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxIntrospectionEntries {
+			c.entries = make(map[string]introspectionEntry)
+		}
+	}
+	c.entries[key] = introspectionEntry{result: result, expireAt: now.Add(expire)}
+}
+
+func (c *introspectionCache) redis(worker internal.Worker) redis.Cmdable {
+	if !c.redisCache || c.ttl <= 0 {
+		return nil
+	}
+	if worker.IsPrivate() {
+		return internal.NewPrivateApplication().Redis()
+	}
+	return internal.NewPublicApplication().Redis()
+}
+
+// missingScopes 返回 granted 未覆盖的 required scope
+// 与 hydra 默认的 wildcard 策略一致: "doc.*" 覆盖 "doc.read"
+func missingScopes(granted string, required []string) (missing []string) {
+	grantedScopes := strings.Fields(granted)
+	for _, r := range required {
+		matched := false
+		for _, g := range grantedScopes {
+			if g == r || (strings.HasSuffix(g, ".*") && strings.HasPrefix(r, strings.TrimSuffix(g, "*"))) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			missing = append(missing, r)
+		}
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/internal"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestIntrospectionCache fetch 返回 result 并计数，redis 使用 miniredis
+func newTestIntrospectionCache(t *testing.T, ttl time.Duration, result Introspection, delay time.Duration) (*introspectionCache, *miniredis.Miniredis, *int32) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	var calls int32
+	c := newIntrospectionCache(ttl, true)
+	c.fetch = func(token string) (Introspection, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(delay)
+		return result, nil
+	}
+	c.client = func(internal.Worker) redis.Cmdable { return client }
+	return c, mr, &calls
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Unix()
+	active := Introspection{Active: true, Subject: "user-1", Scope: "doc.read", ExpiresAt: exp}
+	c, mr, calls := newTestIntrospectionCache(t, time.Hour, active, 0)
+
+	for i := 0; i < 3; i++ {
+		result, err := c.introspect(nil, "token-1")
+		assert.Nil(t, err)
+		assert.Equal(t, "user-1", result.Subject)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+	// 缓存时长不超过 token 的 exp
+	keys := mr.Keys()
+	assert.Len(t, keys, 1)
+	ttl := mr.TTL(keys[0])
+	assert.True(t, ttl > 0 && ttl <= time.Minute, ttl)
+
+	// 其它 pod 从 redis 读取
+	other, _, otherCalls := newTestIntrospectionCache(t, time.Hour, active, 0)
+	other.client = c.client
+	result, err := other.introspect(nil, "token-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "user-1", result.Subject)
+	assert.Equal(t, int32(0), atomic.LoadInt32(otherCalls))
+
+	// 已过期的 token 不缓存
+	expired := Introspection{Active: true, ExpiresAt: time.Now().Add(-time.Second).Unix()}
+	assert.True(t, c.expiration(expired) <= 0)
+
+	// 未激活的 token 不缓存
+	inactive, _, inactiveCalls := newTestIntrospectionCache(t, time.Hour, Introspection{Active: false}, 0)
+	inactive.introspect(nil, "token-2")
+	inactive.introspect(nil, "token-2")
+	assert.Equal(t, int32(2), atomic.LoadInt32(inactiveCalls))
+}
+
+func TestIntrospectionCoalescing(t *testing.T) {
+	active := Introspection{Active: true, Subject: "user-1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	c, _, calls := newTestIntrospectionCache(t, time.Hour, active, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := c.introspect(nil, "token-1")
+			assert.Nil(t, err)
+			assert.Equal(t, "user-1", result.Subject)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestIntrospectionDetachedContext(t *testing.T) {
+	active := Introspection{Active: true, Subject: "user-1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	c, mr, _ := newTestIntrospectionCache(t, time.Hour, active, 0)
+
+	// 首个请求的 Context 已取消，合并调用仍读写 redis
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	worker := internal.NewWorker(false, nil)
+	worker.WithContext(ctx)
+	result, err := c.introspect(worker, "token-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "user-1", result.Subject)
+	assert.Len(t, mr.Keys(), 1)
+}
+
+func TestMissingScopes(t *testing.T) {
+	cases := []struct {
+		granted  string
+		required []string
+		missing  []string
+	}{
+		{"doc.read doc.write", []string{"doc.read"}, nil},
+		{"doc.*", []string{"doc.read", "doc.write"}, nil},
+		{"doc.*", []string{"docs.read"}, []string{"docs.read"}},
+		{"doc.read", []string{"doc.read", "doc.write"}, []string{"doc.write"}},
+		{"", []string{"doc.read"}, []string{"doc.read"}},
+		{"doc.read", nil, nil},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.missing, missingScopes(c.granted, c.required), c.granted)
+	}
+}
+
+func TestAuthenticationIntrospectionFailure(t *testing.T) {
+	hydra := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hydra.Close()
+	u, _ := url.Parse(hydra.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	conf := dt.NewConfiguration()
+	protocol, oldHost, oldPort := conf.DS.HydraAdminProtocol, conf.DS.HydraAdminHost, conf.DS.HydraAdminPort
+	conf.DS.HydraAdminProtocol, conf.DS.HydraAdminHost, conf.DS.HydraAdminPort = u.Scheme, host, port
+	defer func() { conf.DS.HydraAdminProtocol, conf.DS.HydraAdminHost, conf.DS.HydraAdminPort = protocol, oldHost, oldPort }()
+
+	app := iris.New()
+	app.Use(func(ctx iris.Context) {
+		ctx.Values().Set(internal.WorkerKey, &testWorker{bus: &internal.Bus{Header: ctx.Request().Header.Clone()}})
+		ctx.Next()
+	})
+	app.Use(NewAuthentication())
+	app.Get("/docs", func(ctx iris.Context) {})
+	assert.Nil(t, app.Build())
+
+	// 内省失败时 token 视为未激活，返回 401
+	req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	assert.Equal(t, http.StatusUnauthorized, serve(app, req).Code)
+}
@@ -0,0 +1,437 @@
+// 本地 JWT 校验中间件
+// 从签发方拉取并缓存 JWKS，在本地校验签名与 exp/nbf/iss/aud，不再逐个请求调用 hydra 内省接口
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	dt "DT-Go"
+	"DT-Go/errors"
+	"DT-Go/infra/dhttp"
+	"DT-Go/internal"
+	"DT-Go/utils"
+
+	"github.com/kataras/iris/v12/context"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultJWKSRefreshInterval JWKS 定期刷新间隔
+	DefaultJWKSRefreshInterval = 10 * time.Minute
+	// DefaultJWKSMinRefreshInterval 遇到未知 kid 时两次拉取 JWKS 的最小间隔，避免伪造 kid 打爆签发方
+	DefaultJWKSMinRefreshInterval = 10 * time.Second
+	// DefaultJWTLeeway 校验 exp/nbf 时允许的时钟偏差
+	DefaultJWTLeeway = 30 * time.Second
+)
+
+// JWTConfig 本地 JWT 校验配置
+type JWTConfig struct {
+	JWKSURL            string        // 为空时使用 hydra public 的 /.well-known/jwks.json
+	Issuer             string        // 为空时使用 hydra public 地址
+	Audience           []string      // token 的 aud 至少包含其中一个, 为空不校验
+	Leeway             time.Duration // 时钟偏差
+	RefreshInterval    time.Duration // JWKS 定期刷新间隔
+	MinRefreshInterval time.Duration // 未知 kid 触发刷新的最小间隔
+	DisableIssuerCheck bool          // 关闭 iss 校验
+	Scopes             []string      // 访问路由需要的权限范围
+}
+
+// NewJWTAuthentication 使用本地 JWT 校验替代 hydra 内省, 写入总线的字段与 NewAuthentication 一致
+func NewJWTAuthentication(conf ...JWTConfig) context.Handler {
+	var cg JWTConfig
+	if len(conf) > 0 {
+		cg = conf[0]
+	}
+	verifier := newJWTVerifier(cg)
+	return func(ctx dt.Context) {
+		language := utils.ParseXLanguage(ctx.GetHeader("x-language"))
+		token, err := parseBearerToken(ctx.Request())
+		if err != nil {
+			errorResponse(err, ctx)
+			return
+		}
+		result, verifyErr := verifier.verify(token)
+		if verifyErr != nil {
+			errorResponse(verifyErr.apiError(language), ctx)
+			return
+		}
+		if missing := missingScopes(result.Scope, cg.Scopes); len(missing) > 0 {
+			errorResponse(errors.New(language, errors.ForbiddenErr, "insufficient scope.", map[string]interface{}{"required_scopes": missing}), ctx)
+			return
+		}
+		ctx.Values().Set(ScopeKey, result.Scope)
+		worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
+		fillBus(worker, token, language, result)
+		ctx.Next()
+	}
+}
+
+// jwtError 校验失败原因
+type jwtError struct {
+	code  int
+	cause string
+}
+
+func (e *jwtError) Error() string {
+	return e.cause
+}
+
+func (e *jwtError) apiError(language string) errors.APIError {
+	return errors.New(language, e.code, e.cause, nil)
+}
+
+func unauthorized(format string, args ...interface{}) *jwtError {
+	return &jwtError{code: errors.UnauthorizedErr, cause: fmt.Sprintf(format, args...)}
+}
+
+// jwtVerifier .
+type jwtVerifier struct {
+	conf JWTConfig
+	jwks *jwksCache
+}
+
+func newJWTVerifier(conf JWTConfig) *jwtVerifier {
+	if conf.JWKSURL == "" {
+		conf.JWKSURL = getHydraPublicEndpoint("/.well-known/jwks.json")
+	}
+	if conf.Issuer == "" {
+		conf.Issuer = getHydraPublicEndpoint("/")
+	}
+	if conf.Leeway == 0 {
+		conf.Leeway = DefaultJWTLeeway
+	}
+	if conf.RefreshInterval == 0 {
+		conf.RefreshInterval = DefaultJWKSRefreshInterval
+	}
+	if conf.MinRefreshInterval == 0 {
+		conf.MinRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+	return &jwtVerifier{
+		conf: conf,
+		jwks: &jwksCache{
+			url:                conf.JWKSURL,
+			refreshInterval:    conf.RefreshInterval,
+			minRefreshInterval: conf.MinRefreshInterval,
+			keys:               make(map[string]crypto.PublicKey),
+		},
+	}
+}
+
+// jwtHeader .
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// jwtClaims hydra 签发的 JWT access token 中的声明
+type jwtClaims struct {
+	Issuer    string                 `json:"iss"`
+	Subject   string                 `json:"sub"`
+	Audience  audience               `json:"aud"`
+	ExpiresAt int64                  `json:"exp"`
+	NotBefore int64                  `json:"nbf"`
+	IssuedAt  int64                  `json:"iat"`
+	ClientID  string                 `json:"client_id"`
+	Scope     string                 `json:"scope"`
+	Scp       []string               `json:"scp"`
+	Extra     map[string]interface{} `json:"ext"`
+}
+
+// audience 兼容 aud 为字符串或字符串数组
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+// verify 校验签名与声明, 返回与内省结果相同结构的身份信息
+func (v *jwtVerifier) verify(token string) (result Introspection, err *jwtError) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return result, unauthorized("invalid token.")
+	}
+	var header jwtHeader
+	if e := decodeSegment(parts[0], &header); e != nil {
+		return result, unauthorized("invalid token header.")
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return result, unauthorized("unsupported signing algorithm: %s.", header.Alg)
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil {
+		return result, unauthorized("invalid token signature.")
+	}
+	key, e := v.jwks.key(header.Kid)
+	if e != nil {
+		if jerr, ok := e.(*jwtError); ok {
+			return result, jerr
+		}
+		return result, &jwtError{code: errors.InternalErr, cause: fmt.Sprintf("fetch jwks failed: %v", e)}
+	}
+	if e = verifySignature(header.Alg, hash, key, parts[0]+"."+parts[1], signature); e != nil {
+		return result, unauthorized("invalid token signature.")
+	}
+
+	var claims jwtClaims
+	if e := decodeSegment(parts[1], &claims); e != nil {
+		return result, unauthorized("invalid token claims.")
+	}
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.conf.Leeway)) {
+		return result, unauthorized("access token expired.")
+	}
+	if claims.NotBefore != 0 && now.Add(v.conf.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return result, unauthorized("access token not yet valid.")
+	}
+	if !v.conf.DisableIssuerCheck && strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(v.conf.Issuer, "/") {
+		return result, unauthorized("invalid token issuer.")
+	}
+	if len(v.conf.Audience) > 0 && !utils.HasIntersection(v.conf.Audience, claims.Audience) {
+		return result, unauthorized("invalid token audience.")
+	}
+
+	scope := claims.Scope
+	if scope == "" {
+		scope = strings.Join(claims.Scp, " ")
+	}
+	result = Introspection{
+		Active:    true,
+		Audience:  claims.Audience,
+		ClientID:  claims.ClientID,
+		ExpiresAt: claims.ExpiresAt,
+		Extra:     claims.Extra,
+		IssuedAt:  claims.IssuedAt,
+		IssuerURL: claims.Issuer,
+		NotBefore: claims.NotBefore,
+		Scope:     scope,
+		Subject:   claims.Subject,
+		TokenType: "access_token",
+	}
+	return result, nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signingInput string, signature []byte) error {
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch")
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch")
+		}
+		return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm")
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwksCache 缓存签发方公钥, 定期刷新, 遇到未知 kid 时按最小间隔重新拉取以支持密钥轮换
+type jwksCache struct {
+	url                string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time // 最近一次拉取成功的时间
+	attemptedAt time.Time // 最近一次拉取的时间, 拉取失败时同样记录
+	group       singleflight.Group
+}
+
+// key 返回 kid 对应的公钥
+func (c *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fetchedAt, attemptedAt := c.fetchedAt, c.attemptedAt
+	c.mu.RUnlock()
+
+	if ok && time.Since(fetchedAt) < c.refreshInterval {
+		return key, nil
+	}
+	// 距上次拉取不足最小间隔时不再拉取, 签发方不可用时同样限制
+	if !attemptedAt.IsZero() && time.Since(attemptedAt) < c.minRefreshInterval {
+		if ok {
+			return key, nil
+		}
+		return nil, unauthorized("unknown signing key: %s.", kid)
+	}
+	if _, err, _ := c.group.Do("jwks", func() (interface{}, error) {
+		return nil, c.refresh()
+	}); err != nil {
+		if ok {
+			// 刷新失败时继续使用已缓存的公钥
+			dt.Logger().Errorf("refresh jwks failed, url: %s, err: %v", c.url, err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key, ok = c.keys[kid]; !ok {
+		return nil, unauthorized("unknown signing key: %s.", kid)
+	}
+	return key, nil
+}
+
+// refresh 拉取 JWKS
+func (c *jwksCache) refresh() error {
+	c.mu.Lock()
+	c.attemptedAt = time.Now()
+	c.mu.Unlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	resp := dhttp.NewHTTPRequest(c.url).Get().ToJSON(&set)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("fetch jwks failed, status code is %d", resp.StatusCode)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			dt.Logger().Warnf("skip jwk, kid: %s, err: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// jsonWebKey RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
+
+func getHydraPublicEndpoint(path string) string {
+	cg := dt.NewConfiguration()
+	url := url.URL{
+		Scheme: cg.DS.HydraPublicProtocol,
+		Host:   fmt.Sprintf("%v:%v", cg.DS.HydraPublicHost, cg.DS.HydraPublicPort),
+	}
+	url.Path = path
+	return url.String()
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"DT-Go/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIssuer = "https://hydra.example.com/"
+
+// jwksServer 可替换密钥集合与返回状态的 JWKS 服务
+type jwksServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	keys   []map[string]string
+	status int
+	hits   int32
+}
+
+func newJWKSServer(keys ...map[string]string) *jwksServer {
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.WriteHeader(s.status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	return s
+}
+
+func (s *jwksServer) set(status int, keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.keys = status, keys
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()),
+		"e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))),
+		"y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signToken 按 alg 签名, key 为 *rsa.PrivateKey 或 *ecdsa.PrivateKey
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	hash := jwtHashes[alg]
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg[:2] == "PS" {
+			signature, err = rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	assert.Nil(t, err)
+	return input + "." + b64(signature)
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":       testIssuer,
+		"sub":       "user-1",
+		"aud":       []string{"order"},
+		"exp":       now.Add(time.Hour).Unix(),
+		"nbf":       now.Add(-time.Minute).Unix(),
+		"iat":       now.Unix(),
+		"client_id": "client-1",
+		"scope":     "order.read order.write",
+	}
+}
+
+func newTestVerifier(url string) *jwtVerifier {
+	return newJWTVerifier(JWTConfig{
+		JWKSURL:            url,
+		Issuer:             testIssuer,
+		Audience:           []string{"order"},
+		Leeway:             time.Second,
+		MinRefreshInterval: time.Hour,
+	})
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := newJWKSServer(rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey))
+	defer server.Close()
+	v := newTestVerifier(server.URL)
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	now := time.Now()
+
+	for _, alg := range []string{"RS256", "RS512", "PS256"} {
+		result, err := v.verify(signToken(t, alg, "rsa", rsaKey, validClaims()))
+		assert.Nil(t, err, alg)
+		assert.Equal(t, "user-1", result.Subject)
+		assert.Equal(t, "order.read order.write", result.Scope)
+	}
+	result, err := v.verify(signToken(t, "ES256", "ec", ecKey, with("aud", "order")))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"order"}, result.Audience)
+
+	cases := map[string]struct {
+		token string
+		cause string
+	}{
+		"bad signature":    {signToken(t, "RS256", "rsa", otherKey, validClaims()), "invalid token signature."},
+		"alg key mismatch": {signToken(t, "ES256", "rsa", ecKey, validClaims()), "invalid token signature."},
+		"alg RS as PS":     {signAs(t, "RS256", "PS256", rsaKey), "invalid token signature."},
+		"alg none":         {signToken(t, "none", "rsa", rsaKey, validClaims()), "unsupported signing algorithm: none."},
+		"expired":          {signToken(t, "RS256", "rsa", rsaKey, with("exp", now.Add(-time.Minute).Unix())), "access token expired."},
+		"missing exp":      {signToken(t, "RS256", "rsa", rsaKey, with("exp", nil)), "access token expired."},
+		"not yet valid":    {signToken(t, "RS256", "rsa", rsaKey, with("nbf", now.Add(time.Minute).Unix())), "access token not yet valid."},
+		"wrong issuer":     {signToken(t, "RS256", "rsa", rsaKey, with("iss", "https://evil.example.com/")), "invalid token issuer."},
+		"wrong audience":   {signToken(t, "RS256", "rsa", rsaKey, with("aud", []string{"user"})), "invalid token audience."},
+		"unknown kid":      {signToken(t, "RS256", "forged", rsaKey, validClaims()), "unknown signing key: forged."},
+		"malformed":        {"a.b", "invalid token."},
+	}
+	for name, c := range cases {
+		_, err := v.verify(c.token)
+		if assert.NotNil(t, err, name) {
+			assert.Equal(t, errors.UnauthorizedErr, err.code, name)
+			assert.Equal(t, c.cause, err.cause, name)
+		}
+	}
+
+	// 未知 kid 在最小间隔内不重复拉取
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.hits))
+}
+
+// signAs 以 signAlg 签名后把头部的 alg 改为 headerAlg
+func signAs(t *testing.T, signAlg, headerAlg string, key *rsa.PrivateKey) string {
+	token := signToken(t, signAlg, "rsa", key, validClaims())
+	header, _ := json.Marshal(map[string]string{"alg": headerAlg, "kid": "rsa", "typ": "JWT"})
+	parts := strings.Split(token, ".")
+	return b64(header) + "." + parts[1] + "." + parts[2]
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := newJWKSServer(rsaJWK("k1", oldKey))
+	defer server.Close()
+	v := newTestVerifier(server.URL)
+	v.jwks.minRefreshInterval = 50 * time.Millisecond
+
+	_, err := v.verify(signToken(t, "RS256", "k1", oldKey, validClaims()))
+	assert.Nil(t, err)
+
+	// 签发方轮换密钥, 新 kid 触发重新拉取
+	server.set(http.StatusOK, rsaJWK("k2", newKey))
+	time.Sleep(60 * time.Millisecond)
+	_, err = v.verify(signToken(t, "RS256", "k2", newKey, validClaims()))
+	assert.Nil(t, err)
+	_, err = v.verify(signToken(t, "RS256", "k1", oldKey, validClaims()))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.hits))
+}
+
+func TestJWKSRefreshFailure(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	server := newJWKSServer(rsaJWK("k1", key))
+	defer server.Close()
+	v := newTestVerifier(server.URL)
+	v.jwks.refreshInterval = time.Millisecond
+	v.jwks.minRefreshInterval = time.Hour
+
+	_, err := v.verify(signToken(t, "RS256", "k1", key, validClaims()))
+	assert.Nil(t, err)
+
+	// 签发方不可用时使用已缓存的公钥, 且按最小间隔限制拉取次数
+	server.set(http.StatusInternalServerError)
+	v.jwks.mu.Lock()
+	v.jwks.attemptedAt = time.Time{}
+	v.jwks.mu.Unlock()
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		_, err = v.verify(signToken(t, "RS256", "k1", key, validClaims()))
+		assert.Nil(t, err)
+		_, err = v.verify(signToken(t, "RS256", "forged", key, validClaims()))
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&server.hits))
+}
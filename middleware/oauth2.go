@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	dt "DT-Go"
 	"DT-Go/errors"
@@ -33,7 +34,25 @@ type Introspection struct {
 	Username          string                 `json:"username,omitempty"`           // Username is a human-readable identifier for the resource owner who authorized this token.
 }
 
+// AuthenticationConfig 认证中间件配置
+type AuthenticationConfig struct {
+	// Scopes 访问路由或 Party 需要的权限范围，token 须包含全部 scope
+	Scopes []string
+	// CacheTTL 内省结果在进程内的最长缓存时间，不超过 token 的 exp，为0时不缓存
+	CacheTTL time.Duration
+	// RedisCache 同时将内省结果缓存到 redis，供其它 pod 复用
+	RedisCache bool
+}
+
+// NewAuthentication token 内省中间件，每个请求调用一次 hydra 内省接口
 func NewAuthentication() context.Handler {
+	return NewAuthenticationWithConfig(AuthenticationConfig{})
+}
+
+// NewAuthenticationWithConfig 可配置的 token 内省中间件
+// 内省结果按 token 哈希缓存，并发的相同 token 内省通过 singleflight 合并为一次调用
+func NewAuthenticationWithConfig(conf AuthenticationConfig) context.Handler {
+	cache := newIntrospectionCache(conf.CacheTTL, conf.RedisCache)
 	return func(ctx dt.Context) {
 		language := utils.ParseXLanguage(ctx.GetHeader("x-language"))
 		token, err := parseBearerToken(ctx.Request())
@@ -41,7 +60,8 @@ func NewAuthentication() context.Handler {
 			errorResponse(err, ctx)
 			return
 		}
-		result, introErr := introspection(token, nil)
+		worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
+		result, introErr := cache.introspect(worker, token)
 		if !result.Active {
 			err = errors.New(language, errors.UnauthorizedErr, "access token expired.", nil)
 			errorResponse(err, ctx)
@@ -52,37 +72,60 @@ func NewAuthentication() context.Handler {
 			errorResponse(err, ctx)
 			return
 		}
-		worker := ctx.Values().Get(internal.WorkerKey).(internal.Worker)
-		worker.Bus().Add("user_id", result.Subject)
-		worker.Bus().Add("client_id", result.ClientID)
-		worker.Bus().Add("bearer_token", token)
-		worker.Bus().Add("language", language)
-		var accountType string
-		if result.Extra != nil {
-			if str, ok := result.Extra["login_ip"].(string); ok {
-				worker.Bus().Add("ip", str)
+		if missing := missingScopes(result.Scope, conf.Scopes); len(missing) > 0 {
+			err = errors.New(language, errors.ForbiddenErr, "insufficient scope.", map[string]interface{}{"required_scopes": missing})
+			errorResponse(err, ctx)
+			return
+		}
+		ctx.Values().Set(ScopeKey, result.Scope)
+		fillBus(worker, token, language, result)
+		ctx.Next()
+	}
+}
+
+// RequireScopes 校验认证中间件写入的 token scope，用于在 Party 或路由上追加权限范围要求
+func RequireScopes(scopes ...string) context.Handler {
+	return func(ctx dt.Context) {
+		if missing := missingScopes(ctx.Values().GetString(ScopeKey), scopes); len(missing) > 0 {
+			language := utils.ParseXLanguage(ctx.GetHeader("x-language"))
+			err := errors.New(language, errors.ForbiddenErr, "insufficient scope.", map[string]interface{}{"required_scopes": missing})
+			errorResponse(err, ctx)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// fillBus 将 token 的身份信息写入总线
+func fillBus(worker internal.Worker, token, language string, result Introspection) {
+	worker.Bus().Add("user_id", result.Subject)
+	worker.Bus().Add("client_id", result.ClientID)
+	worker.Bus().Add("bearer_token", token)
+	worker.Bus().Add("language", language)
+	var accountType string
+	if result.Extra != nil {
+		if str, ok := result.Extra["login_ip"].(string); ok {
+			worker.Bus().Add("ip", str)
+		}
+		var cType, udid, visitorType string
+		if result.ClientID != result.Subject {
+			if str, ok := result.Extra["client_type"].(string); ok {
+				cType = str
+			}
+			if str, ok := result.Extra["udid"].(string); ok {
+				udid = str
 			}
-			var cType, udid, visitorType string
-			if result.ClientID != result.Subject {
-				if str, ok := result.Extra["client_type"].(string); ok {
-					cType = str
-				}
-				if str, ok := result.Extra["udid"].(string); ok {
-					udid = str
-				}
-				if v, ok := result.Extra["visitor_type"].(string); ok {
-					// realname anonymous
-					visitorType = v
-				}
-				accountType = "user"
+			if v, ok := result.Extra["visitor_type"].(string); ok {
+				// realname anonymous
+				visitorType = v
 			}
-			worker.Bus().Add("client_type", cType)
-			worker.Bus().Add("udid", udid)
-			worker.Bus().Add("visitor_type", visitorType)
+			accountType = "user"
 		}
-		worker.Bus().Add("account_type", accountType)
-		ctx.Next()
+		worker.Bus().Add("client_type", cType)
+		worker.Bus().Add("udid", udid)
+		worker.Bus().Add("visitor_type", visitorType)
 	}
+	worker.Bus().Add("account_type", accountType)
 }
 
 func getIntrospectEndpoint() string {
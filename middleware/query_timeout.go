@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"time"
+
+	dt "DT-Go"
+
+	"github.com/kataras/iris/v12/context"
+)
+
+// NewQueryTimeout 路由级别的 SQL 超时时间，作用于 Repository.FetchDB 获取的连接，可在 BindController/CreateParty 时挂载
+func NewQueryTimeout(timeout time.Duration) context.Handler {
+	return func(ctx dt.Context) {
+		dt.SetQueryTimeout(dt.ToWorker(ctx), timeout)
+		ctx.Next()
+	}
+}
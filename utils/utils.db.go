@@ -4,13 +4,15 @@ package utils
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -24,54 +26,164 @@ var (
 	rwdbOnce sync.Once
 	// db gorm数据库连接池对象
 	db *gorm.DB
+	// resolver 读写分离插件
+	resolver *replicaResolver
+
+	namedMu sync.Mutex
+	// namedDBs 命名数据源的连接池
+	namedDBs = make(map[string]*namedDB)
 )
 
+type namedDB struct {
+	db       *gorm.DB
+	resolver *replicaResolver
+}
+
 // ConnectDB return a db conn pool.
 func ConnectDB(conf *config.DBConfiguration) *gorm.DB {
 	dbOnce.Do(func() {
-		var err error
-		switch conf.Driver {
-		case "mysql":
-			if conf.DBName == "" {
-				panic(fmt.Errorf("Invalid database name"))
-			}
-			dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=%s&loc=Local&timeout=%dms",
-				conf.User, conf.Pwd, conf.Host, conf.Port, conf.DBName, conf.Charset, strconv.FormatBool(conf.ParseTime), conf.Timeout)
-			ormconf := gorm.Config{}
-			if conf.PrintSqlLog {
-				loggerNew := logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
-					SlowThreshold:             time.Duration(conf.SlowSqlTime) * time.Millisecond, //慢SQL阈值
-					LogLevel:                  logger.Warn,
-					Colorful:                  false, // 彩色打印开启
-					IgnoreRecordNotFoundError: true,
-				})
-				ormconf.Logger = loggerNew
-			}
-			db, err = gorm.Open(mysql.Open(dsn), &ormconf)
-			if err != nil {
-				panic(err)
-			}
-			var opt *sql.DB
-			opt, err = db.DB()
-			if err != nil {
-				panic(err)
-			}
-			opt.SetMaxIdleConns(conf.MaxIdleConns)
-			opt.SetMaxOpenConns(conf.MaxOpenConns)
-		case "sqlite3":
-			dsn := os.Getenv("DB_URL")
-			db, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{})
-			if err != nil {
-				panic(err)
-			}
-		}
+		db, resolver = connect(conf)
 		dt.Logger().Infof("connect database success...")
 	})
 	return db
 }
 
+// ConnectNamedDB return the db conn pool of the named datasource, used with Application.InstallDB(f, name).
+// 每个数据源只连接一次，name 为空或 default 时同 ConnectDB
+func ConnectNamedDB(name string, conf *config.DBConfiguration) *gorm.DB {
+	if name == "" || name == dt.DefaultDataSource {
+		return ConnectDB(conf)
+	}
+	namedMu.Lock()
+	defer namedMu.Unlock()
+	if n, ok := namedDBs[name]; ok {
+		return n.db
+	}
+	n := &namedDB{}
+	n.db, n.resolver = connect(conf)
+	namedDBs[name] = n
+	dt.Logger().Infof("connect database %s success...", name)
+	return n.db
+}
+
+// connect 安装超时插件，配置了 Replicas 时安装读写分离插件
+func connect(conf *config.DBConfiguration) (*gorm.DB, *replicaResolver) {
+	gdb := openDB(conf)
+	if err := gdb.Use(NewQueryTimeout(time.Duration(conf.QueryTimeout) * time.Millisecond)); err != nil {
+		panic(err)
+	}
+	if len(conf.Replicas) == 0 {
+		return gdb, nil
+	}
+	r := newReplicaResolver(conf)
+	if err := gdb.Use(r); err != nil {
+		panic(err)
+	}
+	return gdb, r
+}
+
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectDM8      = "dm8"
+	DialectSQLite   = "sqlite"
+)
+
+// Dialect 数据库方言, sqlite3 驱动为 sqlite, 否则由 Type 决定, Type 为空时由 Driver 决定
+func Dialect(conf *config.DBConfiguration) string {
+	if conf.Driver == "sqlite3" {
+		return DialectSQLite
+	}
+	t := conf.Type
+	if t == "" {
+		t = conf.Driver
+	}
+	switch strings.ToLower(t) {
+	case "", "mysql", "mariadb":
+		return DialectMySQL
+	case "postgres", "postgresql", "pg", "pgx":
+		return DialectPostgres
+	case "dm", "dm8":
+		return DialectDM8
+	}
+	return strings.ToLower(t)
+}
+
+// driverName Driver 为 proton-rds 等自定义驱动时使用该驱动, 否则使用方言的默认驱动
+func driverName(conf *config.DBConfiguration) string {
+	switch strings.ToLower(conf.Driver) {
+	case "", "mysql", "mariadb", "postgres", "postgresql", "pg", "pgx", "dm", "dm8":
+		return ""
+	}
+	return conf.Driver
+}
+
+// openDB .
+func openDB(conf *config.DBConfiguration) (gdb *gorm.DB) {
+	var err error
+	var dialector gorm.Dialector
+	logConf := logger.Config{SlowThreshold: 200 * time.Millisecond, LogLevel: logger.Warn}
+	if conf.PrintSqlLog {
+		logConf.SlowThreshold = time.Duration(conf.SlowSqlTime) * time.Millisecond //慢SQL阈值
+		logConf.IgnoreRecordNotFoundError = true
+	}
+	ormconf := gorm.Config{Logger: NewDBLogger(logConf)}
+	switch Dialect(conf) {
+	case DialectSQLite:
+		dsn := os.Getenv("DB_URL")
+		gdb, err = gorm.Open(sqlite.Open(dsn), &ormconf)
+		if err != nil {
+			panic(err)
+		}
+		return
+	case DialectMySQL:
+		if conf.DBName == "" {
+			panic(fmt.Errorf("Invalid database name"))
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=%s&loc=Local&timeout=%dms",
+			conf.User, conf.Pwd, conf.Host, conf.Port, conf.DBName, conf.Charset, strconv.FormatBool(conf.ParseTime), conf.Timeout)
+		dialector = mysql.New(mysql.Config{DriverName: driverName(conf), DSN: dsn})
+	case DialectPostgres:
+		if conf.DBName == "" {
+			panic(fmt.Errorf("Invalid database name"))
+		}
+		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=%d",
+			conf.Host, conf.Port, conf.User, conf.Pwd, conf.DBName, (conf.Timeout+999)/1000)
+		if conf.Timezone != "" {
+			dsn += " TimeZone=" + conf.Timezone
+		}
+		dialector = postgres.New(postgres.Config{DriverName: driverName(conf), DSN: dsn})
+	case DialectDM8:
+		// 达梦的模式与用户对应, DBName 作为模式
+		dsn := fmt.Sprintf("dm://%s:%s@%s:%d?connectTimeout=%d", url.PathEscape(conf.User), url.PathEscape(conf.Pwd), conf.Host, conf.Port, conf.Timeout)
+		if conf.DBName != "" {
+			dsn += "&schema=" + url.QueryEscape(conf.DBName)
+		}
+		dialector = NewDMDialector(driverName(conf), dsn)
+	default:
+		panic(fmt.Errorf("unsupported database type: %s, driver: %s", conf.Type, conf.Driver))
+	}
+
+	gdb, err = gorm.Open(dialector, &ormconf)
+	if err != nil {
+		panic(err)
+	}
+	var opt *sql.DB
+	opt, err = gdb.DB()
+	if err != nil {
+		panic(err)
+	}
+	opt.SetMaxIdleConns(conf.MaxIdleConns)
+	opt.SetMaxOpenConns(conf.MaxOpenConns)
+	return
+}
+
 // DisconnectDB .
 func DisconnectDB() error {
+	if resolver != nil {
+		resolver.close()
+		resolver = nil
+	}
 	if db != nil {
 		opt, _ := db.DB()
 		err := opt.Close()
@@ -80,3 +192,22 @@ func DisconnectDB() error {
 	}
 	return nil
 }
+
+// DisconnectNamedDB .
+func DisconnectNamedDB(name string) error {
+	if name == "" || name == dt.DefaultDataSource {
+		return DisconnectDB()
+	}
+	namedMu.Lock()
+	defer namedMu.Unlock()
+	n, ok := namedDBs[name]
+	if !ok {
+		return nil
+	}
+	delete(namedDBs, name)
+	if n.resolver != nil {
+		n.resolver.close()
+	}
+	opt, _ := n.db.DB()
+	return opt.Close()
+}
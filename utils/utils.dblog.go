@@ -0,0 +1,124 @@
+// 数据库 SQL 日志
+package utils
+
+/*
+	1.ConnectDB 与 ConnectNamedDB 使用该日志，Repository.FetchDB 获取的连接通过 Worker.Logger() 输出，携带请求的 trace id
+	2.Worker 以外的连接通过 dt.Logger() 输出
+	3.慢 SQL 与执行出错的 SQL 以 sql_caller 字段附带调用方的位置
+
+	print_sql_log: true
+	slow_sql_time: 200
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/kataras/golog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	dt "DT-Go"
+)
+
+// dbLogger gorm 日志
+type dbLogger struct {
+	logger.Config
+}
+
+type leveledLogger interface {
+	Errorf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+}
+
+// NewDBLogger .
+func NewDBLogger(conf logger.Config) logger.Interface {
+	return &dbLogger{Config: conf}
+}
+
+// LogMode .
+func (l *dbLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *l
+	newLogger.LogLevel = level
+	return &newLogger
+}
+
+// Info .
+func (l *dbLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Info {
+		loggerFrom(ctx).Infof(msg, data...)
+	}
+}
+
+// Warn .
+func (l *dbLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Warn {
+		loggerFrom(ctx).Warnf(msg, data...)
+	}
+}
+
+// Error .
+func (l *dbLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Error {
+		loggerFrom(ctx).Errorf(msg, data...)
+	}
+}
+
+// Trace 出错、慢 SQL 与 Info 级别时输出 SQL
+func (l *dbLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.LogLevel <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.LogLevel >= logger.Error && (!errors.Is(err, gorm.ErrRecordNotFound) || !l.IgnoreRecordNotFoundError):
+		sql, rows := fc()
+		loggerFrom(ctx).Errorf("%s [%.3fms] [rows:%v] %s", err, duration(elapsed), rowsString(rows), sql, sqlCaller())
+	case l.SlowThreshold != 0 && elapsed > l.SlowThreshold && l.LogLevel >= logger.Warn:
+		sql, rows := fc()
+		loggerFrom(ctx).Warnf("SLOW SQL >= %v [%.3fms] [rows:%v] %s", l.SlowThreshold, duration(elapsed), rowsString(rows), sql, sqlCaller())
+	case l.LogLevel == logger.Info:
+		sql, rows := fc()
+		loggerFrom(ctx).Infof("[%.3fms] [rows:%v] %s", duration(elapsed), rowsString(rows), sql)
+	}
+}
+
+// loggerFrom FetchDB 获取的连接使用 Worker 的日志
+func loggerFrom(ctx context.Context) leveledLogger {
+	if worker := dt.WorkerFrom(ctx); worker != nil {
+		return worker.Logger()
+	}
+	return dt.Logger()
+}
+
+func duration(elapsed time.Duration) float64 {
+	return float64(elapsed.Nanoseconds()) / 1e6
+}
+
+func rowsString(rows int64) string {
+	if rows == -1 {
+		return "-"
+	}
+	return fmt.Sprint(rows)
+}
+
+// sqlCaller 跳过 gorm 与日志自身，返回执行 SQL 的调用方；Worker 日志会追加自己的 caller，因此使用 sql_caller 避免被覆盖
+func sqlCaller() golog.Fields {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "gorm.io/") && !strings.HasPrefix(frame.Function, "DT-Go/utils.(*dbLogger)") {
+			return golog.Fields{"sql_caller": fmt.Sprintf("%s:%d", path.Base(frame.File), frame.Line)}
+		}
+		if !more {
+			return golog.Fields{}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// 达梦(DM8)数据库的 gorm 方言
+// 驱动需由服务引入, 如 import _ "gitee.com/chunanyong/dm", 驱动名默认为 dm
+package utils
+
+import (
+	"database/sql"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/migrator"
+	"gorm.io/gorm/schema"
+)
+
+// DMDialector 达梦方言, 标识符使用双引号, 占位符为 ?
+type DMDialector struct {
+	DriverName string
+	DSN        string
+	Conn       gorm.ConnPool
+}
+
+// NewDMDialector .
+func NewDMDialector(driverName, dsn string) gorm.Dialector {
+	if driverName == "" {
+		driverName = "dm"
+	}
+	return &DMDialector{DriverName: driverName, DSN: dsn}
+}
+
+// Name .
+func (d *DMDialector) Name() string {
+	return DialectDM8
+}
+
+// Initialize .
+func (d *DMDialector) Initialize(db *gorm.DB) (err error) {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{
+		CreateClauses: []string{"INSERT", "VALUES"},
+		UpdateClauses: []string{"UPDATE", "SET", "WHERE"},
+		DeleteClauses: []string{"DELETE", "FROM", "WHERE"},
+	})
+	if d.Conn != nil {
+		db.ConnPool = d.Conn
+		return
+	}
+	db.ConnPool, err = sql.Open(d.DriverName, d.DSN)
+	return
+}
+
+// Migrator .
+func (d *DMDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return migrator.Migrator{Config: migrator.Config{DB: db, Dialector: d}}
+}
+
+// DataTypeOf .
+func (d *DMDialector) DataTypeOf(field *schema.Field) string {
+	switch field.DataType {
+	case schema.Bool:
+		return "BIT"
+	case schema.Int, schema.Uint:
+		sqlType := "BIGINT"
+		switch {
+		case field.Size <= 8:
+			sqlType = "TINYINT"
+		case field.Size <= 16:
+			sqlType = "SMALLINT"
+		case field.Size <= 32:
+			sqlType = "INT"
+		}
+		if field.AutoIncrement {
+			sqlType += " IDENTITY(1,1)"
+		}
+		return sqlType
+	case schema.Float:
+		if field.Size <= 32 {
+			return "FLOAT"
+		}
+		return "DOUBLE"
+	case schema.String:
+		size := field.Size
+		if size == 0 {
+			size = 256
+		}
+		if size > 8188 {
+			return "CLOB"
+		}
+		return "VARCHAR(" + strconv.Itoa(size) + ")"
+	case schema.Time:
+		return "TIMESTAMP"
+	case schema.Bytes:
+		return "BLOB"
+	}
+	return string(field.DataType)
+}
+
+// DefaultValueOf .
+func (d *DMDialector) DefaultValueOf(field *schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+// BindVarTo .
+func (d *DMDialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	writer.WriteByte('?')
+}
+
+// QuoteTo "schema"."table"
+func (d *DMDialector) QuoteTo(writer clause.Writer, str string) {
+	writer.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '.':
+			writer.WriteString(`"."`)
+		case '"':
+			writer.WriteString(`""`)
+		default:
+			writer.WriteByte(str[i])
+		}
+	}
+	writer.WriteByte('"')
+}
+
+// Explain .
+func (d *DMDialector) Explain(sql string, vars ...interface{}) string {
+	return logger.ExplainSQL(sql, nil, `'`, vars...)
+}
@@ -40,6 +40,46 @@ func InitOauthHTTPClient(svcName string, conf config.Configurations) {
 	return
 }
 
+// InitOauthHTTPClients 按 conf.DS.Oauth2Clients 为每个下游注册独立的凭据
+// 未配置 client_id 的下游使用服务注册的应用账户
+func InitOauthHTTPClients(svcName string, conf config.Configurations) {
+	if conf.DS == nil || len(conf.DS.Oauth2Clients) == 0 {
+		return
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConnsPerHost:   100,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}}
+	var svcClientID, svcSecret string
+	for _, downstream := range conf.DS.Oauth2Clients {
+		cred := dhttp.Oauth2Credential{
+			ClientID:     downstream.ClientID,
+			ClientSecret: downstream.ClientSecret,
+			TokenURL:     downstream.TokenURL,
+			Audience:     downstream.Audience,
+			Scopes:       downstream.Scopes,
+		}
+		if cred.ClientID == "" {
+			if svcClientID == "" {
+				svcClientID, svcSecret = clientInfo(svcName, conf)
+			}
+			cred.ClientID, cred.ClientSecret = svcClientID, svcSecret
+		}
+		if cred.TokenURL == "" {
+			cred.TokenURL = tokenEndpoint()
+		}
+		if downstream.TokenExchange {
+			dhttp.RegisterTokenExchanger(cred, client)
+			continue
+		}
+		dhttp.RegisterOauth2Client(cred, client)
+	}
+}
+
 func tokenEndpoint() string {
 	cg := config.NewConfiguration().DS
 	url := url.URL{
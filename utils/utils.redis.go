@@ -58,6 +58,24 @@ func ConnectRedis(conf config.RedisConfiguration) (client redis.Cmdable) {
 	return
 }
 
+// ConnectRedlockNodes return clients of conf.RedlockNodes for dlm.InstallRedlock.
+// Unlike ConnectRedis it does not wait for nodes to be available, a lock only needs a quorum of nodes.
+func ConnectRedlockNodes(conf config.RedisConfiguration) (clients []redis.Cmdable) {
+	for _, node := range conf.RedlockNodes {
+		switch node.ConnectType {
+		case "master-slave":
+			clients = append(clients, masterSlave(*node))
+		case "sentinel":
+			clients = append(clients, sentinel(*node))
+		case "cluster":
+			clients = append(clients, cluster(*node))
+		default:
+			clients = append(clients, standalone(*node))
+		}
+	}
+	return
+}
+
 // masterSlave 主从模式
 func masterSlave(conf config.RedisConfiguration) *redis.Client {
 	if conf.MasterHost == "" {
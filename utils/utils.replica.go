@@ -0,0 +1,287 @@
+// 数据库读写分离
+package utils
+
+/*
+	1.配置 replicas 后 ConnectDB 与 ConnectNamedDB 安装读写分离插件，Repository.FetchDB 获取的连接在事务外的读取按轮询路由到可用的副本
+	2.定时检查副本的连通性与复制延迟，不可用或延迟超过 max_replica_lag 的副本暂停使用，恢复后重新加入，没有可用副本时使用主库
+	3.Worker 写入或开启事务后，后续的读取使用主库；Repository.UsePrimary 可以强制使用主库
+	4.SELECT ... FOR UPDATE 等加锁读取，以及 SourceDB、FetchSourceDB 获取的连接始终使用主库
+
+	replicas:
+	  - db_host: mariadb-replica-0
+	  - db_host: mariadb-replica-1
+	max_replica_lag: 5
+*/
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	dt "DT-Go"
+	"DT-Go/config"
+)
+
+const (
+	defaultMaxReplicaLag        = 5 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+	replicaSourceKey            = "dt:replica:source"
+)
+
+// replica 只读副本
+type replica struct {
+	name      string
+	db        *sql.DB
+	available int32
+}
+
+func (r *replica) isAvailable() bool {
+	return atomic.LoadInt32(&r.available) == 1
+}
+
+// replicaResolver 读写分离的 gorm 插件
+type replicaResolver struct {
+	replicas []*replica
+	next     uint32
+	maxLag   time.Duration
+	interval time.Duration
+	checkLag bool // mysql 检查复制延迟
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newReplicaResolver 连接 conf.Replicas 中的副本
+func newReplicaResolver(conf *config.DBConfiguration) *replicaResolver {
+	replicas := make([]*replica, 0, len(conf.Replicas))
+	for _, rc := range conf.Replicas {
+		rconf := replicaConf(conf, rc)
+		opt, err := openDB(rconf).DB()
+		if err != nil {
+			panic(err)
+		}
+		replicas = append(replicas, &replica{name: fmt.Sprintf("%s:%d", rconf.Host, rconf.Port), db: opt})
+	}
+	return newResolver(replicas, time.Duration(conf.MaxReplicaLag)*time.Second,
+		time.Duration(conf.ReplicaCheckInterval)*time.Second, Dialect(conf) == DialectMySQL)
+}
+
+func newResolver(replicas []*replica, maxLag, interval time.Duration, checkLag bool) *replicaResolver {
+	if maxLag <= 0 {
+		maxLag = defaultMaxReplicaLag
+	}
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+	for _, r := range replicas {
+		r.available = 1
+	}
+	return &replicaResolver{replicas: replicas, maxLag: maxLag, interval: interval, checkLag: checkLag, stop: make(chan struct{})}
+}
+
+// replicaConf 副本未配置的连接参数使用主库的配置
+func replicaConf(primary, r *config.DBConfiguration) *config.DBConfiguration {
+	conf := *primary
+	conf.Replicas = nil
+	conf.Host = r.Host
+	if r.Port != 0 {
+		conf.Port = r.Port
+	}
+	if r.User != "" {
+		conf.User, conf.Pwd = r.User, r.Pwd
+	}
+	if r.DBName != "" {
+		conf.DBName = r.DBName
+	}
+	if r.MaxOpenConns != 0 {
+		conf.MaxOpenConns = r.MaxOpenConns
+	}
+	if r.MaxIdleConns != 0 {
+		conf.MaxIdleConns = r.MaxIdleConns
+	}
+	return &conf
+}
+
+// Name .
+func (r *replicaResolver) Name() string {
+	return dt.ReplicaPluginName
+}
+
+// Initialize 读取前选择副本、读取后恢复主库连接，写入后 Worker 切换到主库
+func (r *replicaResolver) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("dt:replica:query", r.route); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("dt:replica:row", r.route); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().After("gorm:query").Register("dt:replica:query_restore", r.restore); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("dt:replica:row_restore", r.restore); err != nil {
+		return err
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("dt:replica:create", r.written); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("dt:replica:update", r.written); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().After("gorm:delete").Register("dt:replica:delete", r.written); err != nil {
+		return err
+	}
+	if err := db.Callback().Raw().After("gorm:raw").Register("dt:replica:raw", r.written); err != nil {
+		return err
+	}
+	go r.checkLoop()
+	return nil
+}
+
+// route 事务外的读取使用副本
+func (r *replicaResolver) route(db *gorm.DB) {
+	routing := dt.DBRoutingFrom(db.Statement.Context)
+	if routing == nil || routing.Primary() {
+		return
+	}
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return
+	}
+	if _, ok := db.Statement.Clauses["FOR"]; ok {
+		return
+	}
+	if db.Statement.SQL.Len() > 0 && !isReadSQL(db.Statement.SQL.String()) {
+		return
+	}
+	if rep := r.pick(); rep != nil {
+		db.Statement.Settings.Store(replicaSourceKey, db.Statement.ConnPool)
+		db.Statement.ConnPool = rep.db
+	}
+}
+
+// restore 读取结束后恢复主库连接，链式调用复用同一个 Statement，之后的写入与事务不能落在副本上
+func (r *replicaResolver) restore(db *gorm.DB) {
+	if source, ok := db.Statement.Settings.LoadAndDelete(replicaSourceKey); ok {
+		db.Statement.ConnPool = source.(gorm.ConnPool)
+	}
+}
+
+// written 写入后该 Worker 的读取使用主库
+func (r *replicaResolver) written(db *gorm.DB) {
+	if routing := dt.DBRoutingFrom(db.Statement.Context); routing != nil {
+		routing.UsePrimary()
+	}
+}
+
+// pick 轮询可用的副本，没有可用副本时返回 nil
+func (r *replicaResolver) pick() *replica {
+	n := uint32(len(r.replicas))
+	start := atomic.AddUint32(&r.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; rep.isAvailable() {
+			return rep
+		}
+	}
+	return nil
+}
+
+func (r *replicaResolver) checkLoop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		for _, rep := range r.replicas {
+			r.check(rep)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check 检查副本的连通性与复制延迟
+func (r *replicaResolver) check(rep *replica) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+	err := rep.db.PingContext(ctx)
+	if err == nil && r.checkLag {
+		var lag time.Duration
+		if lag, err = replicationLag(ctx, rep.db); err == nil && lag > r.maxLag {
+			err = fmt.Errorf("replication lag %v exceeds %v", lag, r.maxLag)
+		}
+	}
+	if err != nil {
+		if atomic.CompareAndSwapInt32(&rep.available, 1, 0) {
+			dt.Logger().Warnf("replica %s is unavailable, err: %v", rep.name, err)
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&rep.available, 0, 1) {
+		dt.Logger().Infof("replica %s is available", rep.name)
+	}
+}
+
+func (r *replicaResolver) close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		for _, rep := range r.replicas {
+			rep.db.Close()
+		}
+	})
+}
+
+// replicationLag 读取 mysql 副本的复制延迟，未配置复制的节点返回 0
+func replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// mysql 8.0.22 之前的版本与 mariadb 10.5 之前的版本
+		if rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, errors.New("replication is not running")
+		}
+		var seconds int64
+		if _, err = fmt.Sscan(values[i].String, &seconds); err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, nil
+}
+
+// isReadSQL 不加锁的读取
+func isReadSQL(sql string) bool {
+	sql = strings.ToLower(strings.TrimLeft(sql, " \t\r\n("))
+	if !strings.HasPrefix(sql, "select") && !strings.HasPrefix(sql, "show") {
+		return false
+	}
+	return !strings.Contains(sql, "for update") && !strings.Contains(sql, "lock in share mode") && !strings.Contains(sql, "for share")
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"DT-Go/internal"
+)
+
+type replicaItem struct {
+	ID   int
+	Name string
+}
+
+func openReplicaTestDB(t *testing.T, name string) *gorm.DB {
+	gdb, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, gdb.AutoMigrate(&replicaItem{}))
+	assert.Nil(t, gdb.Where("1 = 1").Delete(&replicaItem{}).Error)
+	assert.Nil(t, gdb.Create(&replicaItem{ID: 1, Name: name}).Error)
+	return gdb
+}
+
+func TestReplicaRouting(t *testing.T) {
+	primary := openReplicaTestDB(t, "replica_primary")
+	replicaDB, _ := openReplicaTestDB(t, "replica_0").DB()
+	rep := &replica{name: "replica_0", db: replicaDB}
+	resolver := newResolver([]*replica{rep}, 0, time.Hour, false)
+	defer resolver.close()
+	assert.Nil(t, primary.Use(resolver))
+
+	read := func(db *gorm.DB) string {
+		var item replicaItem
+		assert.Nil(t, db.First(&item, 1).Error)
+		return item.Name
+	}
+
+	// 未经 FetchDB 获取的连接使用主库
+	assert.Equal(t, "replica_primary", read(primary))
+
+	routing := &internal.DBRouting{}
+	db := primary.WithContext(internal.WithDBRouting(context.Background(), routing))
+	assert.Equal(t, "replica_0", read(db))
+
+	var name string
+	assert.Nil(t, db.Raw("SELECT name FROM replica_items WHERE id = 1").Scan(&name).Error)
+	assert.Equal(t, "replica_0", name)
+
+	// 事务中使用主库
+	assert.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		assert.Equal(t, "replica_primary", read(tx))
+		return nil
+	}))
+
+	// 副本不可用时使用主库
+	rep.available = 0
+	assert.Equal(t, "replica_primary", read(db))
+	rep.available = 1
+
+	// 写入后使用主库
+	assert.Nil(t, db.Create(&replicaItem{ID: 2, Name: "new"}).Error)
+	assert.True(t, routing.Primary())
+	assert.Equal(t, "replica_primary", read(db))
+}
+
+func TestReplicaStatementReuse(t *testing.T) {
+	primary := openReplicaTestDB(t, "replica_reuse_primary")
+	replicaGormDB := openReplicaTestDB(t, "replica_reuse_0")
+	replicaDB, _ := replicaGormDB.DB()
+	resolver := newResolver([]*replica{{name: "replica_reuse_0", db: replicaDB}}, 0, time.Hour, false)
+	defer resolver.close()
+	assert.Nil(t, primary.Use(resolver))
+	newDB := func() *gorm.DB {
+		return primary.WithContext(internal.WithDBRouting(context.Background(), &internal.DBRouting{}))
+	}
+	nameOf := func(db *gorm.DB) string {
+		var item replicaItem
+		assert.Nil(t, db.First(&item, 1).Error)
+		return item.Name
+	}
+
+	// 读取后复用同一个 Statement 的写入使用主库
+	var item replicaItem
+	q := newDB().Model(&replicaItem{}).Where("id = ?", 1)
+	assert.Nil(t, q.First(&item).Error)
+	assert.Equal(t, "replica_reuse_0", item.Name)
+	assert.Nil(t, q.Update("name", "updated").Error)
+	assert.Equal(t, "updated", nameOf(primary))
+	assert.Equal(t, "replica_reuse_0", nameOf(replicaGormDB))
+
+	// 读取后开启的事务使用主库
+	q = newDB().Where("id = ?", 1)
+	assert.Nil(t, q.First(&item).Error)
+	assert.Equal(t, "replica_reuse_0", item.Name)
+	assert.Nil(t, q.Transaction(func(tx *gorm.DB) error {
+		return tx.Model(&replicaItem{}).Where("id = ?", 1).Update("name", "in tx").Error
+	}))
+	assert.Equal(t, "in tx", nameOf(primary))
+	assert.Equal(t, "replica_reuse_0", nameOf(replicaGormDB))
+}
+
+func TestReplicationLag(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	defer sqlDB.Close()
+
+	mock.ExpectQuery("SHOW REPLICA STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Replica_IO_State", "Seconds_Behind_Source"}).AddRow("Waiting", "12"))
+	lag, err := replicationLag(context.Background(), sqlDB)
+	assert.Nil(t, err)
+	assert.Equal(t, 12*time.Second, lag)
+
+	mock.ExpectQuery("SHOW REPLICA STATUS").WillReturnError(assert.AnError)
+	mock.ExpectQuery("SHOW SLAVE STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Slave_IO_State", "Seconds_Behind_Master"}).AddRow("", nil))
+	_, err = replicationLag(context.Background(), sqlDB)
+	assert.EqualError(t, err, "replication is not running")
+
+	resolver := newResolver([]*replica{{name: "mock", db: sqlDB}}, time.Second, time.Second, true)
+	mock.ExpectPing()
+	mock.ExpectQuery("SHOW REPLICA STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}).AddRow("3"))
+	resolver.check(resolver.replicas[0])
+	assert.False(t, resolver.replicas[0].isAvailable())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,107 @@
+// 数据库 SQL 超时
+package utils
+
+/*
+	1.ConnectDB 与 ConnectNamedDB 安装超时插件，每条 SQL 在 Worker 的 Context 上附加超时时间，执行结束后释放
+	2.超时时间依次取 Repository.SetQueryTimeout、路由中间件 middleware.NewQueryTimeout、配置 query_timeout(毫秒)
+	3.Row、Rows 及 Scan 返回后才读取结果，超时时间覆盖结果的读取，到期后释放，回调结束即恢复 Statement 原有的 Context
+	4.自行创建的连接可以 db.Use(utils.NewQueryTimeout(timeout)) 安装
+
+	query_timeout: 3000
+*/
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	dt "DT-Go"
+)
+
+// QueryTimeoutPluginName .
+const QueryTimeoutPluginName = "dt:query_timeout"
+
+const queryTimeoutKey = "dt:query_timeout"
+
+// queryDeadline 附加超时前的 Context 与释放函数
+type queryDeadline struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// queryTimeout SQL 超时的 gorm 插件
+type queryTimeout struct {
+	timeout time.Duration
+}
+
+// NewQueryTimeout timeout 为 ctx 未设置超时时间时的默认值，0 表示不限制
+func NewQueryTimeout(timeout time.Duration) gorm.Plugin {
+	return &queryTimeout{timeout: timeout}
+}
+
+// Name .
+func (q *queryTimeout) Name() string {
+	return QueryTimeoutPluginName
+}
+
+// Initialize 在默认事务开启之后附加超时时间，在全部回调(含默认事务的提交)之后释放
+func (q *queryTimeout) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Query().Before("gorm:query").Register("dt:timeout:query", q.begin),
+		callback.Query().After("*").Register("dt:timeout:query_end", q.end),
+		callback.Create().Before("gorm:create").Register("dt:timeout:create", q.begin),
+		callback.Create().After("*").Register("dt:timeout:create_end", q.end),
+		callback.Update().Before("gorm:update").Register("dt:timeout:update", q.begin),
+		callback.Update().After("*").Register("dt:timeout:update_end", q.end),
+		callback.Delete().Before("gorm:delete").Register("dt:timeout:delete", q.begin),
+		callback.Delete().After("*").Register("dt:timeout:delete_end", q.end),
+		callback.Raw().Before("gorm:raw").Register("dt:timeout:raw", q.begin),
+		callback.Raw().After("*").Register("dt:timeout:raw_end", q.end),
+		callback.Row().Before("gorm:row").Register("dt:timeout:row", q.begin),
+		callback.Row().After("*").Register("dt:timeout:row_end", q.rowEnd),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// begin .
+func (q *queryTimeout) begin(db *gorm.DB) {
+	timeout, ok := dt.QueryTimeoutFrom(db.Statement.Context)
+	if !ok {
+		timeout = q.timeout
+	}
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+	db.Statement.Settings.Store(queryTimeoutKey, &queryDeadline{parent: db.Statement.Context, cancel: cancel})
+	db.Statement.Context = ctx
+}
+
+// end 释放超时并恢复原有的 Context，链式调用复用同一个 Statement，之后的 SQL 不能使用已释放的 Context
+func (q *queryTimeout) end(db *gorm.DB) {
+	if deadline := q.restore(db); deadline != nil {
+		deadline.cancel()
+	}
+}
+
+// rowEnd Row 的结果在回调之后读取，不提前释放，超时到期后自动释放
+func (q *queryTimeout) rowEnd(db *gorm.DB) {
+	q.restore(db)
+}
+
+// restore 恢复原有的 Context
+func (q *queryTimeout) restore(db *gorm.DB) *queryDeadline {
+	value, ok := db.Statement.Settings.LoadAndDelete(queryTimeoutKey)
+	if !ok {
+		return nil
+	}
+	deadline := value.(*queryDeadline)
+	db.Statement.Context = deadline.parent
+	return deadline
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kataras/golog"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"DT-Go/internal"
+)
+
+type recordLogger struct {
+	internal.Logger
+	logs   []string
+	fields []golog.Fields
+}
+
+func (l *recordLogger) Warnf(format string, args ...interface{}) {
+	l.record(format, args...)
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.record(format, args...)
+}
+
+func (l *recordLogger) record(format string, args ...interface{}) {
+	var fields golog.Fields
+	if n := len(args); n > 0 {
+		if f, ok := args[n-1].(golog.Fields); ok {
+			fields, args = f, args[:n-1]
+		}
+	}
+	l.logs = append(l.logs, fmt.Sprintf(format, args...)+" "+fmt.Sprint(fields["sql_caller"]))
+	l.fields = append(l.fields, fields)
+}
+
+type recordWorker struct {
+	internal.Worker
+	logger *recordLogger
+}
+
+func (w *recordWorker) Logger() internal.Logger {
+	return w.logger
+}
+
+func TestQueryTimeout(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	gdb, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: NewDBLogger(logger.Config{SlowThreshold: time.Millisecond, LogLevel: logger.Warn}),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, gdb.Use(NewQueryTimeout(time.Second)))
+
+	worker := &recordWorker{logger: &recordLogger{}}
+	ctx := internal.WithWorker(context.Background(), worker)
+
+	// ctx 的超时时间优先于默认值
+	mock.ExpectQuery("SELECT").WillDelayFor(50 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	var id int
+	err = gdb.WithContext(internal.WithQueryTimeout(ctx, 10*time.Millisecond)).Raw("SELECT id FROM items").Scan(&id).Error
+	assert.NotNil(t, err)
+	assert.Len(t, worker.logger.logs, 1)
+
+	// 慢 SQL 通过 Worker 的日志输出，附带调用方
+	mock.ExpectQuery("SELECT").WillDelayFor(5 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	assert.Nil(t, gdb.WithContext(ctx).Raw("SELECT id FROM items").Scan(&id).Error)
+	assert.Equal(t, 1, id)
+	assert.Len(t, worker.logger.logs, 2)
+	assert.Contains(t, worker.logger.logs[1], "SLOW SQL")
+	assert.Contains(t, worker.logger.logs[1], "utils.timeout_test.go")
+
+	// 默认事务提交后才释放超时
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	assert.Nil(t, gdb.WithContext(ctx).Table("items").Create(map[string]interface{}{"id": 1}).Error)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryTimeoutStatementReuse(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	gdb, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, gdb.Use(NewQueryTimeout(time.Second)))
+
+	ctx := internal.WithWorker(context.Background(), &recordWorker{logger: &recordLogger{}})
+	q := gdb.WithContext(ctx).Table("items").Where("id > ?", 0)
+
+	// 链式调用复用同一个 Statement，Count 释放的超时不影响之后的 Find
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	var count int64
+	assert.Nil(t, q.Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	var ids []int
+	assert.Nil(t, q.Pluck("id", &ids).Error)
+	assert.Equal(t, []int{1}, ids)
+	assert.Nil(t, ctx.Err())
+
+	// Row 的结果在回调之后读取，回调结束后同样恢复原有的 Context
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	var id int
+	assert.Nil(t, gdb.WithContext(ctx).Table("items").Select("id").Row().Scan(&id))
+	assert.Equal(t, 2, id)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLCallerField(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	gdb, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: NewDBLogger(logger.Config{SlowThreshold: time.Millisecond, LogLevel: logger.Warn}),
+	})
+	assert.Nil(t, err)
+
+	worker := &recordWorker{logger: &recordLogger{}}
+	ctx := internal.WithWorker(context.Background(), worker)
+	mock.ExpectQuery("SELECT").WillDelayFor(5 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	var id int
+	assert.Nil(t, gdb.WithContext(ctx).Raw("SELECT id FROM items").Scan(&id).Error)
+
+	if assert.Len(t, worker.logger.fields, 1) {
+		// Worker 日志会追加自己的 caller，SQL 的调用方使用 sql_caller 避免被覆盖
+		assert.Contains(t, worker.logger.fields[0]["sql_caller"], "utils.timeout_test.go")
+		assert.NotContains(t, worker.logger.fields[0], "caller")
+	}
+}